@@ -0,0 +1,262 @@
+package make
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// ArchiveTargetNamePrefix is the prefix all ArchiveTargets
+// will have in theire name.
+const ArchiveTargetNamePrefix = "archive_"
+
+// DefaultArchiveName is the default name of archives
+// without the extension.
+const DefaultArchiveName = "{{.BaseName}}_{{.Version}}_{{.OS}}_{{.Arch}}"
+
+// ArchiveFormat represents the format of an archive.
+type ArchiveFormat string
+
+const (
+	// ArchiveTarGz represents gzip compressed tarballs.
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+	// ArchiveZip represents zip files.
+	ArchiveZip ArchiveFormat = "zip"
+)
+
+// ArchiveBinary is an executable that can be archived, e. g.
+// a BuildTarget or a UniversalBinaryTarget.
+type ArchiveBinary interface {
+	NamedOutputTarget
+
+	// TargetPlatform returns the Platform of the executable.
+	TargetPlatform() *Platform
+}
+
+// ArchiveTarget creates an archive containing the outputs of
+// binaries of the same Platform and additional files, e. g.
+// the README and LICENSE. The binaries are executed as
+// dependencies. All files are stored at the top level of the
+// archive.
+type ArchiveTarget struct {
+	Format ArchiveFormat
+	// ArchiveName is the templated name of the archive without
+	// the extension. It will receive TemplateData as ".". Use
+	// NameTemplate to parse it. Defaults to DefaultArchiveName.
+	ArchiveName *template.Template
+	// BaseName is passed to the ArchiveName template.
+	BaseName string
+	// Version is passed to the ArchiveName template. Defaults to
+	// the Version of the first binary if it is a BuildTarget or
+	// a UniversalBinaryTarget.
+	Version Version
+
+	// Binaries are the targets whose outputs are archived.
+	Binaries []ArchiveBinary
+	// Files contains the paths of additional files.
+	Files []string
+
+	// OutputDir is the directory the archive is written to.
+	// If empty it defaults to the output directory of the Suite.
+	OutputDir string
+
+	// defaultOutputDir is the output directory of the Suite
+	// the target is registered with.
+	defaultOutputDir string
+}
+
+// MultiFormat returns one ArchiveTarget based on the
+// current archive target for each format.
+func (t *ArchiveTarget) MultiFormat(formats ...ArchiveFormat) []*ArchiveTarget {
+	newTargets := make([]*ArchiveTarget, len(formats))
+	for i, format := range formats {
+		copy := *t
+		copy.Format = format
+		newTargets[i] = &copy
+	}
+	return newTargets
+}
+
+// Execute builds the binaries and creates the archive.
+func (t *ArchiveTarget) Execute(suite *Suite) error {
+	deps := make([]Target, len(t.Binaries))
+	for i, binary := range t.Binaries {
+		deps[i] = binary
+	}
+	if err := suite.executeDependencies(deps); err != nil {
+		return err
+	}
+
+	filename, err := t.OutputPath()
+	if err != nil {
+		return err
+	}
+	files := make([]string, 0, len(t.Binaries)+len(t.Files))
+	for _, binary := range t.Binaries {
+		files = append(files, binary.OutputName())
+	}
+	files = append(files, t.Files...)
+
+	fmt.Println("Creating archive:", filename)
+	err = writeFileAtomically(filename, 0644, func(w io.Writer) error {
+		switch t.Format {
+		case ArchiveTarGz:
+			return writeTarGzArchive(w, files)
+		case ArchiveZip:
+			return writeZipArchive(w, files)
+		}
+		return fmt.Errorf("invalid archive format \"%s\"", t.Format)
+	})
+	if err != nil {
+		return fmt.Errorf("could not create %s archive: %v", t.Format, err)
+	}
+
+	suite.AddArtifact(&Artifact{
+		Path:     filename,
+		Kind:     ArtifactArchive,
+		Platform: t.TargetPlatform(),
+		Target:   t.Name(),
+	})
+	return nil
+}
+
+func writeTarGzArchive(w io.Writer, files []string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	mtime := buildDate()
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		e := &packageEntry{source: file, mode: info.Mode().Perm(), size: info.Size()}
+		if err := writeTarFile(tw, filepath.Base(file), e, mtime, ioutil.Discard); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeZipArchive(w io.Writer, files []string) error {
+	zw := zip.NewWriter(w)
+	mtime := buildDate()
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		header := &zip.FileHeader{
+			Name:     filepath.Base(file),
+			Method:   zip.Deflate,
+			Modified: mtime,
+		}
+		header.SetMode(info.Mode().Perm())
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(fw, file); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// OutputName returns the name of the archive including the
+// OutputDir. If the ArchiveName template is broken an empty
+// string is returned, use OutputPath to get the error.
+func (t *ArchiveTarget) OutputName() string {
+	name, _ := t.OutputPath()
+	return name
+}
+
+// OutputPath returns the name of the archive including the
+// OutputDir or an error if the ArchiveName template could
+// not be executed.
+func (t *ArchiveTarget) OutputPath() (string, error) {
+	tmpl := t.ArchiveName
+	if tmpl == nil {
+		tmpl = template.Must(NameTemplate(DefaultArchiveName))
+	}
+
+	buf := &bytes.Buffer{}
+	err := tmpl.Execute(buf, NewTemplateData(t.TargetPlatform(), t.version(), t.BaseName))
+	if err != nil {
+		return "", fmt.Errorf("invalid archive name: %v", err)
+	}
+	if buf.Len() == 0 {
+		return "", fmt.Errorf("archive name is empty")
+	}
+	return filepath.Join(t.outputDir(), buf.String()+"."+string(t.Format)), nil
+}
+
+func (t *ArchiveTarget) version() Version {
+	if t.Version != nil || len(t.Binaries) == 0 {
+		return t.Version
+	}
+	switch binary := t.Binaries[0].(type) {
+	case *BuildTarget:
+		return binary.Version
+	case *UniversalBinaryTarget:
+		return binary.Version
+	}
+	return nil
+}
+
+// Validate returns an error if the format is unknown or the
+// Binaries are missing or of different Platforms.
+func (t *ArchiveTarget) Validate() error {
+	if t.Format != ArchiveTarGz && t.Format != ArchiveZip {
+		return fmt.Errorf("invalid archive format \"%s\"", t.Format)
+	}
+	if len(t.Binaries) == 0 || t.Binaries[0].TargetPlatform() == nil {
+		return fmt.Errorf("archive has no binaries")
+	}
+	for _, binary := range t.Binaries[1:] {
+		if p := binary.TargetPlatform(); p == nil || !p.Equals(t.Binaries[0].TargetPlatform()) {
+			return fmt.Errorf("archive contains binaries of different platforms")
+		}
+	}
+	_, err := t.OutputPath()
+	return err
+}
+
+func (t *ArchiveTarget) setDefaultOutputDir(suite *Suite) error {
+	var err error
+	t.defaultOutputDir, err = suite.OutputDir(t.TargetPlatform(), t.version())
+	return err
+}
+
+// outputDir returns the OutputDir or, if it is empty, the
+// output directory of the Suite.
+func (t *ArchiveTarget) outputDir() string {
+	if t.OutputDir != "" {
+		return t.OutputDir
+	}
+	return t.defaultOutputDir
+}
+
+// TargetPlatform returns the Platform of the Binaries.
+func (t *ArchiveTarget) TargetPlatform() *Platform {
+	if len(t.Binaries) == 0 {
+		return nil
+	}
+	return t.Binaries[0].TargetPlatform()
+}
+
+// Name returns the name of this Target.
+// The name will consist of the ArchiveTargetNamePrefix
+// followed by the format and the platform.
+func (t *ArchiveTarget) Name() string {
+	return ArchiveTargetNamePrefix + string(t.Format) + "_" + t.TargetPlatform().String()
+}
@@ -0,0 +1,160 @@
+package make
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// binaryTarget is an ArchiveBinary with a fixed output.
+type binaryTarget struct {
+	fileTarget
+	platform *Platform
+}
+
+func (t *binaryTarget) TargetPlatform() *Platform { return t.platform }
+
+// readZip returns the modes and contents of the files in the
+// zip archive.
+func readZip(t *testing.T, data []byte) (map[string]os.FileMode, map[string]string) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	modes := make(map[string]os.FileMode)
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		modes[f.Name] = f.Mode().Perm()
+		contents[f.Name] = string(content)
+	}
+	return modes, contents
+}
+
+func TestArchiveTarget(t *testing.T) {
+	tests := []struct {
+		format   ArchiveFormat
+		platform *Platform
+		version  Version
+		name     string
+	}{
+		{format: ArchiveTarGz, platform: LinuxAmd64, version: BasicVersion("1.2.3"), name: "app_1.2.3_linux_amd64.tar.gz"},
+		{format: ArchiveZip, platform: DarwinUniversal, version: BasicVersion("2.0.0"), name: "app_2.0.0_darwin_universal.zip"},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.format), func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"README.md": "readme",
+			})
+			if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, "bin", "app"), []byte("binary"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			chdir(t, dir)
+			t.Setenv("SOURCE_DATE_EPOCH", "1600000000")
+
+			target := &ArchiveTarget{
+				Format:    test.format,
+				BaseName:  "app",
+				Version:   test.version,
+				Binaries:  []ArchiveBinary{&binaryTarget{fileTarget{"build", filepath.Join("bin", "app")}, test.platform}},
+				Files:     []string{"README.md"},
+				OutputDir: "dist",
+			}
+			if err := target.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			suite := NewBuildSuite(nil)
+			if err := target.Execute(suite); err != nil {
+				t.Fatal(err)
+			}
+
+			filename := filepath.Join("dist", test.name)
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			modes := make(map[string]os.FileMode)
+			contents := make(map[string]string)
+			if test.format == ArchiveZip {
+				modes, contents = readZip(t, data)
+			} else {
+				headers, tarContents := readTar(t, gunzip(t, data))
+				for name, header := range headers {
+					modes[name] = os.FileMode(header.Mode).Perm()
+					if !header.ModTime.Equal(buildDate()) {
+						t.Errorf("%s: expected the build date as modification time, got %v", name, header.ModTime)
+					}
+				}
+				contents = tarContents
+			}
+			if !reflect.DeepEqual(contents, map[string]string{"app": "binary", "README.md": "readme"}) {
+				t.Errorf("unexpected contents %v", contents)
+			}
+			if modes["app"] != 0755 || modes["README.md"] != 0644 {
+				t.Errorf("unexpected modes %v", modes)
+			}
+
+			artifacts := suite.Artifacts()
+			if len(artifacts) != 1 || artifacts[0].Path != filename || artifacts[0].Kind != ArtifactArchive || artifacts[0].Platform != test.platform {
+				t.Errorf("unexpected artifacts %v", artifacts)
+			}
+		})
+	}
+}
+
+func TestArchiveTargetVersion(t *testing.T) {
+	tests := []struct {
+		target  *ArchiveTarget
+		version Version
+	}{
+		{target: &ArchiveTarget{}},
+		{target: &ArchiveTarget{Binaries: []ArchiveBinary{&BuildTarget{Version: BasicVersion("1.0.0")}}}, version: BasicVersion("1.0.0")},
+		{target: &ArchiveTarget{Binaries: []ArchiveBinary{&UniversalBinaryTarget{Version: BasicVersion("2.0.0")}}}, version: BasicVersion("2.0.0")},
+		{target: &ArchiveTarget{Version: BasicVersion("3.0.0"), Binaries: []ArchiveBinary{&BuildTarget{Version: BasicVersion("1.0.0")}}}, version: BasicVersion("3.0.0")},
+		{target: &ArchiveTarget{Binaries: []ArchiveBinary{&binaryTarget{platform: LinuxAmd64}}}},
+	}
+	for _, test := range tests {
+		if v := test.target.version(); v != test.version {
+			t.Errorf("expected version %v, got %v", test.version, v)
+		}
+	}
+}
+
+func TestArchiveTargetValidate(t *testing.T) {
+	linux := &BuildTarget{Platform: LinuxAmd64}
+	tests := []struct {
+		name    string
+		target  *ArchiveTarget
+		wantErr string
+	}{
+		{name: "valid", target: &ArchiveTarget{Format: ArchiveZip, BaseName: "app", Binaries: []ArchiveBinary{linux, &BuildTarget{Platform: LinuxAmd64}}}},
+		{name: "universal binary", target: &ArchiveTarget{Format: ArchiveTarGz, BaseName: "app", Binaries: []ArchiveBinary{&UniversalBinaryTarget{}}}},
+		{name: "invalid format", target: &ArchiveTarget{Format: "rar", Binaries: []ArchiveBinary{linux}}, wantErr: "invalid archive format"},
+		{name: "no binaries", target: &ArchiveTarget{Format: ArchiveZip}, wantErr: "archive has no binaries"},
+		{name: "no platform", target: &ArchiveTarget{Format: ArchiveZip, Binaries: []ArchiveBinary{&BuildTarget{}}}, wantErr: "archive has no binaries"},
+		{name: "different platforms", target: &ArchiveTarget{Format: ArchiveZip, Binaries: []ArchiveBinary{linux, &UniversalBinaryTarget{}}}, wantErr: "different platforms"},
+		{name: "broken name", target: &ArchiveTarget{Format: ArchiveZip, ArchiveName: mustTemplate(t, "{{.Missing}}"), Binaries: []ArchiveBinary{linux}}, wantErr: "invalid archive name"},
+		{name: "empty name", target: &ArchiveTarget{Format: ArchiveZip, ArchiveName: mustTemplate(t, ""), Binaries: []ArchiveBinary{linux}}, wantErr: "archive name is empty"},
+	}
+	for _, test := range tests {
+		checkError(t, test.target.Validate(), test.wantErr)
+	}
+}
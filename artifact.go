@@ -0,0 +1,95 @@
+package make
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ArtifactKind describes the kind of an Artifact.
+type ArtifactKind string
+
+const (
+	// ArtifactExecutable is an executable built by a BuildTarget.
+	ArtifactExecutable ArtifactKind = "executable"
+	// ArtifactPackage is a Linux package created by a PackageTarget.
+	ArtifactPackage ArtifactKind = "package"
+	// ArtifactArchive is an archive created by an ArchiveTarget.
+	ArtifactArchive ArtifactKind = "archive"
+	// ArtifactSBOM is a software bill of materials created by
+	// an SBOMTarget.
+	ArtifactSBOM ArtifactKind = "sbom"
+	// ArtifactSignature is a detached signature created by
+	// a SignTarget.
+	ArtifactSignature ArtifactKind = "signature"
+	// ArtifactReleaseNotes are release notes written by
+	// a ChangelogTarget.
+	ArtifactReleaseNotes ArtifactKind = "release-notes"
+)
+
+// Artifact is a file produced by a Target.
+type Artifact struct {
+	// Path is the path of the produced file.
+	Path string
+	Kind ArtifactKind
+	// Platform is the optional Platform the file was produced for.
+	Platform *Platform
+	// Target is the name of the producing Target.
+	Target string
+}
+
+// AddArtifact records a file produced by a Target. An Artifact
+// with the same Path replaces the previously recorded one.
+func (s *Suite) AddArtifact(artifact *Artifact) {
+	s.artifactsMutex.Lock()
+	defer s.artifactsMutex.Unlock()
+
+	for i, a := range s.artifacts {
+		if a.Path == artifact.Path {
+			s.artifacts[i] = artifact
+			return
+		}
+	}
+	s.artifacts = append(s.artifacts, artifact)
+}
+
+// Artifacts returns all files produced by the Targets executed
+// by this suite in the order they were produced.
+func (s *Suite) Artifacts() []*Artifact {
+	s.artifactsMutex.Lock()
+	defer s.artifactsMutex.Unlock()
+
+	artifacts := make([]*Artifact, len(s.artifacts))
+	copy(artifacts, s.artifacts)
+	return artifacts
+}
+
+// writeFileAtomically creates the file by writing to a temporary
+// file in the same directory first and renaming it afterwards.
+// Missing directories are created.
+func writeFileAtomically(filename string, perm os.FileMode, write func(w io.Writer) error) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = write(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,88 @@
+package make
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSuiteAddArtifact(t *testing.T) {
+	suite := NewBuildSuite(nil)
+	suite.AddArtifact(&Artifact{Path: "a", Kind: ArtifactExecutable})
+	suite.AddArtifact(&Artifact{Path: "b", Kind: ArtifactArchive})
+	suite.AddArtifact(&Artifact{Path: "a", Kind: ArtifactPackage})
+
+	expected := []*Artifact{
+		{Path: "a", Kind: ArtifactPackage},
+		{Path: "b", Kind: ArtifactArchive},
+	}
+	artifacts := suite.Artifacts()
+	if !reflect.DeepEqual(artifacts, expected) {
+		t.Errorf("expected %v, got %v", expected, artifacts)
+	}
+
+	artifacts[0] = nil
+	if suite.Artifacts()[0] == nil {
+		t.Errorf("the returned slice must be a copy")
+	}
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		write    func(w io.Writer) error
+		content  string
+		wantErr  string
+	}{
+		{
+			name:    "new file",
+			write:   func(w io.Writer) error { _, err := io.WriteString(w, "new"); return err },
+			content: "new",
+		},
+		{
+			name:     "replaced file",
+			existing: "old",
+			write:    func(w io.Writer) error { _, err := io.WriteString(w, "new"); return err },
+			content:  "new",
+		},
+		{
+			name:     "failed write",
+			existing: "old",
+			write: func(w io.Writer) error {
+				io.WriteString(w, "half")
+				return fmt.Errorf("write failed")
+			},
+			content: "old",
+			wantErr: "write failed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "sub", "file")
+			if test.existing != "" {
+				writeFiles(t, dir, map[string]string{"sub/file": test.existing})
+			}
+
+			err := writeFileAtomically(filename, 0600, test.write)
+			checkError(t, err, test.wantErr)
+
+			content, _ := ioutil.ReadFile(filename)
+			if string(content) != test.content {
+				t.Errorf("expected content %q, got %q", test.content, content)
+			}
+			if files := listFiles(t, filepath.Join(dir, "sub")); !reflect.DeepEqual(files, []string{"file"}) {
+				t.Errorf("temporary files were left behind: %v", files)
+			}
+			if info, err := os.Stat(filename); err == nil && test.wantErr == "" && info.Mode().Perm() != 0600 {
+				t.Errorf("expected mode 0600, got %v", info.Mode())
+			}
+		})
+	}
+}
@@ -4,11 +4,16 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 )
 
 // BuildTargetNamePrefix is the prefix all BuildTargets
@@ -20,24 +25,96 @@ const BuildTargetNamePrefix = "build_"
 // working directory.
 type BuildTarget struct {
 	// ExecutableName defines the templated name of the resulting
-	// executable. It will receive the Platform as ".", so take
-	// a look at the Platform struct for usable variables.
+	// executable. It will receive TemplateData as ".", so take
+	// a look at the TemplateData and Platform structs for usable
+	// variables. Use NameTemplate to parse it in order to use the
+	// functions of TemplateFuncs.
 	ExecutableName *template.Template
+	// BaseName is the optional base name of the executable
+	// passed to the ExecutableName template.
+	BaseName string
+	// Binary distinguishes the BuildTargets of multiple executables
+	// built for the same Platform. If set it is part of the name.
+	Binary string
+	// Package is the main package to build, e. g. "./cmd/server".
+	// If empty the package in the working directory is built.
+	Package string
+	// OutputDir is the directory the executable is written to.
+	// If empty it defaults to the output directory of the Suite
+	// the target is registered with. Missing directories are
+	// created.
+	OutputDir string
 
 	Version Version
 	// Full name of the Variable holding the version string.
 	// E. g. "main.version".
 	VersionVariableName string
+	// Variables maps full variable names to the values they are
+	// set to via -ldflags -X. The values are templates receiving
+	// TemplateData as ".", e. g. "{{.Git.Commit}}".
+	Variables map[string]string
 
 	Platform             *Platform
 	AdditionalBuildFlags []string
 
+	// Reproducible makes the executable independent of the build
+	// environment. Paths are trimmed, the build ID is cleared, cgo
+	// is disabled, only a minimal set of environment variables is
+	// passed to go build and SOURCE_DATE_EPOCH is set to the time
+	// of the last commit unless it is already set.
+	Reproducible bool
+	// BuildVCS is passed to go build as -buildvcs if not empty,
+	// e. g. "false" to omit version control information.
+	BuildVCS string
+
+	// WindowsResources are embedded into the executable if the
+	// Platform is a Windows platform. The resource file is written
+	// to the directory of the Package before building and removed
+	// afterwards. Builds of the same package directory with
+	// resources run one after another.
+	WindowsResources *WindowsResources
+
+	// Dependencies will be executed sequentially before building.
+	// NamedTargets will only be executed once per Suite.Execute,
+	// even if they are dependencies of multiple BuildTargets.
+	Dependencies []Target
+
 	// Where to redirect the build commands stdout. If nil
 	// stdout will be redirected to this precesses stdout.
 	Stdout io.Writer
 	// Where to redirect the build commands stderr. If nil
 	// stderr will be redirected to this precesses stderr.
 	Stderr io.Writer
+
+	// buildCache overrides GOCACHE if not empty.
+	buildCache string
+	// dir is the working directory of go build if not empty.
+	dir string
+
+	// defaultOutputDir is the output directory of the Suite
+	// the target is registered with.
+	defaultOutputDir string
+}
+
+// reproducibleEnv contains the environment variables that are
+// passed to go build in reproducible mode. They select the same
+// toolchain, target and module configuration as a normal build or
+// are needed to reach the module proxy. Other variables, e. g.
+// CGO_CFLAGS or CC, are dropped as they are not part of the
+// sources and CGO is disabled anyway.
+var reproducibleEnv = []string{
+	// System
+	"PATH", "HOME", "USERPROFILE", "SYSTEMROOT", "LOCALAPPDATA", "APPDATA", "TMPDIR", "TEMP", "TMP",
+	// Toolchain and caches
+	"GOROOT", "GOPATH", "GOCACHE", "GOMODCACHE", "GOTOOLCHAIN", "GOENV", "GOEXPERIMENT",
+	// Microarchitecture levels
+	"GOARM", "GOARM64", "GOAMD64", "GO386", "GOMIPS", "GOMIPS64", "GOPPC64", "GORISCV64", "GOWASM",
+	// Module configuration
+	"GOFLAGS", "GOWORK", "GO111MODULE", "GOVCS",
+	"GOPROXY", "GOPRIVATE", "GONOPROXY", "GONOSUMDB", "GOSUMDB", "GOINSECURE", "GOAUTH",
+	// Network
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+	"SSL_CERT_FILE", "SSL_CERT_DIR",
 }
 
 // Copy copies a BuildTarget.
@@ -73,29 +150,175 @@ func ConvertBuildTargetSlice(buildTargets []*BuildTarget) []Target {
 }
 
 // Execute build the executable.
+//
+// The executable is built to a temporary file in the output
+// directory first and then renamed, so the output file is
+// never left half-written.
 func (t *BuildTarget) Execute(suite *Suite) error {
 	if err := suite.CheckPlatform(t.Platform); err != nil {
 		return err
 	}
 
-	executableName := t.OutputName()
+	if err := suite.executeDependencies(t.Dependencies); err != nil {
+		return err
+	}
+
+	executableName, err := t.OutputPath()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(executableName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(executableName)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	date, err := t.date()
+	if err != nil {
+		return err
+	}
+	data := NewTemplateData(t.Platform, t.Version, t.BaseName)
+	data.Date = date
+	env := t.environment(date)
+	cmd, err := t.makeCommand(tmp.Name(), env, data)
+	if err != nil {
+		return err
+	}
+
+	if t.WindowsResources != nil && t.Platform.OS == Windows {
+		// go build links all .syso files of the package directory,
+		// so other builds of the same directory have to wait.
+		dir, err := t.packageDir(cmd.Env)
+		if err != nil {
+			return err
+		}
+		lock, _ := sysoLocks.LoadOrStore(dir, &sync.Mutex{})
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
 
-	cmd := t.makeCommand(executableName)
+		syso, err := t.WindowsResources.writeSyso(dir, t.Platform, t.Binary, t.Version, filepath.Base(executableName))
+		if err != nil {
+			return fmt.Errorf("could not write windows resources: %v", err)
+		}
+		defer os.Remove(syso)
+	}
 
 	fmt.Println("Building binary:", executableName)
 	if err := cmd.Run(); err != nil {
-		log.Fatalln("Error running go build:", err)
+		return fmt.Errorf("error running go build for %s: %v", t.Platform, err)
+	}
+	if err := os.Rename(tmp.Name(), executableName); err != nil {
+		return err
 	}
+
+	suite.AddArtifact(&Artifact{
+		Path:     executableName,
+		Kind:     ArtifactExecutable,
+		Platform: t.Platform,
+		Target:   t.Name(),
+	})
 	return nil
 }
 
-func (t *BuildTarget) makeCommand(executableName string) (cmd *exec.Cmd) {
+// sysoLocks contains a mutex for each package directory
+// windows resources are written to.
+var sysoLocks sync.Map
+
+// packageDir returns the directory of the Package.
+func (t *BuildTarget) packageDir(env []string) (string, error) {
+	pkg := t.Package
+	if pkg == "" {
+		pkg = "."
+	}
+	cmd := exec.Command("go", "list", "-f", "{{.Dir}}", pkg)
+	cmd.Dir = t.dir
+	cmd.Env = env
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("could not find the directory of package %s: %v\n%s", pkg, err, stderr)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// date returns the build date. Reproducible builds use the
+// SOURCE_DATE_EPOCH or, if it is not set, the time of the last
+// commit.
+func (t *BuildTarget) date() (time.Time, error) {
+	if !t.Reproducible {
+		return buildDate(), nil
+	}
+
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		var err error
+		epoch, err = gitOutput(t.dir, "log", "-1", "--format=%ct")
+		if err != nil {
+			return time.Time{}, fmt.Errorf("reproducible builds need SOURCE_DATE_EPOCH or a git commit: %v", err)
+		}
+	}
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH \"%s\"", epoch)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// environment returns the environment of go build. In
+// reproducible mode SOURCE_DATE_EPOCH is set to the date.
+func (t *BuildTarget) environment(date time.Time) []string {
+	env := os.Environ()
+	if t.Reproducible {
+		env = make([]string, 0, len(reproducibleEnv))
+		for _, key := range reproducibleEnv {
+			if value, ok := os.LookupEnv(key); ok {
+				env = setEnv(env, key, value)
+			}
+		}
+		env = setEnv(env, "CGO_ENABLED", "0")
+		env = setEnv(env, "SOURCE_DATE_EPOCH", strconv.FormatInt(date.Unix(), 10))
+	}
+	if t.buildCache != "" {
+		env = setEnv(env, "GOCACHE", t.buildCache)
+	}
+	return env
+}
+
+func (t *BuildTarget) makeCommand(executableName string, env []string, data *TemplateData) (cmd *exec.Cmd, err error) {
+	args := []string{"build"}
+	ldflags := make([]string, 0)
 	if t.VersionVariableName != "" && t.Version != nil {
-		ldflags := fmt.Sprintf("-ldflags=-X %s=%s", t.VersionVariableName, t.Version)
-		cmd = exec.Command("go", "build", ldflags, "-o", executableName)
-	} else {
-		cmd = exec.Command("go", "build", "-o", executableName)
+		ldflags = append(ldflags, fmt.Sprintf("-X %s=%s", t.VersionVariableName, t.Version))
 	}
+	variables, err := t.variables(data)
+	if err != nil {
+		return nil, err
+	}
+	ldflags = append(ldflags, variables...)
+	if t.Reproducible {
+		args = append(args, "-trimpath")
+		ldflags = append(ldflags, "-buildid=")
+	}
+	if len(ldflags) > 0 {
+		args = append(args, "-ldflags="+strings.Join(ldflags, " "))
+	}
+	if t.BuildVCS != "" {
+		args = append(args, "-buildvcs="+t.BuildVCS)
+	}
+	args = append(args, t.AdditionalBuildFlags...)
+	args = append(args, "-o", executableName)
+	if t.Package != "" {
+		args = append(args, t.Package)
+	}
+	cmd = exec.Command("go", args...)
+	cmd.Dir = t.dir
 
 	if t.Stdout != nil {
 		cmd.Stdout = t.Stdout
@@ -108,25 +331,115 @@ func (t *BuildTarget) makeCommand(executableName string) (cmd *exec.Cmd) {
 		cmd.Stderr = os.Stderr
 	}
 
-	cmd.Env = os.Environ()
+	cmd.Env = env
 	cmd.Env = setEnv(cmd.Env, "GOOS", t.Platform.OS.String())
 	cmd.Env = setEnv(cmd.Env, "GOARCH", t.Platform.Arch.String())
 
-	return cmd
+	return cmd, nil
 }
 
-// OutputName returns the name of the output file.
+// variables returns the -X flags setting the Variables to
+// their templates executed with data, sorted by the variable
+// names.
+func (t *BuildTarget) variables(data *TemplateData) ([]string, error) {
+	names := make([]string, 0, len(t.Variables))
+	for name := range t.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	flags := make([]string, len(names))
+	for i, name := range names {
+		value, err := executeTemplate("variable", t.Variables[name], data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of variable %s: %v", name, err)
+		}
+		flag := name + "=" + value
+		// go build splits the flags at spaces unless the field is
+		// quoted. Quotes can not be escaped.
+		if strings.ContainsAny(flag, " \t\n'\"") {
+			quote := "'"
+			if strings.Contains(flag, quote) {
+				quote = "\""
+			}
+			if strings.Contains(flag, quote) {
+				return nil, fmt.Errorf("value of variable %s contains single and double quotes", name)
+			}
+			flag = quote + flag + quote
+		}
+		flags[i] = "-X " + flag
+	}
+	return flags, nil
+}
+
+// OutputName returns the name of the output file including
+// the OutputDir. If the ExecutableName template is broken an
+// empty string is returned, use OutputPath to get the error.
 func (t *BuildTarget) OutputName() string {
+	name, _ := t.OutputPath()
+	return name
+}
+
+// OutputPath returns the name of the output file including
+// the OutputDir or an error if the ExecutableName template
+// could not be executed.
+func (t *BuildTarget) OutputPath() (string, error) {
+	if t.ExecutableName == nil {
+		return "", fmt.Errorf("no executable name template")
+	}
+
 	buf := &bytes.Buffer{}
-	err := t.ExecutableName.Execute(buf, t.Platform)
+	err := t.ExecutableName.Execute(buf, NewTemplateData(t.Platform, t.Version, t.BaseName))
 	if err != nil {
-		panic(err)
+		return "", fmt.Errorf("invalid executable name: %v", err)
+	}
+	if buf.Len() == 0 {
+		return "", fmt.Errorf("executable name is empty")
+	}
+	return filepath.Join(t.outputDir(), buf.String()), nil
+}
+
+// Validate returns an error if the Platform or the
+// ExecutableName template is missing, if the Platform is
+// DarwinUniversal or if the template could not be executed.
+func (t *BuildTarget) Validate() error {
+	if t.Platform == nil {
+		return fmt.Errorf("build target has no platform")
+	}
+	if t.Platform.Arch == Universal {
+		return fmt.Errorf("build target can not build %s directly, use a UniversalBinaryTarget", t.Platform)
+	}
+	_, err := t.OutputPath()
+	return err
+}
+
+func (t *BuildTarget) setDefaultOutputDir(suite *Suite) error {
+	var err error
+	t.defaultOutputDir, err = suite.OutputDir(t.Platform, t.Version)
+	return err
+}
+
+// outputDir returns the OutputDir or, if it is empty, the
+// output directory of the Suite.
+func (t *BuildTarget) outputDir() string {
+	if t.OutputDir != "" {
+		return t.OutputDir
 	}
-	return buf.String()
+	return t.defaultOutputDir
 }
 
-// Name returns the name of this Target.
+// TargetPlatform returns the Platform of this Target.
+func (t *BuildTarget) TargetPlatform() *Platform {
+	return t.Platform
+}
+
+// Name returns the name of this Target. The name will consist
+// of the BuildTargetNamePrefix followed by the Binary, if set,
+// and the platform.
 func (t *BuildTarget) Name() string {
+	if t.Binary != "" {
+		return BuildTargetNamePrefix + t.Binary + "_" + t.Platform.String()
+	}
 	return BuildTargetNamePrefix + t.Platform.String()
 }
 
@@ -0,0 +1,236 @@
+package make
+
+import (
+	"bytes"
+	"debug/pe"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	texttemplate "text/template"
+)
+
+// nativePlatform returns the Platform of the test process.
+func nativePlatform(t *testing.T) *Platform {
+	t.Helper()
+	p, err := ParsePlatform("native", "native")
+	if err != nil {
+		t.Skip("unsupported platform:", err)
+	}
+	return p
+}
+
+// writeMainModule writes a module printing its version
+// variables to dir.
+func writeMainModule(t *testing.T, dir string) {
+	t.Helper()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.16\n",
+		"main.go": `package main
+
+import "fmt"
+
+var version, commit string
+
+func main() {
+	fmt.Printf("%s %s", version, commit)
+}
+`,
+	})
+}
+
+func TestBuildTargetVariables(t *testing.T) {
+	tests := []struct {
+		variables map[string]string
+		flags     []string
+		wantErr   string
+	}{
+		{
+			variables: map[string]string{"main.b": "{{.OS}}", "main.a": "{{.Version}}"},
+			flags:     []string{"-X main.a=1.0.0", "-X main.b=linux"},
+		},
+		{
+			variables: map[string]string{"main.a": "hello world"},
+			flags:     []string{"-X 'main.a=hello world'"},
+		},
+		{
+			variables: map[string]string{"main.a": "it's"},
+			flags:     []string{"-X \"main.a=it's\""},
+		},
+		{
+			variables: map[string]string{"main.a": "\"it's\""},
+			wantErr:   "contains single and double quotes",
+		},
+		{
+			variables: map[string]string{"main.a": "{{.Missing}}"},
+			wantErr:   "invalid value of variable main.a",
+		},
+	}
+	for _, test := range tests {
+		target := &BuildTarget{Variables: test.variables, Platform: LinuxAmd64, Version: BasicVersion("1.0.0")}
+		flags, err := target.variables(NewTemplateData(target.Platform, target.Version, ""))
+		checkError(t, err, test.wantErr)
+		if err == nil && !reflect.DeepEqual(flags, test.flags) {
+			t.Errorf("expected %v, got %v", test.flags, flags)
+		}
+	}
+}
+
+func TestBuildTargetName(t *testing.T) {
+	tests := []struct {
+		target NamedTarget
+		name   string
+	}{
+		{&BuildTarget{Platform: LinuxAmd64}, "build_linux_amd64"},
+		{&BuildTarget{Platform: LinuxAmd64, Binary: "server"}, "build_server_linux_amd64"},
+		{&UniversalBinaryTarget{}, "build_darwin_universal"},
+		{NewUniversalBinaryTarget([]*BuildTarget{{Platform: DarwinAmd64, Binary: "server"}}), "build_server_darwin_universal"},
+	}
+	for _, test := range tests {
+		if name := test.target.Name(); name != test.name {
+			t.Errorf("expected %s, got %s", test.name, name)
+		}
+	}
+}
+
+func TestBuildTargetWindowsResources(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":          "module example.com/app\n\ngo 1.16\n",
+		"cmd/app/main.go": "package main\n\nfunc main() {}\n",
+	})
+	chdir(t, dir)
+
+	// Both binaries are built from the same package in parallel
+	// and have to end up with their own resources.
+	suite := NewBuildSuite(PlatformSet{WindowsAmd64})
+	suite.OutputRoot = "dist"
+	targets := make([]Target, 0)
+	for _, binary := range []string{"client", "server"} {
+		target := &BuildTarget{
+			ExecutableName:   DefaultNameTemplate(binary),
+			Binary:           binary,
+			Package:          "./cmd/app",
+			Version:          BasicVersion("1.2.3"),
+			Platform:         WindowsAmd64,
+			WindowsResources: &WindowsResources{ProductName: "product-" + binary},
+		}
+		if err := suite.RegisterTargetE(target); err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, target)
+	}
+	if err := suite.Execute(Parallelize(targets...)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, binary := range []string{"client", "server"} {
+		file, err := pe.Open(filepath.Join("dist", binary+"_windows-amd64.exe"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		section := file.Section(".rsrc")
+		if section == nil {
+			file.Close()
+			t.Fatalf("%s: expected a .rsrc section", binary)
+		}
+		data, err := section.Data()
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, other := range []string{"client", "server"} {
+			if contained := bytes.Contains(data, utf16String("product-"+other)); contained != (other == binary) {
+				t.Errorf("%s: product name of %s contained: %v", binary, other, contained)
+			}
+		}
+	}
+	if files := listFiles(t, filepath.Join(dir, "cmd")); !reflect.DeepEqual(files, []string{"app", "app/main.go"}) {
+		t.Errorf("resource files were left behind: %v", files)
+	}
+}
+
+func TestBuildTargetExecute(t *testing.T) {
+	native := nativePlatform(t)
+	dir := t.TempDir()
+	writeMainModule(t, dir)
+	chdir(t, dir)
+
+	suite := NewBuildSuite(PlatformSet{native})
+	suite.OutputRoot = "dist"
+	suite.OutputLayout = mustTemplate(t, "{{.Version}}/{{.OS}}_{{.Arch}}")
+	target := &BuildTarget{
+		ExecutableName:      DefaultNameTemplate("app"),
+		Version:             BasicVersion("1.2.3"),
+		VersionVariableName: "main.version",
+		Platform:            native,
+	}
+	if err := suite.RegisterTargetE(target); err != nil {
+		t.Fatal(err)
+	}
+	if err := suite.ExecuteNamedTarget(target.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	executable := filepath.Join("dist", "1.2.3", native.OS.String()+"_"+native.Arch.String(), "app_"+native.OS.String()+"-"+native.Arch.String()+native.Extension)
+	out, err := exec.Command(executable).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "1.2.3 " {
+		t.Errorf("unexpected output %q", out)
+	}
+	if files := listFiles(t, filepath.Dir(executable)); !reflect.DeepEqual(files, []string{filepath.Base(executable)}) {
+		t.Errorf("temporary files were left behind: %v", files)
+	}
+
+	expected := []*Artifact{{Path: executable, Kind: ArtifactExecutable, Platform: native, Target: "build_" + native.String()}}
+	if artifacts := suite.Artifacts(); !reflect.DeepEqual(artifacts, expected) {
+		t.Errorf("expected artifacts %v, got %v", expected, artifacts)
+	}
+}
+
+func TestBuildTargetFailure(t *testing.T) {
+	native := nativePlatform(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":  "module example.com/app\n",
+		"main.go": "package main\n\nfunc main() { undefined() }\n",
+	})
+	chdir(t, dir)
+
+	suite := NewBuildSuite(PlatformSet{native})
+	target := &BuildTarget{ExecutableName: DefaultNameTemplate("app"), Platform: native, Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}}
+	checkError(t, suite.Execute(target), "error running go build")
+	if files := listFiles(t, dir); !reflect.DeepEqual(files, []string{"go.mod", "main.go"}) {
+		t.Errorf("the failed build left files behind: %v", files)
+	}
+	if len(suite.Artifacts()) != 0 {
+		t.Errorf("failed builds must not be recorded as artifacts")
+	}
+}
+
+// mustTemplate parses the text with NameTemplate.
+func mustTemplate(t *testing.T, text string) *texttemplate.Template {
+	t.Helper()
+	tmpl, err := NameTemplate(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tmpl
+}
+
+func TestBuildTargetValidate(t *testing.T) {
+	tests := []struct {
+		target  *BuildTarget
+		wantErr string
+	}{
+		{target: &BuildTarget{ExecutableName: DefaultNameTemplate("app"), Platform: LinuxAmd64}},
+		{target: &BuildTarget{ExecutableName: DefaultNameTemplate("app")}, wantErr: "has no platform"},
+		{target: &BuildTarget{ExecutableName: DefaultNameTemplate("app"), Platform: DarwinUniversal}, wantErr: "use a UniversalBinaryTarget"},
+		{target: &BuildTarget{Platform: LinuxAmd64}, wantErr: "no executable name template"},
+	}
+	for _, test := range tests {
+		checkError(t, test.target.Validate(), test.wantErr)
+	}
+}
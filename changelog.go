@@ -0,0 +1,317 @@
+package make
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// ChangelogTargetName is the name of ChangelogTargets.
+	ChangelogTargetName = "changelog"
+	// ReleaseNotesName is the name of the release notes written
+	// by ChangelogTargets without a ChangelogFile.
+	ReleaseNotesName = "RELEASE_NOTES.md"
+)
+
+// DefaultChangelogTemplate renders ChangelogData as Markdown.
+const DefaultChangelogTemplate = `## {{.Version}} ({{.Date.Format "2006-01-02"}})
+{{if .Breaking}}
+### BREAKING CHANGES
+
+{{range .Breaking}}* {{if .Scope}}**{{.Scope}}:** {{end}}{{.Subject}} ({{.ShortHash}})
+{{end}}{{end}}{{range .Sections}}
+### {{.Title}}
+
+{{range .Commits}}* {{if .Scope}}**{{.Scope}}:** {{end}}{{.Subject}} ({{.ShortHash}})
+{{end}}{{end}}`
+
+// ChangelogType maps a Conventional Commit type to the title of
+// its section.
+type ChangelogType struct {
+	Type  string
+	Title string
+}
+
+// DefaultChangelogTypes are the types listed in changelogs
+// by default.
+var DefaultChangelogTypes = []ChangelogType{
+	{"feat", "Features"},
+	{"fix", "Bug Fixes"},
+	{"perf", "Performance Improvements"},
+	{"revert", "Reverts"},
+	{"refactor", "Code Refactoring"},
+	{"docs", "Documentation"},
+}
+
+// ChangelogCommit is a commit passed to the changelog template.
+type ChangelogCommit struct {
+	Hash      string
+	ShortHash string
+	// Type, Scope and Subject are parsed from the Conventional
+	// Commit message "type(scope)!: subject". If the message does
+	// not follow the convention the Type is empty and the Subject
+	// is the first line of the message.
+	Type     string
+	Scope    string
+	Subject  string
+	Body     string
+	Breaking bool
+}
+
+// ChangelogSection contains the commits of one type.
+type ChangelogSection struct {
+	Type    string
+	Title   string
+	Commits []*ChangelogCommit
+}
+
+// ChangelogData is the data passed to the changelog template.
+type ChangelogData struct {
+	Version Version
+	// PreviousTag is empty for the first release.
+	PreviousTag string
+	Date        time.Time
+	// Sections contains the non-empty sections in the order
+	// of the Types.
+	Sections []*ChangelogSection
+	// Breaking contains all commits with breaking changes.
+	Breaking []*ChangelogCommit
+}
+
+// ChangelogTarget renders the commits since the previous tag
+// grouped by their Conventional Commit type. The release is
+// prepended to the ChangelogFile or written as release notes.
+type ChangelogTarget struct {
+	// Version is the released version. If it is not a tag yet
+	// the commits up to HEAD are used. Defaults to VersionFromGit.
+	Version Version
+	// Template renders ChangelogData. Defaults to
+	// DefaultChangelogTemplate. Use NameTemplate to parse it.
+	Template *template.Template
+	// Types defaults to DefaultChangelogTypes.
+	Types []ChangelogType
+	// If IncludeOther is true commits of other types are listed
+	// in an additional "Other Changes" section.
+	IncludeOther bool
+
+	// ChangelogFile is the file the release is prepended to, e. g.
+	// "CHANGELOG.md". A leading level one heading is kept at the top.
+	// If empty the release notes are written to OutputDir instead.
+	ChangelogFile string
+	// OutputDir is the directory the release notes are written to.
+	// Defaults to the OutputRoot of the Suite.
+	OutputDir string
+}
+
+var conventionalCommitRegex = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?: (.+)$`)
+
+func parseChangelogCommit(hash, message string) *ChangelogCommit {
+	lines := strings.SplitN(strings.TrimSpace(message), "\n", 2)
+	c := &ChangelogCommit{
+		Hash:    hash,
+		Subject: strings.TrimSpace(lines[0]),
+	}
+	if len(hash) > 7 {
+		c.ShortHash = hash[:7]
+	} else {
+		c.ShortHash = hash
+	}
+	if len(lines) > 1 {
+		c.Body = strings.TrimSpace(lines[1])
+	}
+
+	if match := conventionalCommitRegex.FindStringSubmatch(c.Subject); match != nil {
+		c.Type = strings.ToLower(match[1])
+		c.Scope = match[2]
+		c.Breaking = match[3] == "!"
+		c.Subject = match[4]
+	}
+	if strings.Contains(c.Body, "BREAKING CHANGE:") || strings.Contains(c.Body, "BREAKING-CHANGE:") {
+		c.Breaking = true
+	}
+	return c
+}
+
+// Execute renders the changelog.
+func (t *ChangelogTarget) Execute(suite *Suite) error {
+	data, err := t.data()
+	if err != nil {
+		return fmt.Errorf("could not collect commits: %v", err)
+	}
+
+	tmpl := t.Template
+	if tmpl == nil {
+		tmpl = template.Must(NameTemplate(DefaultChangelogTemplate))
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return fmt.Errorf("invalid changelog template: %v", err)
+	}
+
+	if t.ChangelogFile != "" {
+		return t.prepend(buf.Bytes(), data.Version)
+	}
+
+	outputDir := t.OutputDir
+	if outputDir == "" {
+		outputDir = suite.OutputRoot
+	}
+	filename := filepath.Join(outputDir, ReleaseNotesName)
+	fmt.Println("Writing release notes:", filename)
+	err = writeFileAtomically(filename, 0644, func(w io.Writer) error {
+		_, err := w.Write(buf.Bytes())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	suite.AddArtifact(&Artifact{
+		Path:   filename,
+		Kind:   ArtifactReleaseNotes,
+		Target: t.Name(),
+	})
+	return nil
+}
+
+// prepend adds the release to the top of the ChangelogFile
+// unless a heading already names the version.
+func (t *ChangelogTarget) prepend(release []byte, v Version) error {
+	existing, err := ioutil.ReadFile(t.ChangelogFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if heading := changelogHeading(existing, v); heading != "" {
+		fmt.Println("Changelog already contains:", heading)
+		return nil
+	}
+
+	var title []byte
+	if bytes.HasPrefix(existing, []byte("# ")) {
+		end := bytes.IndexByte(existing, '\n')
+		if end < 0 {
+			end = len(existing) - 1
+		}
+		title = append(existing[:end+1:end+1], '\n')
+		existing = bytes.TrimLeft(existing[end+1:], "\n")
+	}
+
+	fmt.Println("Updating changelog:", t.ChangelogFile)
+	return writeFileAtomically(t.ChangelogFile, 0644, func(w io.Writer) error {
+		for _, part := range [][]byte{title, bytes.TrimRight(release, "\n"), []byte("\n\n"), existing} {
+			if _, err := w.Write(part); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// changelogHeading returns the first Markdown heading of the
+// changelog naming the version or an empty string if there is
+// none. The date and other text of the heading are ignored.
+func changelogHeading(changelog []byte, v Version) string {
+	version := regexp.QuoteMeta(strings.TrimPrefix(v.String(), "v"))
+	pattern := regexp.MustCompile(`(^|[^\w.+-])v?` + version + `($|[^\w.+-])`)
+	for _, line := range strings.Split(string(changelog), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") && pattern.MatchString(strings.TrimLeft(line, "# ")) {
+			return line
+		}
+	}
+	return ""
+}
+
+func (t *ChangelogTarget) data() (*ChangelogData, error) {
+	v := t.Version
+	if v == nil {
+		var err error
+		v, err = GitVersion(".")
+		if err != nil {
+			return nil, fmt.Errorf("no version given and none found in git: %v", err)
+		}
+	}
+	data := &ChangelogData{Version: v, Date: buildDate()}
+
+	// If the version is not tagged yet the latest
+	// tag is the previous one.
+	to, previous := "HEAD", "HEAD"
+	if tag := versionTag(v); tag != "" {
+		to, previous = tag, tag+"^"
+	}
+	data.PreviousTag, _ = gitOutput(".", "describe", "--tags", "--abbrev=0", previous)
+
+	revisions := to
+	if data.PreviousTag != "" {
+		revisions = data.PreviousTag + ".." + to
+	}
+	out, err := gitOutput(".", "log", "--format=%H%x1f%B%x1e", revisions)
+	if err != nil {
+		return nil, err
+	}
+
+	types := t.Types
+	if types == nil {
+		types = DefaultChangelogTypes
+	}
+	sections := make(map[string]*ChangelogSection)
+	for _, typ := range types {
+		sections[typ.Type] = &ChangelogSection{Type: typ.Type, Title: typ.Title}
+	}
+	other := &ChangelogSection{Title: "Other Changes"}
+
+	for _, entry := range strings.Split(out, "\x1e") {
+		fields := strings.SplitN(strings.TrimSpace(entry), "\x1f", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		c := parseChangelogCommit(fields[0], fields[1])
+		if c.Breaking {
+			data.Breaking = append(data.Breaking, c)
+		}
+		if section, ok := sections[c.Type]; ok {
+			section.Commits = append(section.Commits, c)
+		} else if t.IncludeOther {
+			other.Commits = append(other.Commits, c)
+		}
+	}
+
+	for _, typ := range types {
+		if section := sections[typ.Type]; len(section.Commits) > 0 {
+			data.Sections = append(data.Sections, section)
+		}
+	}
+	if len(other.Commits) > 0 {
+		data.Sections = append(data.Sections, other)
+	}
+	return data, nil
+}
+
+// versionTag returns the name of the tag of the version or an
+// empty string if there is none.
+func versionTag(v Version) string {
+	candidates := []string{v.String(), "v" + v.String()}
+	if o, ok := v.(interface{ Original() string }); ok {
+		candidates = append([]string{o.Original()}, candidates...)
+	}
+	for _, tag := range candidates {
+		if _, err := gitOutput(".", "rev-parse", "-q", "--verify", "refs/tags/"+tag); err == nil {
+			return tag
+		}
+	}
+	return ""
+}
+
+// Name returns ChangelogTargetName.
+func (t *ChangelogTarget) Name() string {
+	return ChangelogTargetName
+}
@@ -0,0 +1,200 @@
+package make
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChangelogCommit(t *testing.T) {
+	tests := []struct {
+		message  string
+		expected ChangelogCommit
+	}{
+		{
+			message:  "feat(cli): add a flag\n\nLonger description.",
+			expected: ChangelogCommit{Type: "feat", Scope: "cli", Subject: "add a flag", Body: "Longer description."},
+		},
+		{
+			message:  "Fix: handle empty input",
+			expected: ChangelogCommit{Type: "fix", Subject: "handle empty input"},
+		},
+		{
+			message:  "refactor!: drop the old API",
+			expected: ChangelogCommit{Type: "refactor", Subject: "drop the old API", Breaking: true},
+		},
+		{
+			message:  "feat: new config\n\nBREAKING CHANGE: the format changed",
+			expected: ChangelogCommit{Type: "feat", Subject: "new config", Body: "BREAKING CHANGE: the format changed", Breaking: true},
+		},
+		{
+			message:  "Merge branch 'main'",
+			expected: ChangelogCommit{Subject: "Merge branch 'main'"},
+		},
+	}
+	for _, test := range tests {
+		test.expected.Hash = "0123456789abcdef"
+		test.expected.ShortHash = "0123456"
+		c := parseChangelogCommit("0123456789abcdef", test.message)
+		if !reflect.DeepEqual(*c, test.expected) {
+			t.Errorf("%q: expected %+v, got %+v", test.message, test.expected, *c)
+		}
+	}
+}
+
+func TestChangelogHeading(t *testing.T) {
+	tests := []struct {
+		changelog string
+		version   string
+		heading   string
+	}{
+		{changelog: "# Changelog\n\n## 1.2.3 (2024-01-02)\n", version: "1.2.3", heading: "## 1.2.3 (2024-01-02)"},
+		{changelog: "## [v1.2.3](https://example.com) - 2024-01-02\n", version: "1.2.3", heading: "## [v1.2.3](https://example.com) - 2024-01-02"},
+		{changelog: "## 1.2.3\n", version: "v1.2.3", heading: "## 1.2.3"},
+		{changelog: "## 1.2.30 (2024-01-02)\n", version: "1.2.3"},
+		{changelog: "## 1.2.3-rc.1 (2024-01-02)\n", version: "1.2.3"},
+		{changelog: "## 11.2.3\n", version: "1.2.3"},
+		{changelog: "* fix crash in 1.2.3\n", version: "1.2.3"},
+		{changelog: "", version: "1.2.3"},
+	}
+	for _, test := range tests {
+		if heading := changelogHeading([]byte(test.changelog), BasicVersion(test.version)); heading != test.heading {
+			t.Errorf("%s in %q: expected %q, got %q", test.version, test.changelog, test.heading, heading)
+		}
+	}
+}
+
+// initChangelogRepo creates a repository with a tagged release
+// followed by Conventional Commits.
+func initChangelogRepo(t *testing.T, dir string) {
+	t.Helper()
+	initGitRepo(t, dir, map[string]string{"README": "readme"})
+	git(t, dir, "tag", "v1.0.0")
+	for _, message := range []string{
+		"feat(cli): add a flag",
+		"fix: handle empty input",
+		"chore: update dependencies",
+		"refactor!: drop the old API",
+	} {
+		git(t, dir, "commit", "-q", "--allow-empty", "-m", message)
+	}
+}
+
+func TestChangelogTarget(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       *ChangelogTarget
+		existing     string
+		expected     []string
+		notExpected  []string
+		releaseNotes bool
+	}{
+		{
+			name:         "release notes",
+			target:       &ChangelogTarget{},
+			releaseNotes: true,
+			expected: []string{
+				"## 1.1.0 (2020-09-13)",
+				"### BREAKING CHANGES\n\n* drop the old API",
+				"### Features\n\n* **cli:** add a flag",
+				"### Bug Fixes\n\n* handle empty input",
+			},
+			notExpected: []string{"update dependencies", "initial commit"},
+		},
+		{
+			name:         "other changes",
+			target:       &ChangelogTarget{IncludeOther: true, Types: []ChangelogType{{"fix", "Fixes"}}},
+			releaseNotes: true,
+			expected:     []string{"### Fixes\n\n* handle empty input", "### Other Changes\n", "* update dependencies"},
+			notExpected:  []string{"### Features"},
+		},
+		{
+			name:     "changelog file",
+			target:   &ChangelogTarget{ChangelogFile: "CHANGELOG.md"},
+			existing: "# Changelog\n\n## 1.0.0 (2020-01-01)\n\n* initial release\n",
+			expected: []string{"# Changelog\n\n## 1.1.0 (2020-09-13)\n", "## 1.0.0 (2020-01-01)\n\n* initial release\n"},
+		},
+		{
+			name:     "already released",
+			target:   &ChangelogTarget{ChangelogFile: "CHANGELOG.md"},
+			existing: "# Changelog\n\n## 1.1.0 (2020-01-01)\n",
+			expected: []string{"# Changelog\n\n## 1.1.0 (2020-01-01)\n"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			initChangelogRepo(t, dir)
+			chdir(t, dir)
+			t.Setenv("SOURCE_DATE_EPOCH", "1600000000")
+			if test.existing != "" {
+				writeFiles(t, dir, map[string]string{"CHANGELOG.md": test.existing})
+			}
+
+			suite := NewBuildSuite(nil)
+			suite.OutputRoot = "dist"
+			test.target.Version = BasicVersion("1.1.0")
+			if err := test.target.Execute(suite); err != nil {
+				t.Fatal(err)
+			}
+
+			filename := test.target.ChangelogFile
+			if test.releaseNotes {
+				filename = filepath.Join("dist", ReleaseNotesName)
+				artifacts := suite.Artifacts()
+				if len(artifacts) != 1 || artifacts[0].Kind != ArtifactReleaseNotes || artifacts[0].Path != filename {
+					t.Errorf("expected the release notes artifact, got %v", artifacts)
+				}
+			}
+			content, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(string(content), expected) {
+					t.Errorf("expected %q in\n%s", expected, content)
+				}
+			}
+			for _, notExpected := range test.notExpected {
+				if strings.Contains(string(content), notExpected) {
+					t.Errorf("unexpected %q in\n%s", notExpected, content)
+				}
+			}
+		})
+	}
+}
+
+func TestChangelogTargetIdempotent(t *testing.T) {
+	dir := t.TempDir()
+	initChangelogRepo(t, dir)
+	chdir(t, dir)
+
+	target := &ChangelogTarget{Version: BasicVersion("1.1.0"), ChangelogFile: "CHANGELOG.md"}
+	// The second run happens on another day.
+	for _, epoch := range []string{"1600000000", "1600100000"} {
+		t.Setenv("SOURCE_DATE_EPOCH", epoch)
+		if err := target.Execute(NewBuildSuite(nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := ioutil.ReadFile("CHANGELOG.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := strings.Count(string(content), "## 1.1.0"); count != 1 {
+		t.Errorf("expected the release once, got %d times in\n%s", count, content)
+	}
+}
+
+func TestChangelogTargetNoGit(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(dir))
+
+	err := (&ChangelogTarget{}).Execute(NewBuildSuite(nil))
+	checkError(t, err, "no version given and none found in git")
+}
@@ -1,46 +1,275 @@
 package make
 
-import "os"
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
 
 // CleanTargetNamePrefix is the prefix all CleanTargets
 // will have in theire name.
 const CleanTargetNamePrefix = "clean_"
 
-// CleanTarget removes the file with the given filename.
+// CleanTarget removes the file or directory with the given
+// filename and the files and directories matching the patterns.
+//
+// As a safety guard it refuses to remove anything outside
+// of the Root directory or the Root itself, even if it is
+// reached through symbolic links.
 type CleanTarget struct {
-	// The name of the file to be removed.
+	// The name of the file to be removed. It is taken
+	// literally, use Patterns for globbing.
 	Filename string
+	// Patterns contains glob patterns as accepted by
+	// filepath.Match of files and directories to be removed.
+	Patterns []string
+	// RecursivePatterns contains additional glob patterns of
+	// files and directories to be removed including their
+	// contents regardless of Recursive.
+	RecursivePatterns []string
+	// Exclude contains glob patterns of files and directories
+	// that must not be removed. The patterns are matched against
+	// the path relative to Root as well as the base name.
+	Exclude []string
+	// If Recursive is true directories will be removed including
+	// their contents, otherwise only empty directories are removed.
+	// Symbolic links are removed, never followed.
+	Recursive bool
+	// If DryRun is true nothing is removed but the paths that
+	// would be removed are printed.
+	DryRun bool
+	// Root is the directory outside of which nothing will be
+	// removed. Defaults to the root of the module containing the
+	// working directory.
+	Root string
+
 	// The optional Platform the file was created for.
 	Platform *Platform
+
+	// Where to print the paths during a dry-run. If nil they
+	// will be printed to this precesses stdout.
+	Stdout io.Writer
+}
+
+// directoryOutputTarget is an OutputTarget whose output
+// is a directory, e. g. an image layout.
+type directoryOutputTarget interface {
+	outputIsDirectory() bool
 }
 
-// CleanTargetsFromOutputTargets creates clean targets from NamedOutputTargets.
+// CleanTargetsFromOutputTargets creates clean targets from OutputTargets.
+// If a Target is a PlatformTarget the Platform is kept. Outputs that
+// are directories are removed recursively.
 func CleanTargetsFromOutputTargets(targets ...OutputTarget) []*CleanTarget {
 	cleans := make([]*CleanTarget, len(targets))
 
 	for i, t := range targets {
 		cleans[i] = &CleanTarget{Filename: t.OutputName()}
+		if pt, ok := t.(PlatformTarget); ok {
+			cleans[i].Platform = pt.TargetPlatform()
+		}
+		if dt, ok := t.(directoryOutputTarget); ok {
+			cleans[i].Recursive = dt.outputIsDirectory()
+		}
 	}
 
 	return cleans
 }
 
-// Execute removes the file with the given filename.
+// Execute removes the files and directories.
 func (t *CleanTarget) Execute(suite *Suite) error {
-	err := os.Remove(t.Filename)
-	if err != nil && !os.IsNotExist(err) {
+	root := t.Root
+	if root == "" {
+		var err error
+		root, err = findModuleRoot(".")
+		if err != nil {
+			return err
+		}
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
 		return err
 	}
+
+	if t.Filename != "" {
+		if _, err := os.Lstat(t.Filename); err == nil {
+			if err := t.removeMatches(root, []string{t.Filename}, t.Recursive); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for _, pattern := range t.Patterns {
+		if err := t.removeGlob(root, pattern, t.Recursive); err != nil {
+			return err
+		}
+	}
+	for _, pattern := range t.RecursivePatterns {
+		if err := t.removeGlob(root, pattern, true); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (t *CleanTarget) removeGlob(root, pattern string, recursive bool) error {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	return t.removeMatches(root, matches, recursive)
+}
+
+func (t *CleanTarget) removeMatches(root string, matches []string, recursive bool) error {
+	for _, match := range matches {
+		path, err := filepath.Abs(match)
+		if err != nil {
+			return err
+		}
+		if err := checkRemovable(root, path); err != nil {
+			return err
+		}
+		if t.isExcluded(root, path) {
+			continue
+		}
+		if _, err := t.remove(root, path, recursive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// escapeGlob returns a pattern matching only the filename by
+// putting the special characters of filepath.Match into
+// character classes. Backslashes are escaped unless they are
+// the path separator.
+func escapeGlob(filename string) string {
+	escaped := &strings.Builder{}
+	for _, r := range filename {
+		switch {
+		case r == '*' || r == '?' || r == '[':
+			escaped.WriteString("[" + string(r) + "]")
+		case r == '\\' && filepath.Separator != '\\':
+			escaped.WriteString("\\\\")
+		default:
+			escaped.WriteRune(r)
+		}
+	}
+	return escaped.String()
+}
+
+func (t *CleanTarget) patterns() []string {
+	patterns := make([]string, 0, len(t.Patterns)+len(t.RecursivePatterns)+1)
+	if t.Filename != "" {
+		patterns = append(patterns, t.Filename)
+	}
+	patterns = append(patterns, t.Patterns...)
+	return append(patterns, t.RecursivePatterns...)
+}
+
+// checkRemovable returns an error if path is the root or
+// outside of it. Symbolic links in the root and the parent
+// directories of path are resolved before comparing. The path
+// itself is not resolved, since symbolic links are removed
+// rather than their targets.
+func checkRemovable(root, path string) error {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(resolvedRoot, filepath.Join(parent, filepath.Base(path)))
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return fmt.Errorf("refusing to remove the root directory %s", root)
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("refusing to remove %s outside of the root directory %s", path, root)
+	}
+	return nil
+}
+
+func (t *CleanTarget) isExcluded(root, path string) bool {
+	rel, _ := filepath.Rel(root, path)
+	for _, pattern := range t.Exclude {
+		if ok, _ := filepath.Match(pattern, filepath.ToSlash(rel)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// remove removes the path and returns true if anything
+// had to be kept due to exclusions.
+func (t *CleanTarget) remove(root, path string, recursive bool) (kept bool, err error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if info.IsDir() && recursive {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return false, err
+		}
+		for _, entry := range entries {
+			child := filepath.Join(path, entry.Name())
+			if t.isExcluded(root, child) {
+				kept = true
+				continue
+			}
+			childKept, err := t.remove(root, child, recursive)
+			if err != nil {
+				return false, err
+			}
+			kept = kept || childKept
+		}
+		if kept {
+			return true, nil
+		}
+	}
+
+	if t.DryRun {
+		stdout := t.Stdout
+		if stdout == nil {
+			stdout = os.Stdout
+		}
+		fmt.Fprintln(stdout, "Would remove:", relativePath(path))
+		return false, nil
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return false, nil
+}
+
 // Name returns the name of this Target.
 // The name will consist of the CleanTargetNamePrefix
 // and the Platform name if present or the filename otherwise.
 func (t *CleanTarget) Name() string {
 	var postfix string
 	if t.Platform == nil {
-		postfix = t.Filename
+		patterns := t.patterns()
+		if len(patterns) > 0 {
+			postfix = patterns[0]
+		}
 	} else {
 		postfix = t.Platform.String()
 	}
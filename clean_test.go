@@ -0,0 +1,205 @@
+package make
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// listFiles returns the slash separated paths of all files,
+// directories and symbolic links below dir.
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestCleanTarget(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    *CleanTarget
+		remaining []string
+		wantErr   string
+	}{
+		{
+			name:      "file",
+			target:    &CleanTarget{Filename: "dist/app"},
+			remaining: []string{"dist", "dist/app.exe", "dist/docs", "dist/docs/README", "go.mod", "src", "src/main.go"},
+		},
+		{
+			name:      "literal filename",
+			target:    &CleanTarget{Filename: "dist/app*"},
+			remaining: []string{"dist", "dist/app", "dist/app.exe", "dist/docs", "dist/docs/README", "go.mod", "src", "src/main.go"},
+		},
+		{
+			name:      "glob",
+			target:    &CleanTarget{Patterns: []string{"dist/app*"}},
+			remaining: []string{"dist", "dist/docs", "dist/docs/README", "go.mod", "src", "src/main.go"},
+		},
+		{
+			name:      "recursive",
+			target:    &CleanTarget{Filename: "dist", Recursive: true},
+			remaining: []string{"go.mod", "src", "src/main.go"},
+		},
+		{
+			name:    "non-empty directory",
+			target:  &CleanTarget{Filename: "dist"},
+			wantErr: "directory not empty",
+		},
+		{
+			name:      "exclude",
+			target:    &CleanTarget{Filename: "dist", Recursive: true, Exclude: []string{"README"}},
+			remaining: []string{"dist", "dist/docs", "dist/docs/README", "go.mod", "src", "src/main.go"},
+		},
+		{
+			name:      "patterns",
+			target:    &CleanTarget{Patterns: []string{"dist/*.exe", "src/*.go"}},
+			remaining: []string{"dist", "dist/app", "dist/docs", "dist/docs/README", "go.mod", "src"},
+		},
+		{
+			name:      "dry-run",
+			target:    &CleanTarget{Filename: "dist", Recursive: true, DryRun: true},
+			remaining: []string{"dist", "dist/app", "dist/app.exe", "dist/docs", "dist/docs/README", "go.mod", "src", "src/main.go"},
+		},
+		{
+			name:    "root",
+			target:  &CleanTarget{Filename: ".", Recursive: true},
+			wantErr: "refusing to remove the root directory",
+		},
+		{
+			name:    "outside of root",
+			target:  &CleanTarget{Filename: "..", Recursive: true},
+			wantErr: "outside of the root directory",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":           "module example.com/clean\n",
+				"src/main.go":      "package main\n",
+				"dist/app":         "binary",
+				"dist/app.exe":     "binary",
+				"dist/docs/README": "readme",
+			})
+			chdir(t, dir)
+
+			stdout := &bytes.Buffer{}
+			test.target.Stdout = stdout
+			err := test.target.Execute(NewBuildSuite(nil))
+			checkError(t, err, test.wantErr)
+			if test.remaining != nil {
+				if files := listFiles(t, dir); !reflect.DeepEqual(files, test.remaining) {
+					t.Errorf("expected %v to remain, got %v", test.remaining, files)
+				}
+			}
+			if test.target.DryRun && !strings.Contains(stdout.String(), "Would remove: "+filepath.Join("dist", "docs", "README")) {
+				t.Errorf("expected the removed files to be printed, got\n%s", stdout)
+			}
+		})
+	}
+}
+
+func TestCleanTargetSymlinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		link    string
+		target  *CleanTarget
+		wantErr string
+	}{
+		{
+			name:    "symlinked output directory",
+			link:    "dist",
+			target:  &CleanTarget{Patterns: []string{"dist/*"}, Recursive: true},
+			wantErr: "outside of the root directory",
+		},
+		{
+			name:   "symlink to outside directory",
+			link:   "dist/home",
+			target: &CleanTarget{Filename: "dist", Recursive: true},
+		},
+		{
+			name:   "globbed symlink",
+			link:   "dist/home",
+			target: &CleanTarget{RecursivePatterns: []string{"dist/*"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outside := t.TempDir()
+			writeFiles(t, outside, map[string]string{"precious/file": "keep me"})
+			root := t.TempDir()
+			writeFiles(t, root, map[string]string{"go.mod": "module example.com/clean\n"})
+			if err := os.MkdirAll(filepath.Join(root, "dist"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			os.Remove(filepath.Join(root, test.link))
+			if err := os.Symlink(outside, filepath.Join(root, test.link)); err != nil {
+				t.Skip("symbolic links are not supported:", err)
+			}
+
+			test.target.Root = root
+			if test.target.Filename != "" {
+				test.target.Filename = filepath.Join(root, test.target.Filename)
+			}
+			for i, pattern := range test.target.Patterns {
+				test.target.Patterns[i] = filepath.Join(root, pattern)
+			}
+			for i, pattern := range test.target.RecursivePatterns {
+				test.target.RecursivePatterns[i] = filepath.Join(root, pattern)
+			}
+			err := test.target.Execute(NewBuildSuite(nil))
+			checkError(t, err, test.wantErr)
+
+			if files := listFiles(t, outside); !reflect.DeepEqual(files, []string{"precious", "precious/file"}) {
+				t.Errorf("files outside of the root were removed, remaining %v", files)
+			}
+			if err == nil {
+				if _, err := os.Lstat(filepath.Join(root, test.link)); !os.IsNotExist(err) {
+					t.Errorf("expected the symbolic link to be removed")
+				}
+			}
+		})
+	}
+}
+
+func TestEscapeGlob(t *testing.T) {
+	tests := []struct {
+		filename string
+		other    string
+	}{
+		{filename: "dist/app", other: "dist/app.exe"},
+		{filename: "dist/app*", other: "dist/app.exe"},
+		{filename: "dist/app?", other: "dist/app1"},
+		{filename: "dist/app[1]", other: "dist/app1"},
+	}
+	for _, test := range tests {
+		pattern := escapeGlob(test.filename)
+		if ok, err := filepath.Match(pattern, test.filename); err != nil || !ok {
+			t.Errorf("%s: expected %q to match, got %v %v", test.filename, pattern, ok, err)
+		}
+		if ok, _ := filepath.Match(pattern, test.other); ok {
+			t.Errorf("%s: expected %q not to match %s", test.filename, pattern, test.other)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package make
 
 import (
 	"fmt"
+	"os"
 
 	"gopkg.in/urfave/cli.v1"
 )
@@ -9,7 +10,11 @@ import (
 // VERSION is the version of go-make.
 const VERSION = "0.1.0"
 
-// CLIApp returns a cli.App with default build and clean commands.
+// CLIApp returns a cli.App with default build and clean commands
+// and the PlatformCommands and global flags registered with the
+// Suite. Flags not given on the command line are read from environment
+// variables prefixed with SettingsEnvPrefix and from the settings
+// file SettingsFileName, in that order.
 func CLIApp(suite *Suite) *cli.App {
 	app := cli.NewApp()
 	app.Name = "make"
@@ -21,65 +26,232 @@ func CLIApp(suite *Suite) *cli.App {
 			Name:  "parallel, p",
 			Usage: "parallelize targets",
 		},
+		cli.IntFlag{
+			Name:  "jobs, j",
+			Usage: "maximum number of parallel targets, 0 means no limit",
+		},
 	}
+	settings := newCLISettings(SettingsFileName)
 
 	app.Commands = []cli.Command{
 		cli.Command{
-			Name: "build",
+			Name:  "verify-reproducible",
+			Usage: "Builds twice in reproducible mode and compares the executables.",
+			Flags: platformFlags(),
+			Action: func(c *cli.Context) error {
+				platforms, err := selectedPlatforms(c)
+				if err != nil {
+					return cli.NewExitError(err, -1)
+				}
+
+				target := &ReproducibilityTarget{}
+				for _, t := range selectTargets(suite, BuildTargetNamePrefix, platforms) {
+					if bt, ok := t.(*BuildTarget); ok {
+						target.Targets = append(target.Targets, bt)
+					}
+				}
+				if len(target.Targets) == 0 {
+					return cli.NewExitError("no build targets for the selected platforms", -2)
+				}
+
+				err = suite.Execute(target)
+				if err != nil {
+					return cli.NewExitError(err, -2)
+				}
+
+				return nil
+			},
+		},
+		cli.Command{
+			Name:  "list",
+			Usage: "Lists all registered targets.",
+			Action: func(c *cli.Context) error {
+				for _, name := range suite.TargetNames() {
+					if t, ok := suite.Lookup(name).(DescribedTarget); ok && t.Description() != "" {
+						fmt.Printf("%s\t%s\n", name, t.Description())
+					} else {
+						fmt.Println(name)
+					}
+				}
+				return nil
+			},
+		},
+		cli.Command{
+			Name:      "run",
+			Usage:     "Runs the registered targets with the given names.",
+			ArgsUsage: "TARGET...",
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					return cli.NewExitError("no target given", -1)
+				}
+
+				targets := make([]Target, c.NArg())
+				for i, name := range c.Args() {
+					targets[i] = suite.Lookup(name)
+					if targets[i] == nil {
+						return cli.NewExitError(fmt.Sprintf("target \"%s\" could not be found", name), -1)
+					}
+				}
+
+				var target Target
+				if c.GlobalBool("parallel") {
+					target = parallelize(c, targets)
+				} else {
+					target = Concatenate(true, targets...)
+				}
+
+				err := suite.Execute(target)
+				if err != nil {
+					return cli.NewExitError(err, -2)
+				}
+
+				return nil
+			},
+		},
+		cli.Command{
+			Name:  "generate",
+			Usage: "Runs go generate.",
 			Flags: []cli.Flag{
-				cli.StringFlag{
-					Name:  "os",
-					Usage: "The operating system to build for.",
-					Value: "native",
+				cli.BoolFlag{
+					Name:  "check",
+					Usage: "Fail if go generate changes any generated file.",
 				},
-				cli.StringFlag{
-					Name:  "arch",
-					Usage: "The architecture to build for.",
-					Value: "native",
+			},
+			Action: func(c *cli.Context) error {
+				target := &GenerateTarget{}
+				if t, ok := suite.Lookup(GenerateTargetName).(*GenerateTarget); ok {
+					copy := *t
+					target = &copy
+				}
+				target.Check = c.Bool("check")
+
+				err := suite.Execute(target)
+				if err != nil {
+					return cli.NewExitError(err, -2)
+				}
+
+				return nil
+			},
+		},
+		cli.Command{
+			Name:  "fmt",
+			Usage: "Checks or fixes the formatting of all Go files.",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "check",
+					Usage: "List unformatted files with diffs and fail if there are any. This is the default.",
 				},
 				cli.BoolFlag{
-					Name:  "release",
-					Usage: "If set all available platforms will be built.",
+					Name:  "fix",
+					Usage: "Rewrite unformatted files.",
 				},
 			},
 			Action: func(c *cli.Context) error {
-				if c.Bool("release") {
-					// Build all.
-					var target Target
-					targets := suite.LookupBuildTargets()
-					if c.GlobalBool("parallel") {
-						target = Parallelize(targets...)
-					} else {
-						target = Concatenate(false, targets...)
-					}
+				if c.Bool("check") && c.Bool("fix") {
+					return cli.NewExitError("--check and --fix are mutually exclusive", -1)
+				}
 
-					err := suite.Execute(target)
-					return err
+				target := &FormatTarget{}
+				if t, ok := suite.Lookup(FormatTargetName).(*FormatTarget); ok {
+					copy := *t
+					target = &copy
 				}
+				target.Fix = c.Bool("fix")
 
-				// Build only one.
-				platform, err := ParsePlatform(c.String("os"), c.String("arch"))
+				err := suite.Execute(target)
 				if err != nil {
-					return cli.NewExitError(err, -1)
+					return cli.NewExitError(err, -2)
 				}
 
-				err = suite.ExecuteNamedTarget(BuildTargetNamePrefix + platform.String())
-				if IsNotFound(err) {
-					return cli.NewExitError(fmt.Sprintf("platform %s is not supported", platform), -2)
-				} else if err != nil {
+				return nil
+			},
+		},
+		cli.Command{
+			Name:  "mod",
+			Usage: "Tidies, downloads and verifies the module dependencies.",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "check",
+					Usage: "Fail if go mod tidy would change go.mod or go.sum.",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				target := &ModTarget{Tidy: true, Verify: true}
+				if t, ok := suite.Lookup(ModTargetName).(*ModTarget); ok {
+					copy := *t
+					target = &copy
+				}
+				target.Check = c.Bool("check")
+
+				err := suite.Execute(target)
+				if err != nil {
 					return cli.NewExitError(err, -2)
 				}
 
 				return nil
 			},
 		},
+		cli.Command{
+			Name:  "publish",
+			Usage: "Publishes the artifacts to the release directory.",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "Replace the version if it is already published.",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				t, ok := suite.Lookup(PublishTargetName).(*PublishTarget)
+				if !ok {
+					return cli.NewExitError("no publish target registered", -1)
+				}
+				target := *t
+				target.Force = c.Bool("force")
+
+				err := suite.Execute(&target)
+				if err != nil {
+					return cli.NewExitError(err, -2)
+				}
+
+				return nil
+			},
+		},
+		cli.Command{
+			Name:  "config",
+			Usage: "Prints the effective flag values and where they came from.",
+			Action: func(c *cli.Context) error {
+				return settings.print(os.Stdout, c, app)
+			},
+		},
 		cli.Command{
 			Name: "clean",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Only print what would be removed.",
+				},
+				cli.BoolFlag{
+					Name:  "all",
+					Usage: "Also remove caches and fingerprints.",
+				},
+			},
 			Action: func(c *cli.Context) error {
 				var target Target
 				targets := suite.LookupCleanTargets()
+				if c.Bool("all") {
+					targets = append(targets, suite.CacheCleanTarget())
+				}
+				if c.Bool("dry-run") {
+					for i, t := range targets {
+						if clean, ok := t.(*CleanTarget); ok {
+							copy := *clean
+							copy.DryRun = true
+							targets[i] = &copy
+						}
+					}
+				}
 				if c.GlobalBool("parallel") {
-					target = Parallelize(targets...)
+					target = parallelize(c, targets)
 				} else {
 					target = Concatenate(false, targets...)
 				}
@@ -93,6 +265,67 @@ func CLIApp(suite *Suite) *cli.App {
 			},
 		},
 	}
+	app.Flags = append(app.Flags, suite.flags...)
+	app.Commands = append(platformCommands(suite), app.Commands...)
+
+	app.Before = func(c *cli.Context) error {
+		suite.setFlagValues(c.GlobalGeneric, app.Flags)
+		return nil
+	}
+	for i := range app.Commands {
+		flags := app.Commands[i].Flags
+		app.Commands[i].Before = func(c *cli.Context) error {
+			suite.setFlagValues(c.Generic, flags)
+			return nil
+		}
+	}
+	settings.bind(app)
 
 	return app
 }
+
+// parallelize combines the Targets to be executed in parallel
+// limited by the jobs flag.
+func parallelize(c *cli.Context, targets []Target) Target {
+	return &ParallelTargets{SubTargets: targets, Jobs: c.GlobalInt("jobs")}
+}
+
+// onceAll wraps all NamedTargets, so they are executed via
+// Suite.ExecuteOnce.
+func onceAll(targets []Target) []Target {
+	ret := make([]Target, len(targets))
+	for i, target := range targets {
+		if named, ok := target.(NamedTarget); ok {
+			ret[i] = Once(named)
+		} else {
+			ret[i] = target
+		}
+	}
+	return ret
+}
+
+// platformCommands returns the cli.Commands of the default and
+// the registered PlatformCommands. Registered commands replace
+// default ones with the same name.
+func platformCommands(suite *Suite) []cli.Command {
+	commands := make([]*PlatformCommand, len(defaultPlatformCommands))
+	copy(commands, defaultPlatformCommands)
+	for _, registered := range suite.commands {
+		replaced := false
+		for i, command := range commands {
+			if command.Name == registered.Name {
+				commands[i] = registered
+				replaced = true
+			}
+		}
+		if !replaced {
+			commands = append(commands, registered)
+		}
+	}
+
+	ret := make([]cli.Command, len(commands))
+	for i, command := range commands {
+		ret[i] = command.cliCommand(suite)
+	}
+	return ret
+}
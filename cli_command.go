@@ -0,0 +1,225 @@
+package make
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+// PlatformCommand is a command of the CLIApp executing the
+// registered Targets whose names start with the TargetPrefix.
+// Like the build command it has the flags --os and --arch to
+// select one platform, --platforms to select several and
+// --release to select all of them. It fails if no Target of the
+// selected platforms is registered. Targets without a Platform
+// are executed regardless of the selection.
+type PlatformCommand struct {
+	Name  string
+	Usage string
+	// TargetPrefix is the name prefix of the executed Targets,
+	// e. g. PackageTargetNamePrefix.
+	TargetPrefix string
+	// Flags are additional flags of the command. Their values
+	// are available to the Targets through Suite.FlagValue.
+	Flags []cli.Flag
+}
+
+// defaultPlatformCommands are the PlatformCommands of every CLIApp.
+var defaultPlatformCommands = []*PlatformCommand{
+	{
+		Name:         "build",
+		Usage:        "Builds the executables.",
+		TargetPrefix: BuildTargetNamePrefix,
+	},
+	{
+		Name:         "package",
+		Usage:        "Creates the Linux packages.",
+		TargetPrefix: PackageTargetNamePrefix,
+	},
+	{
+		Name:         "archive",
+		Usage:        "Creates the archives.",
+		TargetPrefix: ArchiveTargetNamePrefix,
+	},
+}
+
+// RegisterCommand registers a PlatformCommand that will be added
+// to the CLIApp of the Suite. It replaces a default command with
+// the same name.
+func (s *Suite) RegisterCommand(command *PlatformCommand) error {
+	if command.Name == "" || command.TargetPrefix == "" {
+		return fmt.Errorf("command needs a name and a target prefix")
+	}
+	for _, registered := range s.commands {
+		if registered.Name == command.Name {
+			return fmt.Errorf("command %s is already registered", command.Name)
+		}
+	}
+	s.commands = append(s.commands, command)
+	return nil
+}
+
+// RegisterFlag registers a global flag that will be added to the
+// CLIApp of the Suite. Like the default flags it can be set via
+// environment variables and the settings file. Its value is
+// available to the Targets through FlagValue.
+func (s *Suite) RegisterFlag(flag cli.Flag) {
+	s.flags = append(s.flags, flag)
+}
+
+// FlagValue returns the value of a flag of the CLIApp, e. g.
+// "parallel", formatted as string. Global flags are available
+// while any command is executed, flags of a command only while
+// that command is executed. An empty string is returned for
+// unknown flags or if the Suite is not run by a CLIApp.
+func (s *Suite) FlagValue(name string) string {
+	s.flagsMutex.RLock()
+	defer s.flagsMutex.RUnlock()
+	return s.flagValues[name]
+}
+
+// BoolFlagValue returns true if the value of the flag is
+// a boolean that is true.
+func (s *Suite) BoolFlagValue(name string) bool {
+	value, _ := strconv.ParseBool(s.FlagValue(name))
+	return value
+}
+
+// setFlagValues records the values of the flags.
+func (s *Suite) setFlagValues(lookup func(name string) interface{}, flags []cli.Flag) {
+	s.flagsMutex.Lock()
+	defer s.flagsMutex.Unlock()
+	if s.flagValues == nil {
+		s.flagValues = make(map[string]string)
+	}
+	for _, f := range flags {
+		if value := lookup(flagName(f)); value != nil {
+			s.flagValues[flagName(f)] = fmt.Sprint(value)
+		}
+	}
+}
+
+// platformFlags returns the flags selecting the platforms.
+func platformFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "os",
+			Usage: "The operating system to select.",
+			Value: "native",
+		},
+		cli.StringFlag{
+			Name:  "arch",
+			Usage: "The architecture to select.",
+			Value: "native",
+		},
+		cli.StringFlag{
+			Name:  "platforms",
+			Usage: "Comma separated platforms to select, e. g. \"linux/amd64,windows/amd64\".",
+		},
+		cli.BoolFlag{
+			Name:  "release",
+			Usage: "If set all available platforms are selected.",
+		},
+	}
+}
+
+// selectedPlatforms returns the platforms selected by the
+// platformFlags or nil if all are selected.
+func selectedPlatforms(c *cli.Context) (PlatformSet, error) {
+	if c.Bool("release") {
+		return nil, nil
+	}
+	if platforms := c.String("platforms"); platforms != "" {
+		texts := strings.Split(platforms, ",")
+		for i := range texts {
+			texts[i] = strings.TrimSpace(texts[i])
+		}
+		return parsePlatforms(texts)
+	}
+
+	platform, err := ParsePlatform(c.String("os"), c.String("arch"))
+	if err != nil {
+		return nil, err
+	}
+	return PlatformSet{platform}, nil
+}
+
+// selectTargets returns the registered Targets with the prefix
+// sorted by name. If platforms is not nil Targets of other
+// platforms are left out.
+func selectTargets(suite *Suite, prefix string, platforms PlatformSet) []Target {
+	ret := make([]Target, 0)
+	for _, name := range suite.TargetNames() {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		target := suite.Lookup(name)
+		if pt, ok := target.(PlatformTarget); ok && platforms != nil && pt.TargetPlatform() != nil {
+			if ok, _ := platforms.Contains(pt.TargetPlatform()); !ok {
+				continue
+			}
+		}
+		ret = append(ret, target)
+	}
+	return ret
+}
+
+// hasPlatformTargets returns true if any of the Targets has
+// a Platform.
+func hasPlatformTargets(targets []Target) bool {
+	for _, target := range targets {
+		if pt, ok := target.(PlatformTarget); ok && pt.TargetPlatform() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// cliCommand returns the cli.Command executing the Targets.
+func (command *PlatformCommand) cliCommand(suite *Suite) cli.Command {
+	return cli.Command{
+		Name:  command.Name,
+		Usage: command.Usage,
+		Flags: append(platformFlags(), command.Flags...),
+		Action: func(c *cli.Context) error {
+			if len(selectTargets(suite, command.TargetPrefix, nil)) == 0 {
+				return cli.NewExitError(fmt.Sprintf("no %s targets registered", command.Name), -1)
+			}
+
+			platforms, err := selectedPlatforms(c)
+			if err != nil {
+				return cli.NewExitError(err, -1)
+			}
+			targets := selectTargets(suite, command.TargetPrefix, platforms)
+			if !hasPlatformTargets(targets) && hasPlatformTargets(selectTargets(suite, command.TargetPrefix, nil)) {
+				if len(platforms) == 1 {
+					return cli.NewExitError(fmt.Sprintf("platform %s is not supported", platforms[0]), -2)
+				}
+				names := make([]string, len(platforms))
+				for i, p := range platforms {
+					names[i] = p.String()
+				}
+				return cli.NewExitError(fmt.Sprintf("none of the platforms %s is supported", strings.Join(names, ", ")), -2)
+			}
+
+			// Targets may depend on each other, e. g. universal
+			// binaries, so each one must only be executed once.
+			targets = onceAll(targets)
+			var target Target
+			if c.GlobalBool("parallel") {
+				target = parallelize(c, targets)
+			} else {
+				target = Concatenate(false, targets...)
+			}
+
+			err = suite.Execute(target)
+			if err != nil {
+				return cli.NewExitError(err, -2)
+			}
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,209 @@
+package make
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+// flagTarget records its executions and the value of a flag.
+type flagTarget struct {
+	name     string
+	platform *Platform
+	flag     string
+
+	mutex    sync.Mutex
+	executed int
+	value    string
+}
+
+func (t *flagTarget) Execute(suite *Suite) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.executed++
+	t.value = suite.FlagValue(t.flag)
+	return nil
+}
+func (t *flagTarget) Name() string              { return t.name }
+func (t *flagTarget) TargetPlatform() *Platform { return t.platform }
+
+func TestRegisterCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []*PlatformCommand
+		wantErr  string
+	}{
+		{name: "valid", commands: []*PlatformCommand{{Name: "test", TargetPrefix: "test_"}, {Name: "build", TargetPrefix: "compile_"}}},
+		{name: "no name", commands: []*PlatformCommand{{TargetPrefix: "test_"}}, wantErr: "needs a name and a target prefix"},
+		{name: "no prefix", commands: []*PlatformCommand{{Name: "test"}}, wantErr: "needs a name and a target prefix"},
+		{name: "duplicate", commands: []*PlatformCommand{{Name: "test", TargetPrefix: "test_"}, {Name: "test", TargetPrefix: "check_"}}, wantErr: "command test is already registered"},
+	}
+	for _, test := range tests {
+		suite := NewBuildSuite(nil)
+		var err error
+		for _, command := range test.commands {
+			if err = suite.RegisterCommand(command); err != nil {
+				break
+			}
+		}
+		checkError(t, err, test.wantErr)
+	}
+}
+
+func TestPlatformCommands(t *testing.T) {
+	suite := NewBuildSuite(nil)
+	if err := suite.RegisterCommand(&PlatformCommand{Name: "test", TargetPrefix: "test_"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := suite.RegisterCommand(&PlatformCommand{Name: "build", TargetPrefix: "compile_"}); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := platformCommands(suite)
+	names := make([]string, len(commands))
+	for i, command := range commands {
+		names[i] = command.Name
+	}
+	if expected := []string{"build", "package", "archive", "test"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected the commands %v, got %v", expected, names)
+	}
+}
+
+func TestSelectTargets(t *testing.T) {
+	suite := NewBuildSuite(nil)
+	suite.RegisterTargets(
+		&flagTarget{name: "test_linux", platform: LinuxAmd64},
+		&flagTarget{name: "test_windows", platform: WindowsAmd64},
+		&flagTarget{name: "test_lint"},
+		&flagTarget{name: "build_linux", platform: LinuxAmd64},
+	)
+
+	tests := []struct {
+		platforms PlatformSet
+		names     []string
+	}{
+		{platforms: nil, names: []string{"test_lint", "test_linux", "test_windows"}},
+		{platforms: PlatformSet{LinuxAmd64}, names: []string{"test_lint", "test_linux"}},
+		{platforms: PlatformSet{LinuxArm64}, names: []string{"test_lint"}},
+	}
+	for _, test := range tests {
+		names := make([]string, 0)
+		for _, target := range selectTargets(suite, "test_", test.platforms) {
+			names = append(names, target.(NamedTarget).Name())
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("%v: expected %v, got %v", test.platforms, test.names, names)
+		}
+	}
+}
+
+func TestCLIAppPlatformCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		env      string
+		executed []string
+		value    string
+		wantErr  string
+	}{
+		{name: "os and arch", args: []string{"test", "--os", "linux", "--arch", "amd64"}, executed: []string{"test_lint", "test_linux"}},
+		{name: "platforms", args: []string{"test", "--platforms", "linux/amd64, windows/amd64"}, executed: []string{"test_lint", "test_linux", "test_windows"}},
+		{name: "release", args: []string{"--parallel", "test", "--release"}, executed: []string{"test_darwin", "test_lint", "test_linux", "test_windows"}},
+		{name: "global flag", args: []string{"--coverage", "cover.out", "test", "--release"}, executed: []string{"test_darwin", "test_lint", "test_linux", "test_windows"}, value: "cover.out"},
+		{name: "global flag from env", args: []string{"test", "--release"}, env: "env.out", executed: []string{"test_darwin", "test_lint", "test_linux", "test_windows"}, value: "env.out"},
+		{name: "command flag", args: []string{"test", "--race", "--release"}, executed: []string{"test_darwin", "test_lint", "test_linux", "test_windows"}, value: "true"},
+		{name: "unsupported platform", args: []string{"test", "--os", "linux", "--arch", "arm64"}, executed: []string{}, wantErr: "platform linux_arm64 is not supported"},
+		{name: "unsupported platforms", args: []string{"test", "--platforms", "linux/arm64,darwin/arm64"}, executed: []string{}, wantErr: "none of the platforms linux_arm64, darwin_arm64 is supported"},
+		{name: "invalid platforms", args: []string{"test", "--platforms", "linux"}, executed: []string{}, wantErr: "expected \"os/arch\""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chdir(t, t.TempDir())
+			t.Setenv("GOMAKE_COVERAGE", test.env)
+
+			flag := "coverage"
+			if test.name == "command flag" {
+				flag = "race"
+			}
+			targets := []*flagTarget{
+				{name: "test_linux", platform: LinuxAmd64, flag: flag},
+				{name: "test_windows", platform: WindowsAmd64, flag: flag},
+				{name: "test_darwin", platform: DarwinAmd64, flag: flag},
+				{name: "test_lint", flag: flag},
+			}
+			suite := NewBuildSuite(nil)
+			for _, target := range targets {
+				suite.RegisterTarget(target)
+			}
+			suite.RegisterFlag(cli.StringFlag{Name: "coverage", Usage: "The coverage profile."})
+			err := suite.RegisterCommand(&PlatformCommand{
+				Name:         "test",
+				TargetPrefix: "test_",
+				Flags:        []cli.Flag{cli.BoolFlag{Name: "race"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = runApp(t, CLIApp(suite), test.args...)
+			checkError(t, err, test.wantErr)
+
+			executed := make([]string, 0)
+			for _, target := range targets {
+				if target.executed > 1 {
+					t.Errorf("%s was executed %d times", target.name, target.executed)
+				}
+				if target.executed > 0 {
+					executed = append(executed, target.name)
+					if test.value != "" && target.value != test.value {
+						t.Errorf("%s: expected the flag value %q, got %q", target.name, test.value, target.value)
+					}
+				}
+			}
+			sort.Strings(executed)
+			if !reflect.DeepEqual(executed, test.executed) {
+				t.Errorf("expected %v to be executed, got %v", test.executed, executed)
+			}
+		})
+	}
+}
+
+func TestFlagValue(t *testing.T) {
+	suite := NewBuildSuite(nil)
+	if value := suite.FlagValue("parallel"); value != "" {
+		t.Errorf("expected no value without a CLIApp, got %q", value)
+	}
+
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{cli.BoolFlag{Name: "parallel"}, cli.IntFlag{Name: "jobs, j"}}
+	app.Action = func(c *cli.Context) error {
+		suite.setFlagValues(c.GlobalGeneric, app.Flags)
+		return nil
+	}
+	if err := runApp(t, app, "--parallel", "-j", "3"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		bool  bool
+	}{
+		{name: "parallel", value: "true", bool: true},
+		{name: "jobs", value: "3"},
+		{name: "unknown"},
+	}
+	for _, test := range tests {
+		if value := suite.FlagValue(test.name); value != test.value {
+			t.Errorf("%s: expected %q, got %q", test.name, test.value, value)
+		}
+		if value := suite.BoolFlagValue(test.name); value != test.bool {
+			t.Errorf("%s: expected %v, got %v", test.name, test.bool, value)
+		}
+	}
+}
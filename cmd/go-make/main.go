@@ -0,0 +1,358 @@
+// go-make runs the build script of the current module.
+//
+// The build script is the main package in the "make" or "build"
+// directory of the module root. It is compiled to the user cache
+// directory and rebuilt whenever the go version, go.mod, go.sum,
+// go.work or the sources of a package the script depends on change.
+// The sources of packages in the module, the workspace or local
+// replace directories are hashed, other dependencies are identified
+// by their module version. All arguments are passed to the script,
+// which is run in the module root, and its exit code is returned,
+// so if the script uses make.CLIApp
+//
+//	go-make build --release
+//
+// builds all platforms. Set GOMAKE_REBUILD=1 to force recompiling.
+//
+// If there is no build script a configuration file (see
+// make.DefaultConfigFiles) in the module root is loaded instead.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	gomake "github.com/targodan/go-make"
+)
+
+// scriptDirs are the directories searched for the build script
+// in order of preference.
+var scriptDirs = []string{"make", "build"}
+
+func main() {
+	root, err := moduleRoot()
+	if err != nil {
+		fail(err)
+	}
+
+	dir, err := findScript(root)
+	if err != nil {
+		fail(err)
+	}
+	if dir == "" {
+		os.Exit(runConfig(root))
+	}
+
+	executable, err := compileScript(root, dir)
+	if err != nil {
+		fail(err)
+	}
+	os.Exit(runScript(root, executable))
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "go-make: %v\n", err)
+	os.Exit(1)
+}
+
+// moduleRoot returns the first directory containing a go.mod
+// walking up from the working directory.
+func moduleRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no go.mod found, go-make must be run inside a module")
+		}
+		dir = parent
+	}
+}
+
+// findScript returns the first script directory containing
+// a main package. If there is none an empty directory is
+// returned.
+func findScript(root string) (string, error) {
+	for _, name := range scriptDirs {
+		dir := filepath.Join(root, name)
+		matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil {
+			return "", err
+		}
+
+		for _, file := range matches {
+			if strings.HasSuffix(file, "_test.go") {
+				continue
+			}
+			parsed, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.PackageClauseOnly)
+			if err != nil {
+				return "", err
+			}
+			if parsed.Name.Name == "main" {
+				return dir, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// compileScript builds the script unless it is cached and
+// returns the path of the executable.
+func compileScript(root, dir string) (string, error) {
+	relative, err := filepath.Rel(root, dir)
+	if err != nil {
+		return "", err
+	}
+	pkg := "./" + filepath.ToSlash(relative)
+	key, err := cacheKey(root, pkg)
+	if err != nil {
+		return "", err
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	cacheDir = filepath.Join(cacheDir, "go-make")
+	executable := filepath.Join(cacheDir, key)
+	if runtime.GOOS == "windows" {
+		executable += ".exe"
+	}
+
+	if _, err := os.Stat(executable); err == nil && os.Getenv("GOMAKE_REBUILD") == "" {
+		return executable, nil
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(cacheDir, "."+filepath.Base(executable)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	fmt.Fprintln(os.Stderr, "Compiling build script:", relative)
+	cmd := exec.Command("go", "build", "-o", tmp.Name(), pkg)
+	cmd.Dir = root
+	cmd.Env = hostEnv()
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("could not compile the build script: %v", err)
+	}
+	return executable, os.Rename(tmp.Name(), executable)
+}
+
+// listedPackage is the part of the output of go list -json
+// used by cacheKey.
+type listedPackage struct {
+	Dir      string
+	Standard bool
+	Module   *struct {
+		Path    string
+		Version string
+		Main    bool
+		GoMod   string
+		Replace *struct {
+			Path    string
+			Version string
+		}
+	}
+
+	GoFiles    []string
+	CgoFiles   []string
+	CFiles     []string
+	CXXFiles   []string
+	HFiles     []string
+	SFiles     []string
+	SysoFiles  []string
+	EmbedFiles []string
+}
+
+// isLocal returns true if the package is not part of a module
+// version in the module cache, e. g. part of the main module, the
+// workspace or a replace directory.
+func (p *listedPackage) isLocal() bool {
+	return p.Module == nil || p.Module.Main || p.Module.Replace != nil && p.Module.Replace.Version == ""
+}
+
+// cacheKey hashes everything the compiled script package depends on.
+func cacheKey(root, pkg string) (string, error) {
+	out, err := goOutput(root, "env", "GOVERSION", "GOWORK")
+	if err != nil {
+		return "", fmt.Errorf("could not determine the go environment: %v", err)
+	}
+	env := strings.Split(out, "\n")
+	goVersion, goWork := env[0], ""
+	if len(env) > 1 && env[1] != "off" {
+		goWork = env[1]
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s/%s\x00%s\x00", root, goVersion, runtime.GOOS, runtime.GOARCH, os.Getenv("GOFLAGS"))
+
+	files := map[string]bool{
+		filepath.Join(root, "go.mod"): true,
+		filepath.Join(root, "go.sum"): true,
+	}
+	if goWork != "" {
+		files[goWork] = true
+		files[goWork+".sum"] = true
+	}
+
+	// Errors are reported by go build later.
+	out, err = goOutput(root, "list", "-e", "-deps", "-json", pkg)
+	if err != nil {
+		return "", fmt.Errorf("could not list the dependencies of the build script: %v", err)
+	}
+	versions := make([]string, 0)
+	decoder := json.NewDecoder(strings.NewReader(out))
+	for decoder.More() {
+		p := &listedPackage{}
+		if err := decoder.Decode(p); err != nil {
+			return "", err
+		}
+		if p.Standard {
+			continue
+		}
+		if !p.isLocal() {
+			version := p.Module.Path + "@" + p.Module.Version
+			if p.Module.Replace != nil {
+				version += "=>" + p.Module.Replace.Path + "@" + p.Module.Replace.Version
+			}
+			versions = append(versions, version)
+			continue
+		}
+
+		if p.Module != nil && p.Module.GoMod != "" {
+			files[p.Module.GoMod] = true
+		}
+		for _, names := range [][]string{p.GoFiles, p.CgoFiles, p.CFiles, p.CXXFiles, p.HFiles, p.SFiles, p.SysoFiles, p.EmbedFiles} {
+			for _, name := range names {
+				files[filepath.Join(p.Dir, name)] = true
+			}
+		}
+	}
+
+	sort.Strings(versions)
+	for _, version := range versions {
+		fmt.Fprintf(hash, "%s\x00", version)
+	}
+
+	sorted := make([]string, 0, len(files))
+	for file := range files {
+		sorted = append(sorted, file)
+	}
+	sort.Strings(sorted)
+	for _, file := range sorted {
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s\x00", file)
+		_, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hostEnv returns the environment with GOOS and GOARCH set to
+// the host, so the script runs here even if they are set for
+// cross-compiling the project.
+func hostEnv() []string {
+	env := make([]string, 0)
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, "GOOS=") && !strings.HasPrefix(v, "GOARCH=") {
+			env = append(env, v)
+		}
+	}
+	return append(env, "GOOS="+runtime.GOOS, "GOARCH="+runtime.GOARCH)
+}
+
+// goOutput runs the go command in dir and returns its output
+// without the trailing newline.
+func goOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = hostEnv()
+	stderr := &strings.Builder{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// runScript runs the executable with the arguments of go-make
+// and returns its exit code.
+func runScript(root, executable string) int {
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Dir = root
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		fail(err)
+	}
+	return 0
+}
+
+// runConfig runs the CLIApp of the Suite loaded from the
+// configuration file.
+func runConfig(root string) int {
+	filename, err := gomake.FindConfig(root)
+	if err != nil {
+		fail(fmt.Errorf("no build script in %s and %v", strings.Join(scriptDirs, " or "), err))
+	}
+	if err := os.Chdir(root); err != nil {
+		fail(err)
+	}
+
+	config, err := gomake.LoadConfig(filename)
+	if err != nil {
+		fail(err)
+	}
+	suite, err := config.NewSuite()
+	if err != nil {
+		fail(err)
+	}
+
+	app := gomake.CLIApp(suite)
+	app.Name = "go-make"
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
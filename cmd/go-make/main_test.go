@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes the files relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindScript(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		found string
+	}{
+		{name: "make", files: map[string]string{"make/main.go": "package main\n", "build/main.go": "package main\n"}, found: "make"},
+		{name: "build", files: map[string]string{"make/make.go": "package make\n", "build/main.go": "package main\n"}, found: "build"},
+		{name: "only tests", files: map[string]string{"make/make.go": "package make\n", "make/main_test.go": "package main\n"}},
+		{name: "none", files: map[string]string{"main.go": "package main\n"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, test.files)
+			dir, err := findScript(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := ""
+			if test.found != "" {
+				expected = filepath.Join(root, test.found)
+			}
+			if dir != expected {
+				t.Errorf("expected %q, got %q", expected, dir)
+			}
+		})
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		changed bool
+	}{
+		{name: "script", files: map[string]string{"app/make/main.go": "package main\n\nimport _ \"example.com/app/internal/version\"\nimport _ \"example.com/lib\"\n\nfunc main() { println() }\n"}, changed: true},
+		{name: "module package", files: map[string]string{"app/internal/version/version.go": "package version\n\nconst Version = \"2\"\n"}, changed: true},
+		{name: "embedded file", files: map[string]string{"app/internal/version/VERSION": "2\n"}, changed: true},
+		{name: "replace directory", files: map[string]string{"lib/lib.go": "package lib\n\nconst Name = \"other\"\n"}, changed: true},
+		{name: "workspace", files: map[string]string{"app/go.work": "go 1.18\n\nuse .\n"}, changed: true},
+		{name: "unrelated package", files: map[string]string{"app/cmd/app/main.go": "package main\n\nfunc main() { println() }\n"}},
+		{name: "unrelated file", files: map[string]string{"app/README.md": "changed\n"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"app/go.mod":                      "module example.com/app\n\ngo 1.18\n\nrequire example.com/lib v0.0.0\n\nreplace example.com/lib => ../lib\n",
+				"app/make/main.go":                "package main\n\nimport _ \"example.com/app/internal/version\"\nimport _ \"example.com/lib\"\n\nfunc main() {}\n",
+				"app/internal/version/version.go": "package version\n\nimport _ \"embed\"\n\n//go:embed VERSION\nvar Version string\n",
+				"app/internal/version/VERSION":    "1\n",
+				"app/cmd/app/main.go":             "package main\n\nfunc main() {}\n",
+				"app/README.md":                   "readme\n",
+				"lib/go.mod":                      "module example.com/lib\n\ngo 1.18\n",
+				"lib/lib.go":                      "package lib\n\nconst Name = \"lib\"\n",
+			})
+			root := filepath.Join(dir, "app")
+			t.Setenv("GOWORK", "")
+			t.Setenv("GOFLAGS", "")
+
+			before, err := cacheKey(root, "./make")
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeFiles(t, dir, test.files)
+			after, err := cacheKey(root, "./make")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := before != after; changed != test.changed {
+				t.Errorf("expected changed %v, got %v", test.changed, changed)
+			}
+		})
+	}
+}
+
+func TestCompileScriptCrossCompiling(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod":         "module example.com/app\n\ngo 1.18\n",
+		"make/main.go":   "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Print(message) }\n",
+		"make/host.go":   "//go:build !plan9\n\npackage main\n\nconst message = \"host\"\n",
+		"make/target.go": "//go:build plan9\n\npackage main\n\nconst message = \"target\"\n",
+	})
+	// The build cache moves with the user cache directory.
+	goCache, err := exec.Command("go", "env", "GOCACHE").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOCACHE", strings.TrimSpace(string(goCache)))
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("GOWORK", "")
+	t.Setenv("GOFLAGS", "")
+
+	key, err := cacheKey(root, "./make")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOOS", "plan9")
+	t.Setenv("GOARCH", "arm")
+	if crossKey, err := cacheKey(root, "./make"); err != nil {
+		t.Fatal(err)
+	} else if crossKey != key {
+		t.Error("expected the key of the host")
+	}
+
+	executable, err := compileScript(root, filepath.Join(root, "make"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Unsetenv("GOOS")
+	os.Unsetenv("GOARCH")
+	out, err := exec.Command(executable).Output()
+	if err != nil {
+		t.Fatalf("the script is not runnable on %s/%s: %v", runtime.GOOS, runtime.GOARCH, err)
+	}
+	if string(out) != "host" {
+		t.Errorf("expected the script to be built for the host, got %q", out)
+	}
+}
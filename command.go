@@ -0,0 +1,210 @@
+package make
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// CommandTarget is a Target that runs an arbitrary command,
+// e. g. protoc, npm or docker.
+//
+// All arguments, the working directory and the values of the
+// environment overrides are templates. They will receive
+// TemplateData as ".", so "{{.OS}}", "{{.Arch}}" and "{{.Version}}"
+// can be used as well as the functions of TemplateFuncs.
+type CommandTarget struct {
+	// TargetName is the name of the target. If a Platform is
+	// set it will be appended to the name.
+	TargetName string
+
+	// Command contains the executable followed by its arguments.
+	Command []string
+	// Dir is the working directory of the command. If empty
+	// the current working directory will be used.
+	Dir string
+	// Env contains environment variables that will be set in
+	// addition to the environment of this process.
+	Env map[string]string
+	// Stdin is the standard input of the command. If nil
+	// the command will receive no input.
+	Stdin io.Reader
+	// Timeout is the maximum duration the command may run.
+	// Zero means no timeout. With a timeout the command runs in
+	// its own process group on Unix, which is killed including
+	// the children of the command once the timeout expires.
+	Timeout time.Duration
+	// ExpectedExitCodes contains all exit codes that are
+	// considered a success. If empty only 0 is a success.
+	ExpectedExitCodes []int
+
+	// The optional Platform the command is run for. If set
+	// GOOS and GOARCH will be set accordingly.
+	Platform *Platform
+	// The optional Version passed to the templates.
+	Version Version
+
+	// Where to redirect the commands stdout. If nil
+	// stdout will be redirected to this precesses stdout.
+	Stdout io.Writer
+	// Where to redirect the commands stderr. If nil
+	// stderr will be redirected to this precesses stderr.
+	Stderr io.Writer
+}
+
+// Copy copies a CommandTarget.
+func (t *CommandTarget) Copy() *CommandTarget {
+	copy := *t
+	return &copy
+}
+
+// MultiPlatform returns one CommandTarget based on
+// the current command target for each platform.
+func (t *CommandTarget) MultiPlatform(platforms PlatformSet) []*CommandTarget {
+	newTargets := make([]*CommandTarget, len(platforms))
+	for i, platform := range platforms {
+		newTargets[i] = t.Copy()
+		newTargets[i].Platform = platform
+	}
+	return newTargets
+}
+
+// commandWaitDelay is how long a command that timed out may keep
+// its output open, e. g. through children that escaped its process
+// group, before it is abandoned.
+const commandWaitDelay = 5 * time.Second
+
+// ConvertCommandTargetSlice converts a *CommandTarget slice to a Target slice.
+func ConvertCommandTargetSlice(commandTargets []*CommandTarget) []Target {
+	ret := make([]Target, len(commandTargets))
+	for i := range commandTargets {
+		ret[i] = commandTargets[i]
+	}
+	return ret
+}
+
+// Execute runs the command.
+func (t *CommandTarget) Execute(suite *Suite) error {
+	if len(t.Command) == 0 {
+		return fmt.Errorf("target %s has no command", t.Name())
+	}
+	if t.Platform != nil {
+		if err := suite.CheckPlatform(t.Platform); err != nil {
+			return err
+		}
+	}
+
+	ctx := context.Background()
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+
+	cmd, err := t.makeCommand(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Running command:", strings.Join(cmd.Args, " "))
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command %s timed out after %s", cmd.Args[0], t.Timeout)
+	}
+	return t.checkExitCode(cmd.Args[0], err)
+}
+
+func (t *CommandTarget) makeCommand(ctx context.Context) (*exec.Cmd, error) {
+	data := NewTemplateData(t.Platform, t.Version, "")
+
+	args := make([]string, len(t.Command))
+	for i, arg := range t.Command {
+		var err error
+		args[i], err = executeTemplate("arg", arg, data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid argument \"%s\": %v", arg, err)
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if t.Timeout > 0 {
+		setProcessGroup(cmd)
+		cmd.WaitDelay = commandWaitDelay
+	}
+
+	if t.Dir != "" {
+		dir, err := executeTemplate("dir", t.Dir, data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid directory \"%s\": %v", t.Dir, err)
+		}
+		cmd.Dir = dir
+	}
+
+	cmd.Stdin = t.Stdin
+	if t.Stdout != nil {
+		cmd.Stdout = t.Stdout
+	} else {
+		cmd.Stdout = os.Stdout
+	}
+	if t.Stderr != nil {
+		cmd.Stderr = t.Stderr
+	} else {
+		cmd.Stderr = os.Stderr
+	}
+
+	cmd.Env = os.Environ()
+	if t.Platform != nil {
+		cmd.Env = setEnv(cmd.Env, "GOOS", t.Platform.OS.String())
+		cmd.Env = setEnv(cmd.Env, "GOARCH", t.Platform.Arch.String())
+	}
+	for key, value := range t.Env {
+		value, err := executeTemplate("env", value, data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of environment variable %s: %v", key, err)
+		}
+		cmd.Env = setEnv(cmd.Env, key, value)
+	}
+
+	return cmd, nil
+}
+
+func (t *CommandTarget) checkExitCode(command string, err error) error {
+	exitCode := 0
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return fmt.Errorf("error running %s: %v", command, err)
+		}
+		exitCode = exitErr.ExitCode()
+	}
+
+	expected := t.ExpectedExitCodes
+	if len(expected) == 0 {
+		expected = []int{0}
+	}
+	for _, code := range expected {
+		if code == exitCode {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s exited with unexpected code %d", command, exitCode)
+}
+
+// TargetPlatform returns the Platform of this Target.
+func (t *CommandTarget) TargetPlatform() *Platform {
+	return t.Platform
+}
+
+// Name returns the name of this Target.
+// The name will consist of the TargetName followed by
+// the Platform name if present.
+func (t *CommandTarget) Name() string {
+	if t.Platform == nil {
+		return t.TargetName
+	}
+	return t.TargetName + "_" + t.Platform.String()
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package make
+
+import "os/exec"
+
+// setProcessGroup does nothing, only the command itself is
+// killed when the context is done.
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,143 @@
+package make
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandTargetName(t *testing.T) {
+	tests := []struct {
+		target *CommandTarget
+		name   string
+	}{
+		{&CommandTarget{TargetName: "protoc"}, "protoc"},
+		{&CommandTarget{TargetName: "protoc", Platform: LinuxAmd64}, "protoc_linux_amd64"},
+	}
+	for _, test := range tests {
+		if name := test.target.Name(); name != test.name {
+			t.Errorf("expected name %s, got %s", test.name, name)
+		}
+	}
+}
+
+func TestCommandTargetExecute(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  *CommandTarget
+		stdout  string
+		wantErr string
+	}{
+		{
+			name:   "templated arguments",
+			target: &CommandTarget{Command: []string{"echo", "{{.OS}}-{{.Arch}}", "{{.Version}}"}, Platform: LinuxAmd64, Version: BasicVersion("1.2.3")},
+			stdout: "linux-amd64 1.2.3\n",
+		},
+		{
+			name:   "environment",
+			target: &CommandTarget{Command: []string{"sh", "-c", "echo $FOO $GOOS"}, Env: map[string]string{"FOO": "{{upper \"bar\"}}"}, Platform: LinuxAmd64},
+			stdout: "BAR linux\n",
+		},
+		{
+			name:   "stdin",
+			target: &CommandTarget{Command: []string{"cat"}, Stdin: strings.NewReader("input")},
+			stdout: "input",
+		},
+		{
+			name:   "templated directory",
+			target: &CommandTarget{Command: []string{"pwd"}, Dir: "/{{lower \"TMP\"}}"},
+			stdout: "/tmp\n",
+		},
+		{
+			name:   "expected exit code",
+			target: &CommandTarget{Command: []string{"sh", "-c", "exit 3"}, ExpectedExitCodes: []int{0, 3}},
+		},
+		{
+			name:    "unexpected exit code",
+			target:  &CommandTarget{Command: []string{"sh", "-c", "exit 3"}},
+			wantErr: "unexpected code 3",
+		},
+		{
+			name:    "timeout",
+			target:  &CommandTarget{Command: []string{"sleep", "5"}, Timeout: 50 * time.Millisecond},
+			wantErr: "timed out",
+		},
+		{
+			name:    "no command",
+			target:  &CommandTarget{TargetName: "empty"},
+			wantErr: "has no command",
+		},
+		{
+			name:    "unsupported platform",
+			target:  &CommandTarget{Command: []string{"true"}, Platform: WindowsAmd64},
+			wantErr: "not supported",
+		},
+		{
+			name:    "broken template",
+			target:  &CommandTarget{Command: []string{"echo", "{{.Missing"}},
+			wantErr: "invalid argument",
+		},
+	}
+
+	suite := NewBuildSuite(PlatformSet{LinuxAmd64})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stdout := &bytes.Buffer{}
+			test.target.Stdout = stdout
+			test.target.Stderr = &bytes.Buffer{}
+
+			err := test.target.Execute(suite)
+			checkError(t, err, test.wantErr)
+			if stdout.String() != test.stdout {
+				t.Errorf("expected stdout %q, got %q", test.stdout, stdout.String())
+			}
+		})
+	}
+}
+
+func TestCommandTargetTimeoutKillsChildren(t *testing.T) {
+	// The child keeps the output open after the shell is killed.
+	target := &CommandTarget{
+		Command: []string{"sh", "-c", "sleep 30 & sleep 30"},
+		Timeout: 50 * time.Millisecond,
+		Stdout:  &bytes.Buffer{},
+		Stderr:  &bytes.Buffer{},
+	}
+	start := time.Now()
+	checkError(t, target.Execute(NewBuildSuite(nil)), "timed out")
+	if elapsed := time.Since(start); elapsed >= commandWaitDelay {
+		t.Errorf("expected the children to be killed, waited %s", elapsed)
+	}
+}
+
+func TestCommandTargetMultiPlatform(t *testing.T) {
+	base := &CommandTarget{TargetName: "gen", Command: []string{"true"}}
+	targets := base.MultiPlatform(PlatformSet{LinuxAmd64, WindowsAmd64})
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(targets))
+	}
+	if targets[0].Name() != "gen_linux_amd64" || targets[1].Name() != "gen_windows_amd64" {
+		t.Errorf("unexpected names %s and %s", targets[0].Name(), targets[1].Name())
+	}
+	if base.Platform != nil {
+		t.Errorf("base target was modified")
+	}
+}
+
+// checkError fails the test if err does not contain wantErr or,
+// if wantErr is empty, if err is not nil.
+func checkError(t *testing.T, err error, wantErr string) {
+	t.Helper()
+	if wantErr == "" {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		return
+	}
+	if err == nil {
+		t.Errorf("expected error containing %q, got nil", wantErr)
+	} else if !strings.Contains(err.Error(), wantErr) {
+		t.Errorf("expected error containing %q, got %v", wantErr, err)
+	}
+}
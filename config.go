@@ -0,0 +1,504 @@
+package make
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hashicorp/go-version"
+	"gopkg.in/yaml.v2"
+)
+
+// ReleaseTargetName is the name of the Target registered by
+// configurations that builds and archives all platforms.
+const ReleaseTargetName = "release"
+
+// DefaultConfigFiles are the names of configuration files
+// searched by FindConfig in order of preference.
+var DefaultConfigFiles = []string{"gomake.yml", "gomake.yaml", "gomake.toml"}
+
+// Config describes a Suite, so simple projects do not need to
+// write Go code. It can be read from YAML or TOML files:
+//
+//	name: app
+//	output: dist
+//	platforms: [linux/amd64, windows/amd64]
+//	binaries:
+//	  - name: app
+//	    package: ./cmd/app
+//	    version_variable: main.version
+//	    variables:
+//	      main.commit: "{{.Git.Commit}}"
+//	archives:
+//	  - format: tar.gz
+//	    files: [README.md, LICENSE]
+//	hooks:
+//	  before: ["go generate ./..."]
+//
+// The Suite returned by NewSuite can still be extended in Go.
+type Config struct {
+	// Name is the name of the project. It is the default name of
+	// binaries and the base name of archives.
+	Name string `yaml:"name" toml:"name"`
+	// Version defaults to the version from git, see GitVersion.
+	Version string `yaml:"version" toml:"version"`
+	// Output is the OutputRoot of the Suite.
+	Output string `yaml:"output" toml:"output"`
+	// Layout is the OutputLayout template of the Suite.
+	Layout string `yaml:"layout" toml:"layout"`
+	// Platforms contains platforms like "linux/amd64" or
+	// "darwin/universal". Defaults to the native platform.
+	Platforms []string `yaml:"platforms" toml:"platforms"`
+	// Reproducible enables reproducible builds of all binaries.
+	Reproducible bool `yaml:"reproducible" toml:"reproducible"`
+
+	Binaries []BinaryConfig  `yaml:"binaries" toml:"binaries"`
+	Archives []ArchiveConfig `yaml:"archives" toml:"archives"`
+	Hooks    HooksConfig     `yaml:"hooks" toml:"hooks"`
+}
+
+// BinaryConfig describes the BuildTargets of one executable.
+type BinaryConfig struct {
+	// Name is the base name of the executable.
+	// Defaults to the Name of the project.
+	Name string `yaml:"name" toml:"name"`
+	// Package is the main package, e. g. "./cmd/app".
+	Package string `yaml:"package" toml:"package"`
+	// Executable is the template of the executable name.
+	// Defaults to DefaultNameTemplate of the Name.
+	Executable string `yaml:"executable" toml:"executable"`
+	// VersionVariable is the full name of the variable
+	// holding the version string, e. g. "main.version".
+	VersionVariable string `yaml:"version_variable" toml:"version_variable"`
+	// Variables are set via -ldflags -X, see BuildTarget.
+	Variables map[string]string `yaml:"variables" toml:"variables"`
+	// Flags are additional flags passed to go build.
+	Flags []string `yaml:"flags" toml:"flags"`
+	// Platforms overrides the Platforms of the project.
+	Platforms []string `yaml:"platforms" toml:"platforms"`
+}
+
+// ArchiveConfig describes archives containing all binaries
+// of a platform.
+type ArchiveConfig struct {
+	// Format is "tar.gz" or "zip".
+	Format string `yaml:"format" toml:"format"`
+	// Name is the archive name template without the extension.
+	// Defaults to DefaultArchiveName.
+	Name string `yaml:"name" toml:"name"`
+	// Files contains additional files, e. g. "README.md".
+	Files []string `yaml:"files" toml:"files"`
+	// Platforms restricts the archived platforms. Defaults to
+	// the platforms of the binaries.
+	Platforms []string `yaml:"platforms" toml:"platforms"`
+}
+
+// HooksConfig contains commands run before and after building.
+// The commands are split into arguments like a shell does, so
+// arguments containing spaces can be quoted with single or double
+// quotes or escaped with a backslash, e. g. `sh -c "go generate
+// ./..."`. Nothing else is interpreted, there are no variables,
+// globs or pipes. The arguments are templates like the ones of
+// CommandTargets.
+type HooksConfig struct {
+	// Before contains commands run once before the first build.
+	Before []string `yaml:"before" toml:"before"`
+	// After contains commands run by the release target after
+	// all binaries are built and archived.
+	After []string `yaml:"after" toml:"after"`
+}
+
+// LoadConfig reads a configuration file. The format is
+// determined by the extension, ".yml", ".yaml" or ".toml".
+func LoadConfig(filename string) (*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yml", ".yaml":
+		err = yaml.UnmarshalStrict(data, config)
+	case ".toml":
+		var meta toml.MetaData
+		meta, err = toml.Decode(string(data), config)
+		if err == nil && len(meta.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown key %s", meta.Undecoded()[0])
+		}
+	default:
+		return nil, fmt.Errorf("unknown configuration format of %s", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %v", filename, err)
+	}
+	return config, nil
+}
+
+// FindConfig returns the path of the first of the
+// DefaultConfigFiles existing in the directory.
+func FindConfig(dir string) (string, error) {
+	for _, name := range DefaultConfigFiles {
+		filename := filepath.Join(dir, name)
+		if _, err := os.Stat(filename); err == nil {
+			return filename, nil
+		}
+	}
+	return "", fmt.Errorf("no configuration file found in %s", dir)
+}
+
+// NewSuite creates a Suite supporting all configured platforms
+// and registers the configured Targets.
+func (c *Config) NewSuite() (*Suite, error) {
+	platforms, err := parsePlatforms(c.Platforms)
+	if err != nil {
+		return nil, err
+	}
+	for _, binary := range c.Binaries {
+		if len(binary.Platforms) == 0 {
+			continue
+		}
+		binaryPlatforms, err := parsePlatforms(binary.Platforms)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range binaryPlatforms {
+			if ok, _ := platforms.Contains(p); !ok {
+				platforms = append(platforms, p)
+			}
+		}
+	}
+
+	// Universal binaries are merged from both darwin architectures.
+	if ok, _ := platforms.Contains(DarwinUniversal); ok {
+		for _, p := range universalInputPlatforms {
+			if ok, _ := platforms.Contains(p); !ok {
+				platforms = append(platforms, p)
+			}
+		}
+	}
+
+	suite := NewBuildSuite(platforms)
+	suite.OutputRoot = c.Output
+	if c.Layout != "" {
+		suite.OutputLayout, err = NameTemplate(c.Layout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid layout: %v", err)
+		}
+	}
+
+	if err := c.Register(suite); err != nil {
+		return nil, err
+	}
+	return suite, nil
+}
+
+// Register registers the configured Targets with the Suite:
+// a BuildTarget for each binary and platform, an ArchiveTarget
+// for each archive and platform, CommandTargets for the hooks
+// and a FuncTarget named ReleaseTargetName. The platform
+// "darwin/universal" registers a UniversalBinaryTarget merging
+// the darwin/amd64 and darwin/arm64 BuildTargets.
+func (c *Config) Register(suite *Suite) error {
+	versionString := c.Version
+	if versionString == "" {
+		gitVersion, err := GitVersion(".")
+		if err != nil {
+			return fmt.Errorf("no version configured and none found in git: %v", err)
+		}
+		versionString = gitVersion.String()
+	}
+	var v Version = BasicVersion(versionString)
+	if parsed, err := version.NewVersion(versionString); err == nil {
+		v = parsed
+	}
+
+	register := func(target NamedTarget) error {
+		if suite.Lookup(target.Name()) != nil {
+			return fmt.Errorf("target %s is configured multiple times", target.Name())
+		}
+		return suite.RegisterTargetE(target)
+	}
+
+	before, err := c.hookTargets("before", c.Hooks.Before, v)
+	if err != nil {
+		return err
+	}
+	after, err := c.hookTargets("after", c.Hooks.After, v)
+	if err != nil {
+		return err
+	}
+	for _, hook := range append(before, after...) {
+		if err := register(hook); err != nil {
+			return err
+		}
+	}
+
+	release := make([]Target, 0)
+	// The binaries by platform and the platforms
+	// in the order they are configured.
+	binaries := make(map[string][]ArchiveBinary)
+	platforms := make(PlatformSet, 0)
+	for _, binary := range c.Binaries {
+		targets, outputs, err := c.buildTargets(binary, v, before)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			if err := register(target); err != nil {
+				return err
+			}
+		}
+		for _, output := range outputs {
+			p := output.TargetPlatform()
+			if ok, _ := platforms.Contains(p); !ok {
+				platforms = append(platforms, p)
+			}
+			binaries[p.String()] = append(binaries[p.String()], output)
+			release = append(release, output)
+		}
+	}
+
+	for _, archive := range c.Archives {
+		targets, err := c.archiveTargets(archive, binaries, platforms)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			if err := register(target); err != nil {
+				return err
+			}
+			release = append(release, target)
+		}
+	}
+
+	for _, hook := range after {
+		release = append(release, hook)
+	}
+	return register(NewFuncTarget(ReleaseTargetName, "Builds and archives all platforms and runs the after hooks.", nil, release...))
+}
+
+// universalInputPlatforms are the platforms merged into
+// universal binaries.
+var universalInputPlatforms = PlatformSet{DarwinAmd64, DarwinArm64}
+
+// buildTargets returns the Targets to register for the binary and
+// the ones producing the executables of the configured platforms.
+func (c *Config) buildTargets(binary BinaryConfig, v Version, before []*CommandTarget) ([]NamedTarget, []ArchiveBinary, error) {
+	name := binary.Name
+	if name == "" {
+		name = c.Name
+	}
+	if name == "" {
+		return nil, nil, fmt.Errorf("binary of package %s has no name", binary.Package)
+	}
+
+	executableName := DefaultNameTemplate(name)
+	if binary.Executable != "" {
+		var err error
+		executableName, err = NameTemplate(binary.Executable)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid executable name of binary %s: %v", name, err)
+		}
+	}
+
+	platforms := binary.Platforms
+	if len(platforms) == 0 {
+		platforms = c.Platforms
+	}
+	parsed, err := parsePlatforms(platforms)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The inputs of a universal binary are only
+	// outputs if they are configured as well.
+	buildPlatforms := make(PlatformSet, 0, len(parsed))
+	universal := false
+	for _, p := range parsed {
+		if p.Equals(DarwinUniversal) {
+			universal = true
+		} else {
+			buildPlatforms = append(buildPlatforms, p)
+		}
+	}
+	if universal {
+		for _, p := range universalInputPlatforms {
+			if ok, _ := buildPlatforms.Contains(p); !ok {
+				buildPlatforms = append(buildPlatforms, p)
+			}
+		}
+	}
+
+	base := &BuildTarget{
+		ExecutableName:       executableName,
+		BaseName:             name,
+		Package:              binary.Package,
+		Version:              v,
+		VersionVariableName:  binary.VersionVariable,
+		Variables:            binary.Variables,
+		AdditionalBuildFlags: binary.Flags,
+		Reproducible:         c.Reproducible,
+	}
+	// The name only contains the binary if needed, so the
+	// targets of single binary projects have the usual names.
+	if len(c.Binaries) > 1 {
+		base.Binary = name
+	}
+	for _, hook := range before {
+		base.Dependencies = append(base.Dependencies, hook)
+	}
+
+	targets := make([]NamedTarget, 0, len(buildPlatforms)+1)
+	outputs := make([]ArchiveBinary, 0, len(parsed))
+	builds := base.MultiPlatform(buildPlatforms)
+	for _, build := range builds {
+		targets = append(targets, build)
+		if ok, _ := parsed.Contains(build.Platform); ok {
+			outputs = append(outputs, build)
+		}
+	}
+	if universal {
+		inputs := make([]*BuildTarget, 0, len(universalInputPlatforms))
+		for _, build := range builds {
+			if ok, _ := universalInputPlatforms.Contains(build.Platform); ok {
+				inputs = append(inputs, build)
+			}
+		}
+		target := NewUniversalBinaryTarget(inputs)
+		targets = append(targets, target)
+		outputs = append(outputs, target)
+	}
+	return targets, outputs, nil
+}
+
+func (c *Config) archiveTargets(archive ArchiveConfig, binaries map[string][]ArchiveBinary, platforms PlatformSet) ([]*ArchiveTarget, error) {
+	base := &ArchiveTarget{
+		Format:   ArchiveFormat(archive.Format),
+		BaseName: c.Name,
+		Files:    archive.Files,
+	}
+	if archive.Name != "" {
+		var err error
+		base.ArchiveName, err = NameTemplate(archive.Name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive name: %v", err)
+		}
+	}
+
+	if len(archive.Platforms) > 0 {
+		var err error
+		platforms, err = parsePlatforms(archive.Platforms)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	targets := make([]*ArchiveTarget, len(platforms))
+	for i, p := range platforms {
+		if len(binaries[p.String()]) == 0 {
+			return nil, fmt.Errorf("no binaries to archive for %s", p)
+		}
+		copy := *base
+		copy.Binaries = binaries[p.String()]
+		targets[i] = &copy
+	}
+	return targets, nil
+}
+
+func (c *Config) hookTargets(kind string, commands []string, v Version) ([]*CommandTarget, error) {
+	targets := make([]*CommandTarget, len(commands))
+	for i, command := range commands {
+		args, err := splitCommand(command)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s hook %d: %v", kind, i, err)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s hook %d is empty", kind, i)
+		}
+		targets[i] = &CommandTarget{
+			TargetName: fmt.Sprintf("hook_%s_%d", kind, i),
+			Command:    args,
+			Version:    v,
+		}
+	}
+	return targets, nil
+}
+
+// splitCommand splits the command line into arguments at
+// unquoted whitespace. Single quotes preserve everything up to the
+// closing quote, in double quotes and outside of quotes backslashes
+// escape the next character.
+func splitCommand(command string) ([]string, error) {
+	args := make([]string, 0)
+	arg := &strings.Builder{}
+	// inArg is true if an argument was started, which may
+	// be empty like "".
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, c := range command {
+		switch {
+		case escaped:
+			if quote == '"' && c != '"' && c != '\\' {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case unicode.IsSpace(c):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash in %s", command)
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %s", quote, command)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// parsePlatforms parses platforms like "linux/amd64". An empty
+// list results in the native platform.
+func parsePlatforms(texts []string) (PlatformSet, error) {
+	if len(texts) == 0 {
+		texts = []string{"native/native"}
+	}
+
+	platforms := make(PlatformSet, len(texts))
+	for i, text := range texts {
+		parts := strings.SplitN(text, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid platform \"%s\", expected \"os/arch\"", text)
+		}
+		var err error
+		platforms[i], err = ParsePlatform(parts[0], parts[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return platforms, nil
+}
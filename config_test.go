@@ -0,0 +1,297 @@
+package make
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	expected := &Config{
+		Name:      "app",
+		Output:    "dist",
+		Platforms: []string{"linux/amd64", "darwin/universal"},
+		Binaries: []BinaryConfig{{
+			Package:         "./cmd/app",
+			VersionVariable: "main.version",
+			Variables:       map[string]string{"main.commit": "{{.Git.Commit}}"},
+		}},
+		Archives: []ArchiveConfig{{Format: "tar.gz", Files: []string{"README.md"}}},
+		Hooks:    HooksConfig{Before: []string{`sh -c "go generate ./..."`}},
+	}
+
+	tests := []struct {
+		filename string
+		content  string
+		config   *Config
+		wantErr  string
+	}{
+		{
+			filename: "gomake.yml",
+			content: `name: app
+output: dist
+platforms: [linux/amd64, darwin/universal]
+binaries:
+  - package: ./cmd/app
+    version_variable: main.version
+    variables:
+      main.commit: "{{.Git.Commit}}"
+archives:
+  - format: tar.gz
+    files: [README.md]
+hooks:
+  before: ['sh -c "go generate ./..."']
+`,
+			config: expected,
+		},
+		{
+			filename: "gomake.toml",
+			content: `name = "app"
+output = "dist"
+platforms = ["linux/amd64", "darwin/universal"]
+
+[[binaries]]
+package = "./cmd/app"
+version_variable = "main.version"
+variables = { "main.commit" = "{{.Git.Commit}}" }
+
+[[archives]]
+format = "tar.gz"
+files = ["README.md"]
+
+[hooks]
+before = ['sh -c "go generate ./..."']
+`,
+			config: expected,
+		},
+		{filename: "gomake.yaml", content: "name: app\nplatform: [linux/amd64]\n", wantErr: "field platform not found"},
+		{filename: "gomake.yml", content: "binaries:\n  - name: app\n    flag: [-v]\n", wantErr: "field flag not found"},
+		{filename: "gomake.yml", content: "platforms: linux/amd64\n", wantErr: "invalid configuration"},
+		{filename: "gomake.toml", content: "name = \"app\"\n[[binaries]]\npkg = \"./cmd/app\"\n", wantErr: "unknown key binaries.pkg"},
+		{filename: "gomake.toml", content: "name = app\n", wantErr: "invalid configuration"},
+		{filename: "gomake.json", content: "{}", wantErr: "unknown configuration format"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.filename, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{test.filename: test.content})
+			config, err := LoadConfig(filepath.Join(dir, test.filename))
+			checkError(t, err, test.wantErr)
+			if err == nil && !reflect.DeepEqual(config, test.config) {
+				t.Errorf("expected %+v, got %+v", test.config, config)
+			}
+		})
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	tests := []struct {
+		files   []string
+		found   string
+		wantErr string
+	}{
+		{files: []string{"gomake.toml", "gomake.yml"}, found: "gomake.yml"},
+		{files: []string{"gomake.toml", "gomake.yaml"}, found: "gomake.yaml"},
+		{files: []string{"gomake.toml"}, found: "gomake.toml"},
+		{files: []string{"gomake.json"}, wantErr: "no configuration file found"},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		files := make(map[string]string)
+		for _, file := range test.files {
+			files[file] = ""
+		}
+		writeFiles(t, dir, files)
+
+		filename, err := FindConfig(dir)
+		checkError(t, err, test.wantErr)
+		if err == nil && filename != filepath.Join(dir, test.found) {
+			t.Errorf("%v: expected %s, got %s", test.files, test.found, filename)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+		wantErr string
+	}{
+		{command: "go generate ./...", args: []string{"go", "generate", "./..."}},
+		{command: "  go\tvet  ", args: []string{"go", "vet"}},
+		{command: `sh -c "go generate ./..."`, args: []string{"sh", "-c", "go generate ./..."}},
+		{command: `cp 'my file' "other \"file\""`, args: []string{"cp", "my file", `other "file"`}},
+		{command: `echo 'a\b' "a\b" a\ b`, args: []string{"echo", `a\b`, `a\b`, "a b"}},
+		{command: `echo "" ''`, args: []string{"echo", "", ""}},
+		{command: `echo pre"fix"'es'`, args: []string{"echo", "prefixes"}},
+		{command: "", args: []string{}},
+		{command: `sh -c "echo`, wantErr: `unterminated " quote`},
+		{command: `echo it's`, wantErr: "unterminated ' quote"},
+		{command: `echo \`, wantErr: "trailing backslash"},
+	}
+	for _, test := range tests {
+		args, err := splitCommand(test.command)
+		checkError(t, err, test.wantErr)
+		if err == nil && !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%s: expected %q, got %q", test.command, test.args, args)
+		}
+	}
+}
+
+func TestConfigRegister(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *Config
+		targets []string
+		wantErr string
+	}{
+		{
+			name: "single binary",
+			config: &Config{
+				Name:      "app",
+				Platforms: []string{"linux/amd64", "windows/amd64"},
+				Binaries:  []BinaryConfig{{}},
+				Archives:  []ArchiveConfig{{Format: "tar.gz"}},
+				Hooks:     HooksConfig{Before: []string{"go generate ./..."}, After: []string{"echo done"}},
+			},
+			targets: []string{
+				"archive_tar.gz_linux_amd64", "archive_tar.gz_windows_amd64",
+				"build_linux_amd64", "build_windows_amd64",
+				"hook_after_0", "hook_before_0", "release",
+			},
+		},
+		{
+			name: "multiple binaries",
+			config: &Config{
+				Name:      "app",
+				Platforms: []string{"linux/amd64"},
+				Binaries:  []BinaryConfig{{Name: "client"}, {Name: "server", Platforms: []string{"linux/arm64"}}},
+				Archives:  []ArchiveConfig{{Format: "zip", Platforms: []string{"linux/amd64"}}},
+			},
+			targets: []string{"archive_zip_linux_amd64", "build_client_linux_amd64", "build_server_linux_arm64", "release"},
+		},
+		{
+			name: "universal binary",
+			config: &Config{
+				Name:      "app",
+				Platforms: []string{"darwin/universal"},
+				Binaries:  []BinaryConfig{{}},
+				Archives:  []ArchiveConfig{{Format: "tar.gz"}},
+			},
+			targets: []string{"archive_tar.gz_darwin_universal", "build_darwin_amd64", "build_darwin_arm64", "build_darwin_universal", "release"},
+		},
+		{
+			name: "universal and thin binaries",
+			config: &Config{
+				Name:      "app",
+				Platforms: []string{"darwin/arm64", "darwin/universal"},
+				Binaries:  []BinaryConfig{{}},
+				Archives:  []ArchiveConfig{{Format: "zip"}},
+			},
+			targets: []string{"archive_zip_darwin_arm64", "archive_zip_darwin_universal", "build_darwin_amd64", "build_darwin_arm64", "build_darwin_universal", "release"},
+		},
+		{
+			name:    "no name",
+			config:  &Config{Platforms: []string{"linux/amd64"}, Binaries: []BinaryConfig{{Package: "./cmd/app"}}},
+			wantErr: "binary of package ./cmd/app has no name",
+		},
+		{
+			name:    "duplicate binary",
+			config:  &Config{Name: "app", Platforms: []string{"linux/amd64"}, Binaries: []BinaryConfig{{}, {}}},
+			wantErr: "configured multiple times",
+		},
+		{
+			name:    "invalid platform",
+			config:  &Config{Name: "app", Platforms: []string{"linux"}, Binaries: []BinaryConfig{{}}},
+			wantErr: "expected \"os/arch\"",
+		},
+		{
+			name:    "archive without binaries",
+			config:  &Config{Name: "app", Platforms: []string{"linux/amd64"}, Binaries: []BinaryConfig{{}}, Archives: []ArchiveConfig{{Format: "zip", Platforms: []string{"linux/arm64"}}}},
+			wantErr: "no binaries to archive for linux_arm64",
+		},
+		{
+			name:    "invalid hook",
+			config:  &Config{Name: "app", Hooks: HooksConfig{Before: []string{`sh -c "go generate`}}},
+			wantErr: "invalid before hook 0",
+		},
+		{
+			name:    "empty hook",
+			config:  &Config{Name: "app", Hooks: HooksConfig{After: []string{" "}}},
+			wantErr: "after hook 0 is empty",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.config.Version = "1.2.3"
+			suite, err := test.config.NewSuite()
+			checkError(t, err, test.wantErr)
+			if err != nil {
+				return
+			}
+
+			names := make([]string, 0)
+			for _, name := range suite.TargetNames() {
+				if !strings.HasPrefix(name, CleanTargetNamePrefix) {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, test.targets) {
+				t.Errorf("expected the targets %v, got %v", test.targets, names)
+			}
+		})
+	}
+}
+
+func TestConfigRegisterDetails(t *testing.T) {
+	config := &Config{
+		Name:      "app",
+		Version:   "v1.2.3",
+		Platforms: []string{"darwin/universal"},
+		Binaries:  []BinaryConfig{{Package: "./cmd/app"}},
+		Archives:  []ArchiveConfig{{Format: "tar.gz", Files: []string{"README.md"}}},
+		Hooks:     HooksConfig{Before: []string{`sh -c "go generate ./..."`}},
+	}
+	suite, err := config.NewSuite()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := suite.Lookup("hook_before_0").(*CommandTarget)
+	if !reflect.DeepEqual(hook.Command, []string{"sh", "-c", "go generate ./..."}) {
+		t.Errorf("unexpected hook command %q", hook.Command)
+	}
+	build := suite.Lookup("build_darwin_arm64").(*BuildTarget)
+	if build.Package != "./cmd/app" || build.Version.String() != "1.2.3" || len(build.Dependencies) != 1 || build.Dependencies[0] != hook {
+		t.Errorf("unexpected build target %+v", build)
+	}
+	universal := suite.Lookup("build_darwin_universal").(*UniversalBinaryTarget)
+	if len(universal.Inputs) != 2 {
+		t.Errorf("expected both darwin builds as inputs, got %v", universal.Inputs)
+	}
+	archive := suite.Lookup("archive_tar.gz_darwin_universal").(*ArchiveTarget)
+	if len(archive.Binaries) != 1 || archive.Binaries[0] != universal {
+		t.Errorf("expected the universal binary to be archived, got %v", archive.Binaries)
+	}
+	if name := filepath.Base(archive.OutputName()); name != "app_1.2.3_darwin_universal.tar.gz" {
+		t.Errorf("unexpected archive name %s", name)
+	}
+	if err := suite.CheckPlatform(DarwinAmd64); err != nil {
+		t.Errorf("the inputs of the universal binary must be supported: %v", err)
+	}
+}
+
+func TestConfigRegisterGitVersion(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(dir))
+
+	config := &Config{Name: "app", Binaries: []BinaryConfig{{}}}
+	_, err := config.NewSuite()
+	checkError(t, err, "no version configured and none found in git")
+}
@@ -0,0 +1,102 @@
+package make
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		diff string
+	}{
+		{
+			name: "equal",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			diff: "",
+		},
+		{
+			name: "changed line",
+			a:    "a\nb\nc\n",
+			b:    "a\nx\nc\n",
+			diff: "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		},
+		{
+			name: "created file",
+			a:    "",
+			b:    "a\nb\n",
+			diff: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "removed file",
+			a:    "a\n",
+			b:    "",
+			diff: "--- a\n+++ b\n@@ -1,1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "inserted and removed lines",
+			a:    "a\nb\nc\nd\n",
+			b:    "a\nc\nd\ne\n",
+			diff: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n a\n-b\n c\n d\n+e\n",
+		},
+		{
+			name: "separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			b:    "x\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ny\n",
+			diff: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+y\n",
+		},
+		{
+			name: "merged hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n",
+			b:    "x\n2\n3\n4\n5\n6\ny\n",
+			diff: "--- a\n+++ b\n@@ -1,7 +1,7 @@\n-1\n+x\n 2\n 3\n 4\n 5\n 6\n-7\n+y\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diff := unifiedDiff("a", "b", []byte(test.a), []byte(test.b))
+			if diff != test.diff {
+				t.Errorf("expected diff\n%s\ngot\n%s", test.diff, diff)
+			}
+		})
+	}
+}
+
+func TestDiffLinesLongestCommonSubsequence(t *testing.T) {
+	tests := []struct {
+		a, b string
+		// common is the length of the longest common subsequence.
+		common int
+	}{
+		{"abc", "abc", 3},
+		{"abcabba", "cbabac", 4},
+		{"", "ab", 0},
+		{"ab", "", 0},
+	}
+	for _, test := range tests {
+		ops := diffLines(strings.Split(test.a, ""), strings.Split(test.b, ""))
+		kinds := make([]byte, len(ops))
+		for i, op := range ops {
+			kinds[i] = op.kind
+		}
+
+		// Applying the edit script to a has to result in b.
+		result := ""
+		for _, op := range ops {
+			if op.kind != '-' {
+				result += op.line
+			}
+		}
+		if result != test.b {
+			t.Errorf("%s -> %s: edit script results in %s", test.a, test.b, result)
+		}
+
+		unchanged := strings.Count(string(kinds), " ")
+		if unchanged != test.common {
+			t.Errorf("%s -> %s: expected %d unchanged elements, got %d (%s)", test.a, test.b, test.common, unchanged, kinds)
+		}
+	}
+}
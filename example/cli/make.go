@@ -12,6 +12,7 @@ func main() {
 		make.WindowsAmd64,
 	}
 	suite := make.NewBuildSuite(all)
+	suite.OutputRoot = "dist"
 
 	buildTargets := make.MultiPlatformBuild(&make.BuildTarget{
 		ExecutableName:      make.DefaultNameTemplate("test"),
@@ -21,7 +22,6 @@ func main() {
 
 	for _, target := range buildTargets {
 		suite.RegisterTarget(target)
-		suite.RegisterTarget(make.CleanTargetsFromOutputTargets(target)[0])
 	}
 
 	app := make.CLIApp(suite)
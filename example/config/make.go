@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/targodan/go-make"
+)
+
+func main() {
+	config, err := make.LoadConfig("gomake.yml")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(-1)
+	}
+	suite, err := config.NewSuite()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(-1)
+	}
+
+	// The loaded suite can be extended like any other.
+	suite.RegisterTarget(&make.ChangelogTarget{})
+
+	app := make.CLIApp(suite)
+	app.RunAndExitOnError()
+}
@@ -0,0 +1,94 @@
+package make
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// fingerprintFiles returns a hash over the names and contents of all
+// files matching the glob patterns relative to dir. Patterns without
+// any match contribute to the hash as well, so a deleted file
+// changes the fingerprint.
+func fingerprintFiles(dir string, patterns []string) (string, error) {
+	hash := sha256.New()
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return "", err
+		}
+		sort.Strings(matches)
+
+		io.WriteString(hash, "pattern:"+pattern+"\x00")
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return "", err
+			}
+			if info.IsDir() {
+				continue
+			}
+
+			io.WriteString(hash, "file:"+filepath.ToSlash(match)+"\x00")
+			if err := hashFile(hash, match); err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// fileSHA256 returns the hex encoded SHA256 sum of a file.
+func fileSHA256(filename string) (string, error) {
+	hash := sha256.New()
+	if err := hashFile(hash, filename); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// readFingerprints reads previously stored fingerprints. A missing
+// file results in an empty map.
+func readFingerprints(filename string) (map[string]string, error) {
+	fingerprints := make(map[string]string)
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return fingerprints, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &fingerprints)
+	return fingerprints, err
+}
+
+// writeFingerprints stores fingerprints, creating the parent
+// directory if necessary.
+func writeFingerprints(filename string, fingerprints map[string]string) error {
+	data, err := json.MarshalIndent(fingerprints, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
@@ -0,0 +1,163 @@
+package make
+
+import (
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FormatTargetName is the name of the FormatTarget.
+const FormatTargetName = "fmt"
+
+// FormatTarget checks or fixes the formatting of all Go files
+// in the module using go/format.
+//
+// Directories named "vendor" or "testdata", directories starting
+// with "." or "_" and nested modules are skipped.
+type FormatTarget struct {
+	// Root is the directory to search for Go files. Defaults to
+	// the root of the module containing the working directory.
+	Root string
+	// Exclude contains additional glob patterns of files and
+	// directories to skip, relative to Root.
+	Exclude []string
+
+	// If Fix is true unformatted files are rewritten, otherwise
+	// the target fails listing all unformatted files with diffs.
+	Fix bool
+
+	// Where to write the diffs of unformatted files. If nil
+	// they will be written to this precesses stdout.
+	Stdout io.Writer
+}
+
+// UnformattedError is returned by the FormatTarget if
+// unformatted files were found.
+type UnformattedError struct {
+	Files []string
+}
+
+func (e *UnformattedError) Error() string {
+	return "files are not formatted:\n" + strings.Join(e.Files, "\n")
+}
+
+// Execute checks or fixes the formatting.
+func (t *FormatTarget) Execute(suite *Suite) error {
+	root := t.Root
+	if root == "" {
+		var err error
+		root, err = findModuleRoot(".")
+		if err != nil {
+			return err
+		}
+	}
+
+	files, err := t.findGoFiles(root)
+	if err != nil {
+		return err
+	}
+
+	stdout := t.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+
+	unformatted := make([]string, 0)
+	for _, filename := range files {
+		source, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		formatted, err := format.Source(source)
+		if err != nil {
+			return fmt.Errorf("could not format %s: %v", filename, err)
+		}
+		if string(source) == string(formatted) {
+			continue
+		}
+
+		name := relativePath(filename)
+		if t.Fix {
+			fmt.Fprintln(stdout, "Formatting:", name)
+			info, err := os.Stat(filename)
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(filename, formatted, info.Mode()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		unformatted = append(unformatted, name)
+		fmt.Fprint(stdout, unifiedDiff(name+".orig", name, source, formatted))
+	}
+
+	if len(unformatted) > 0 {
+		return &UnformattedError{Files: unformatted}
+	}
+	return nil
+}
+
+func (t *FormatTarget) findGoFiles(root string) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if t.isExcluded(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			if path == root {
+				return nil
+			}
+			name := info.Name()
+			if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if strings.HasSuffix(path, ".go") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func (t *FormatTarget) isExcluded(rel string) bool {
+	for _, pattern := range t.Exclude {
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the name of this Target.
+func (t *FormatTarget) Name() string {
+	return FormatTargetName
+}
+
+// Description returns the description of this Target.
+func (t *FormatTarget) Description() string {
+	return "Checks or fixes the formatting of all Go files."
+}
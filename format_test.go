@@ -0,0 +1,98 @@
+package make
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFormatTarget(t *testing.T) {
+	const unformatted = "package p\nfunc F( ) {}\n"
+	const formatted = "package p\n\nfunc F() {}\n"
+	tests := []struct {
+		name      string
+		exclude   []string
+		fix       bool
+		wantFiles []string
+		fixed     []string
+	}{
+		{
+			name:      "check",
+			wantFiles: []string{"a.go", filepath.Join("sub", "b.go")},
+		},
+		{
+			name:      "exclude",
+			exclude:   []string{"sub"},
+			wantFiles: []string{"a.go"},
+		},
+		{
+			name:  "fix",
+			fix:   true,
+			fixed: []string{"a.go", filepath.Join("sub", "b.go")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":              "module example.com/fmt\n",
+				"a.go":                unformatted,
+				"ok.go":               formatted,
+				"sub/b.go":            unformatted,
+				"vendor/v.go":         unformatted,
+				"testdata/t.go":       unformatted,
+				".hidden/h.go":        unformatted,
+				"_skip/s.go":          unformatted,
+				"nested/go.mod":       "module example.com/nested\n",
+				"nested/n.go":         unformatted,
+				"sub/not_go_file.txt": unformatted,
+			})
+			chdir(t, dir)
+
+			stdout := &bytes.Buffer{}
+			target := &FormatTarget{Exclude: test.exclude, Fix: test.fix, Stdout: stdout}
+			err := target.Execute(NewBuildSuite(nil))
+			if test.wantFiles == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				fmtErr, ok := err.(*UnformattedError)
+				if !ok {
+					t.Fatalf("expected an UnformattedError, got %v", err)
+				}
+				if !reflect.DeepEqual(fmtErr.Files, test.wantFiles) {
+					t.Errorf("expected unformatted files %v, got %v", test.wantFiles, fmtErr.Files)
+				}
+				if !bytes.Contains(stdout.Bytes(), []byte("+++ a.go\n")) {
+					t.Errorf("expected a diff of a.go, got\n%s", stdout)
+				}
+			}
+
+			for _, name := range test.fixed {
+				content, err := ioutil.ReadFile(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(content) != formatted {
+					t.Errorf("%s was not formatted: %q", name, content)
+				}
+			}
+			content, _ := ioutil.ReadFile(filepath.Join("vendor", "v.go"))
+			if string(content) != unformatted {
+				t.Errorf("vendored file was modified")
+			}
+		})
+	}
+}
+
+func TestFormatTargetSyntaxError(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"broken.go": "package p\nfunc {\n"})
+
+	err := (&FormatTarget{Root: dir, Stdout: ioutil.Discard}).Execute(NewBuildSuite(nil))
+	checkError(t, err, "could not format")
+}
@@ -0,0 +1,57 @@
+package make
+
+// TargetFunc is an adapter to allow the use of ordinary functions
+// as Targets. If f is a function with the appropriate signature,
+// TargetFunc(f) is a Target that calls f.
+type TargetFunc func(suite *Suite) error
+
+// Execute calls f(suite).
+func (f TargetFunc) Execute(suite *Suite) error {
+	return f(suite)
+}
+
+// FuncTarget is a NamedTarget executing a function after
+// executing its dependencies.
+type FuncTarget struct {
+	TargetName        string
+	TargetDescription string
+	// Dependencies will be executed sequentially before the
+	// function. The function will not be executed if any of
+	// the dependencies fails. NamedTargets will only be executed
+	// once per Suite.Execute.
+	Dependencies []Target
+
+	Func TargetFunc
+}
+
+// NewFuncTarget creates a FuncTarget with the given name, description,
+// function and dependencies.
+func NewFuncTarget(name, description string, f func(suite *Suite) error, dependencies ...Target) *FuncTarget {
+	return &FuncTarget{
+		TargetName:        name,
+		TargetDescription: description,
+		Dependencies:      dependencies,
+		Func:              f,
+	}
+}
+
+// Execute executes the dependencies followed by the function.
+func (t *FuncTarget) Execute(suite *Suite) error {
+	if err := suite.executeDependencies(t.Dependencies); err != nil {
+		return err
+	}
+	if t.Func == nil {
+		return nil
+	}
+	return t.Func(suite)
+}
+
+// Name returns the name of this Target.
+func (t *FuncTarget) Name() string {
+	return t.TargetName
+}
+
+// Description returns the description of this Target.
+func (t *FuncTarget) Description() string {
+	return t.TargetDescription
+}
@@ -0,0 +1,160 @@
+package make
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+// recordingTarget is a NamedTarget appending its name to
+// a log when executed.
+type recordingTarget struct {
+	name string
+	log  *[]string
+	err  error
+}
+
+func (t *recordingTarget) Execute(suite *Suite) error {
+	*t.log = append(*t.log, t.name)
+	return t.err
+}
+
+func (t *recordingTarget) Name() string {
+	return t.name
+}
+
+func TestTargetFunc(t *testing.T) {
+	suite := NewBuildSuite(PlatformSet{LinuxAmd64})
+	var got *Suite
+	err := TargetFunc(func(s *Suite) error {
+		got = s
+		return fmt.Errorf("failed")
+	}).Execute(suite)
+	if got != suite {
+		t.Errorf("function did not receive the suite")
+	}
+	checkError(t, err, "failed")
+}
+
+func TestFuncTargetExecute(t *testing.T) {
+	tests := []struct {
+		name    string
+		deps    []string
+		failing string
+		err     error
+		log     []string
+		wantErr string
+	}{
+		{
+			name: "dependencies before function",
+			deps: []string{"a", "b"},
+			log:  []string{"a", "b", "func"},
+		},
+		{
+			name: "shared dependency once",
+			deps: []string{"a", "b", "a"},
+			log:  []string{"a", "b", "func"},
+		},
+		{
+			name:    "failing dependency",
+			deps:    []string{"a", "b", "c"},
+			failing: "b",
+			log:     []string{"a", "b"},
+			wantErr: "b failed",
+		},
+		{
+			name:    "failing function",
+			deps:    []string{"a"},
+			err:     fmt.Errorf("func failed"),
+			log:     []string{"a", "func"},
+			wantErr: "func failed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := make([]string, 0)
+			registered := make(map[string]Target)
+			deps := make([]Target, len(test.deps))
+			for i, name := range test.deps {
+				if registered[name] == nil {
+					dep := &recordingTarget{name: name, log: &log}
+					if name == test.failing {
+						dep.err = fmt.Errorf("%s failed", name)
+					}
+					registered[name] = dep
+				}
+				deps[i] = registered[name]
+			}
+
+			target := NewFuncTarget("all", "Does everything.", func(suite *Suite) error {
+				log = append(log, "func")
+				return test.err
+			}, deps...)
+			err := NewBuildSuite(PlatformSet{LinuxAmd64}).Execute(target)
+			checkError(t, err, test.wantErr)
+			if !reflect.DeepEqual(log, test.log) {
+				t.Errorf("expected execution %v, got %v", test.log, log)
+			}
+		})
+	}
+}
+
+func TestFuncTargetWithoutFunc(t *testing.T) {
+	log := make([]string, 0)
+	target := NewFuncTarget("deps", "", nil, &recordingTarget{name: "a", log: &log})
+	if target.Name() != "deps" {
+		t.Errorf("unexpected name %s", target.Name())
+	}
+	if err := NewBuildSuite(nil).Execute(target); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(log, []string{"a"}) {
+		t.Errorf("expected the dependency to be executed, got %v", log)
+	}
+}
+
+func TestCLIAppRun(t *testing.T) {
+	tests := []struct {
+		args    []string
+		log     []string
+		wantErr string
+	}{
+		{args: []string{"run", "b", "a"}, log: []string{"b", "a"}},
+		{args: []string{"run", "all"}, log: []string{"a", "b"}},
+		{args: []string{"run", "missing"}, log: []string{}, wantErr: "could not be found"},
+		{args: []string{"run"}, log: []string{}, wantErr: "no target given"},
+	}
+
+	for _, test := range tests {
+		log := make([]string, 0)
+		a := &recordingTarget{name: "a", log: &log}
+		b := &recordingTarget{name: "b", log: &log}
+		suite := NewBuildSuite(PlatformSet{LinuxAmd64})
+		suite.RegisterTargets(a, b, NewFuncTarget("all", "", nil, a, b, a))
+
+		err := runCLIApp(suite, test.args...)
+		checkError(t, err, test.wantErr)
+		if !reflect.DeepEqual(log, test.log) {
+			t.Errorf("%v: expected execution %v, got %v", test.args, test.log, log)
+		}
+	}
+}
+
+// runCLIApp runs the CLIApp of the suite with the arguments
+// without exiting the process on errors.
+func runCLIApp(suite *Suite, args ...string) error {
+	exiter, errWriter := cli.OsExiter, cli.ErrWriter
+	defer func() {
+		cli.OsExiter, cli.ErrWriter = exiter, errWriter
+	}()
+	cli.OsExiter = func(int) {}
+	cli.ErrWriter = ioutil.Discard
+
+	app := CLIApp(suite)
+	app.Writer = ioutil.Discard
+	return app.Run(append([]string{"make"}, args...))
+}
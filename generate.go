@@ -0,0 +1,284 @@
+package make
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// GenerateTargetName is the name of the GenerateTarget.
+const GenerateTargetName = "generate"
+
+// generateFingerprintFile is the name of the file in the suites
+// CacheDir holding the fingerprints of the GenerateDirectives.
+const generateFingerprintFile = "generate.json"
+
+// GenerateDirective declares the inputs and outputs of the
+// go:generate directives in a directory.
+type GenerateDirective struct {
+	// Dir is the directory containing the directives.
+	Dir string
+	// Run is an optional regular expression selecting the
+	// directives in Dir, see "go help generate".
+	Run string
+	// Inputs are glob patterns relative to Dir matching all
+	// files the directives read.
+	Inputs []string
+	// Outputs are glob patterns relative to Dir matching all
+	// files the directives write.
+	Outputs []string
+}
+
+func (d *GenerateDirective) key() string {
+	return filepath.ToSlash(filepath.Clean(d.Dir)) + "#" + d.Run
+}
+
+// GenerateTarget runs "go generate".
+//
+// If Directives are given, only the declared directives will be
+// run and only if their inputs or outputs changed since the last
+// execution. Otherwise "go generate" is run for all Packages.
+type GenerateTarget struct {
+	// Packages to run "go generate" for. Defaults to "./...".
+	Packages []string
+	// Directives with declared inputs and outputs.
+	Directives []GenerateDirective
+
+	// If Check is true all directives will be run regardless of
+	// their fingerprints and the target fails if go generate
+	// changes any file. The files matching the Outputs of the
+	// Directives or, without Directives, the files in the
+	// directories of the Packages are compared.
+	Check bool
+
+	// Where to redirect the generate commands stdout. If nil
+	// stdout will be redirected to this precesses stdout.
+	Stdout io.Writer
+	// Where to redirect the generate commands stderr. If nil
+	// stderr will be redirected to this precesses stderr.
+	Stderr io.Writer
+}
+
+// Execute runs "go generate".
+func (t *GenerateTarget) Execute(suite *Suite) error {
+	var before map[string][]byte
+	if t.Check {
+		var err error
+		before, err = t.snapshot()
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if len(t.Directives) == 0 {
+		err = t.generatePackages(suite)
+	} else {
+		err = t.generateDirectives(suite)
+	}
+	if err != nil {
+		return err
+	}
+
+	if t.Check {
+		return t.checkUnchanged(before)
+	}
+	return nil
+}
+
+func (t *GenerateTarget) generatePackages(suite *Suite) error {
+	packages := t.Packages
+	if len(packages) == 0 {
+		packages = []string{"./..."}
+	}
+
+	return t.command(".", "", packages).Execute(suite)
+}
+
+func (t *GenerateTarget) generateDirectives(suite *Suite) error {
+	fingerprintFile := suite.CachePath(generateFingerprintFile)
+	fingerprints, err := readFingerprints(fingerprintFile)
+	if err != nil {
+		return fmt.Errorf("could not read fingerprints: %v", err)
+	}
+
+	for _, directive := range t.Directives {
+		fingerprint, err := directive.fingerprint()
+		if err != nil {
+			return err
+		}
+		if !t.Check && fingerprints[directive.key()] == fingerprint {
+			fmt.Println("Skipping go generate, inputs unchanged:", directive.Dir)
+			continue
+		}
+
+		err = t.command(directive.Dir, directive.Run, []string{"."}).Execute(suite)
+		if err != nil {
+			return err
+		}
+
+		fingerprints[directive.key()], err = directive.fingerprint()
+		if err != nil {
+			return err
+		}
+		if err := writeFingerprints(fingerprintFile, fingerprints); err != nil {
+			return fmt.Errorf("could not write fingerprints: %v", err)
+		}
+	}
+	return nil
+}
+
+func (d *GenerateDirective) fingerprint() (string, error) {
+	inputs, err := fingerprintFiles(d.Dir, d.Inputs)
+	if err != nil {
+		return "", err
+	}
+	outputs, err := fingerprintFiles(d.Dir, d.Outputs)
+	if err != nil {
+		return "", err
+	}
+	return inputs + outputs, nil
+}
+
+func (t *GenerateTarget) command(dir, run string, packages []string) *CommandTarget {
+	command := []string{"go", "generate"}
+	if run != "" {
+		command = append(command, "-run", run)
+	}
+	command = append(command, packages...)
+
+	return &CommandTarget{
+		TargetName: GenerateTargetName,
+		Command:    command,
+		Dir:        escapeName(dir),
+		Stdout:     t.Stdout,
+		Stderr:     t.Stderr,
+	}
+}
+
+// snapshot returns the contents of all files go generate may
+// write by their names.
+func (t *GenerateTarget) snapshot() (map[string][]byte, error) {
+	files := make([]string, 0)
+	if len(t.Directives) == 0 {
+		dirs, err := t.packageDirs()
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			matches, err := filepath.Glob(filepath.Join(dir, "*"))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+	}
+	for _, directive := range t.Directives {
+		outputs := directive.Outputs
+		if len(outputs) == 0 {
+			outputs = []string{"*"}
+		}
+		for _, pattern := range outputs {
+			matches, err := filepath.Glob(filepath.Join(directive.Dir, pattern))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+	}
+
+	contents := make(map[string][]byte)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			continue
+		}
+		contents[file], err = ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return contents, nil
+}
+
+// packageDirs returns the directories of the Packages.
+func (t *GenerateTarget) packageDirs() ([]string, error) {
+	packages := t.Packages
+	if len(packages) == 0 {
+		packages = []string{"./..."}
+	}
+
+	cmd := exec.Command("go", append([]string{"list", "-e", "-f", "{{.Dir}}"}, packages...)...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running go list: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	dirs := make([]string, 0)
+	for _, dir := range strings.Split(string(out), "\n") {
+		if dir != "" {
+			dirs = append(dirs, relativePath(dir))
+		}
+	}
+	return dirs, nil
+}
+
+// checkUnchanged returns an error containing the diffs if the
+// files go generate may write differ from the snapshot taken
+// before running it. Unrelated changes in the working tree and
+// whether the files are committed do not matter.
+func (t *GenerateTarget) checkUnchanged(before map[string][]byte) error {
+	after, err := t.snapshot()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(after))
+	for name := range after {
+		names = append(names, name)
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	diff := ""
+	for _, name := range names {
+		old, existed := before[name]
+		generated, exists := after[name]
+		switch {
+		case !existed:
+			diff += fmt.Sprintf("created %s\n", name)
+		case !exists:
+			diff += fmt.Sprintf("removed %s\n", name)
+		case !bytes.Equal(old, generated):
+			diff += unifiedDiff(name, name+".generated", old, generated)
+		}
+	}
+	if diff != "" {
+		return fmt.Errorf("go generate changed generated files:\n%s", diff)
+	}
+	return nil
+}
+
+// Name returns the name of this Target.
+func (t *GenerateTarget) Name() string {
+	return GenerateTargetName
+}
+
+// Description returns the description of this Target.
+func (t *GenerateTarget) Description() string {
+	return "Runs go generate."
+}
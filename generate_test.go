@@ -0,0 +1,139 @@
+package make
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles writes files with the given contents relative
+// to dir, creating missing directories.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// chdir changes the working directory for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+}
+
+func TestGenerateTargetCheck(t *testing.T) {
+	const generator = "package p\n\n//go:generate sh -c \"echo package p > gen.go\"\n"
+	tests := []struct {
+		name       string
+		files      map[string]string
+		directives []GenerateDirective
+		wantErr    string
+	}{
+		{
+			name: "unchanged",
+			files: map[string]string{
+				"p/p.go":   generator,
+				"p/gen.go": "package p\n",
+			},
+		},
+		{
+			name: "unrelated changes",
+			files: map[string]string{
+				"p/p.go":     generator,
+				"p/gen.go":   "package p\n",
+				"other.txt":  "uncommitted",
+				"p/data.txt": "not an output",
+			},
+			directives: []GenerateDirective{{Dir: "p", Outputs: []string{"gen.go"}}},
+		},
+		{
+			name: "modified output",
+			files: map[string]string{
+				"p/p.go":   generator,
+				"p/gen.go": "package q\n",
+			},
+			directives: []GenerateDirective{{Dir: "p", Outputs: []string{"gen.go"}}},
+			wantErr:    "+package p",
+		},
+		{
+			name: "created output",
+			files: map[string]string{
+				"p/p.go": generator,
+			},
+			wantErr: "created " + filepath.Join("p", "gen.go"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			test.files["go.mod"] = "module example.com/gen\n\ngo 1.16\n"
+			writeFiles(t, dir, test.files)
+			chdir(t, dir)
+
+			suite := NewBuildSuite(nil)
+			suite.CacheDir = filepath.Join(dir, ".cache")
+			target := &GenerateTarget{Directives: test.directives, Check: true, Stdout: ioutil.Discard, Stderr: ioutil.Discard}
+			err := target.Execute(suite)
+			checkError(t, err, test.wantErr)
+			if err != nil {
+				if _, statErr := os.Stat(filepath.Join(dir, "p", "gen.go")); statErr != nil {
+					t.Errorf("generated file is missing: %v", statErr)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateTargetFingerprints(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":      "module example.com/gen\n\ngo 1.16\n",
+		"p/p.go":      "package p\n\n//go:generate sh -c \"cat input.txt >> out.txt\"\n",
+		"p/input.txt": "a\n",
+	})
+	chdir(t, dir)
+
+	suite := NewBuildSuite(nil)
+	target := &GenerateTarget{
+		Directives: []GenerateDirective{{Dir: "p", Inputs: []string{"*.txt"}, Outputs: []string{"out.txt"}}},
+		Stdout:     ioutil.Discard,
+		Stderr:     ioutil.Discard,
+	}
+	run := func(expected string) {
+		t.Helper()
+		if err := target.Execute(suite); err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadFile(filepath.Join("p", "out.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, []byte(expected)) {
+			t.Errorf("expected output %q, got %q", expected, out)
+		}
+	}
+
+	run("a\n")
+	// The inputs and outputs are unchanged, so nothing runs.
+	run("a\n")
+	writeFiles(t, dir, map[string]string{"p/input.txt": "b\n"})
+	run("a\nb\n")
+}
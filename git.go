@@ -0,0 +1,58 @@
+package make
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// gitOutput runs git with the given arguments in dir and returns
+// its trimmed stdout.
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error running git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// GitInfo contains information about a git repository.
+type GitInfo struct {
+	Commit      string
+	ShortCommit string
+	Branch      string
+	// Tag is the tag pointing at the current commit if any.
+	Tag string
+	// Dirty is true if there are uncommitted changes.
+	Dirty bool
+}
+
+var (
+	currentGitInfo     *GitInfo
+	currentGitInfoOnce sync.Once
+)
+
+// CurrentGitInfo returns information about the git repository in
+// the working directory. The information is only queried once.
+func CurrentGitInfo() *GitInfo {
+	currentGitInfoOnce.Do(func() {
+		currentGitInfo = &GitInfo{}
+		commit, err := gitOutput(".", "rev-parse", "HEAD")
+		if err != nil {
+			return
+		}
+		currentGitInfo.Commit = commit
+		currentGitInfo.ShortCommit, _ = gitOutput(".", "rev-parse", "--short", "HEAD")
+		currentGitInfo.Branch, _ = gitOutput(".", "rev-parse", "--abbrev-ref", "HEAD")
+		currentGitInfo.Tag, _ = gitOutput(".", "describe", "--tags", "--exact-match", "HEAD")
+		status, _ := gitOutput(".", "status", "--porcelain")
+		currentGitInfo.Dirty = status != ""
+	})
+	return currentGitInfo
+}
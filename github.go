@@ -0,0 +1,427 @@
+package make
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DefaultGitHubBaseURL is the default base URL of the
+	// GitHub REST API.
+	DefaultGitHubBaseURL = "https://api.github.com"
+	// defaultGitHubUploadHost is the host assets are uploaded to
+	// if the DefaultGitHubBaseURL is used.
+	defaultGitHubUploadHost = "uploads.github.com"
+	// DefaultGitHubTokenEnv is the default environment variable
+	// containing the GitHub token.
+	DefaultGitHubTokenEnv = "GITHUB_TOKEN"
+)
+
+// GitHubPublisher publishes releases using the GitHub Releases API.
+// Releases are looked up by their tag and created if they do not
+// exist yet, otherwise their notes are updated. Artifacts are
+// uploaded as assets named after their files. Assets that are
+// already uploaded with the same size and checksum are skipped,
+// differing ones are replaced. That way publishing can safely be
+// retried.
+//
+// Any server implementing the same API, e. g. GitHub Enterprise
+// or a local stand-in for testing, can be used by setting the
+// BaseURL.
+type GitHubPublisher struct {
+	// BaseURL of the API. Defaults to DefaultGitHubBaseURL.
+	BaseURL string
+	// UploadURL is the base URL assets are uploaded to. Defaults to
+	// the upload URL returned by the server for the release.
+	UploadURL string
+	// Owner and Repository identify the GitHub repository.
+	Owner      string
+	Repository string
+
+	// Token is used for authentication. Defaults to the content of
+	// the environment variable TokenEnv. It is only sent to the hosts
+	// of the BaseURL and the UploadURL, or to the GitHub upload host
+	// if both are left empty, but never to other hosts linked by the
+	// server.
+	Token string
+	// TokenEnv defaults to DefaultGitHubTokenEnv.
+	TokenEnv string
+
+	// Draft and Prerelease are set when creating releases.
+	Draft      bool
+	Prerelease bool
+	// TargetCommitish is the commit the tag is created from if it
+	// does not exist yet. Defaults to the default branch.
+	TargetCommitish string
+
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// gitHubRelease is the part of a release used by GitHubPublishers.
+type gitHubRelease struct {
+	ID              int64  `json:"id,omitempty"`
+	TagName         string `json:"tag_name"`
+	Name            string `json:"name"`
+	Body            string `json:"body"`
+	Draft           bool   `json:"draft"`
+	Prerelease      bool   `json:"prerelease"`
+	TargetCommitish string `json:"target_commitish,omitempty"`
+	UploadURL       string `json:"upload_url,omitempty"`
+}
+
+type gitHubAsset struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// State is "uploaded" unless an upload failed.
+	State string `json:"state"`
+	// Digest is "sha256:<hex>" if provided by the server.
+	Digest string `json:"digest"`
+}
+
+// gitHubError is returned for unsuccessful responses.
+type gitHubError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Message    string
+}
+
+func (e *gitHubError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Publish creates or updates the release and uploads the artifacts.
+func (p *GitHubPublisher) Publish(release *Release) error {
+	if p.Owner == "" || p.Repository == "" {
+		return fmt.Errorf("no GitHub repository given")
+	}
+
+	assets := make(map[string]*Artifact)
+	for _, artifact := range release.Artifacts {
+		name := filepath.Base(artifact.Path)
+		if other, ok := assets[name]; ok {
+			return fmt.Errorf("%s and %s would be uploaded as the same asset", other.Path, artifact.Path)
+		}
+		assets[name] = artifact
+	}
+
+	existing, err := p.findRelease(release.Tag)
+	if err != nil {
+		return err
+	}
+
+	update := &gitHubRelease{
+		TagName:         release.Tag,
+		Name:            release.Tag,
+		Body:            release.Notes,
+		Draft:           p.Draft,
+		Prerelease:      p.Prerelease,
+		TargetCommitish: p.TargetCommitish,
+	}
+	ghRelease := &gitHubRelease{}
+	if existing == nil {
+		fmt.Println("Creating GitHub release:", release.Tag)
+		err = p.do("POST", p.repositoryURL("releases"), update, ghRelease)
+	} else {
+		// The draft state of existing releases is left alone,
+		// so retries do not publish drafts by accident.
+		update.Draft = existing.Draft
+		fmt.Println("Updating GitHub release:", release.Tag)
+		err = p.do("PATCH", p.repositoryURL(fmt.Sprintf("releases/%d", existing.ID)), update, ghRelease)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The assets embedded in the release are limited,
+	// so all pages of the asset list are requested.
+	uploaded := make(map[string]*gitHubAsset)
+	next := p.repositoryURL(fmt.Sprintf("releases/%d/assets?per_page=100", ghRelease.ID))
+	for next != "" {
+		assets := make([]*gitHubAsset, 0)
+		next, err = p.getPage(next, &assets)
+		if err != nil {
+			return err
+		}
+		for _, asset := range assets {
+			uploaded[asset.Name] = asset
+		}
+	}
+
+	for _, artifact := range release.Artifacts {
+		name := filepath.Base(artifact.Path)
+		if asset, ok := uploaded[name]; ok {
+			same, err := p.isSameAsset(asset, artifact)
+			if err != nil {
+				return err
+			}
+			if same && !release.Force {
+				fmt.Println("Already uploaded:", name)
+				continue
+			}
+			fmt.Println("Deleting asset:", name)
+			err = p.do("DELETE", p.repositoryURL(fmt.Sprintf("releases/assets/%d", asset.ID)), nil, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		fmt.Println("Uploading asset:", name)
+		if err := p.upload(ghRelease, name, artifact.Path); err != nil {
+			return fmt.Errorf("could not upload %s: %v", artifact.Path, err)
+		}
+	}
+	return nil
+}
+
+// findRelease returns the release of the tag or nil if there is none.
+func (p *GitHubPublisher) findRelease(tag string) (*gitHubRelease, error) {
+	release := &gitHubRelease{}
+	err := p.do("GET", p.repositoryURL("releases/tags/"+url.PathEscape(tag)), nil, release)
+	if err == nil {
+		return release, nil
+	}
+	if e, ok := err.(*gitHubError); !ok || e.StatusCode != http.StatusNotFound {
+		return nil, err
+	}
+
+	// Draft releases are not found by their tag,
+	// so they have to be searched for.
+	next := p.repositoryURL("releases?per_page=100")
+	for next != "" {
+		releases := make([]*gitHubRelease, 0)
+		next, err = p.getPage(next, &releases)
+		if err != nil {
+			return nil, err
+		}
+		for _, release := range releases {
+			if release.TagName == tag {
+				return release, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// isSameAsset compares the asset with the artifact using the
+// digest if available and the size otherwise.
+func (p *GitHubPublisher) isSameAsset(asset *gitHubAsset, artifact *Artifact) (bool, error) {
+	if asset.State != "" && asset.State != "uploaded" {
+		return false, nil
+	}
+	info, err := os.Stat(artifact.Path)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != asset.Size {
+		return false, nil
+	}
+	if !strings.HasPrefix(asset.Digest, "sha256:") {
+		return true, nil
+	}
+	sum, err := fileSHA256(artifact.Path)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimPrefix(asset.Digest, "sha256:") == sum, nil
+}
+
+func (p *GitHubPublisher) upload(release *gitHubRelease, name, filename string) error {
+	uploadURL := strings.TrimRight(p.UploadURL, "/")
+	if uploadURL != "" {
+		uploadURL = fmt.Sprintf("%s/repos/%s/%s/releases/%d/assets", uploadURL, p.Owner, p.Repository, release.ID)
+	} else {
+		// The upload URL is a URI template like
+		// ".../assets{?name,label}".
+		uploadURL = release.UploadURL
+		if i := strings.Index(uploadURL, "{"); i >= 0 {
+			uploadURL = uploadURL[:i]
+		}
+	}
+	if uploadURL == "" {
+		return fmt.Errorf("no upload URL known for release %s", release.TagName)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", uploadURL+"?name="+url.QueryEscape(name), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return p.send(req, nil)
+}
+
+func (p *GitHubPublisher) repositoryURL(path string) string {
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultGitHubBaseURL
+	}
+	return fmt.Sprintf("%s/repos/%s/%s/%s", strings.TrimRight(baseURL, "/"), p.Owner, p.Repository, path)
+}
+
+// do sends the request JSON encoding the body if it is not nil and
+// decodes the response into result if it is not nil.
+func (p *GitHubPublisher) do(method, address string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, address, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return p.send(req, result)
+}
+
+// getPage decodes the page of a list into result and returns the
+// URL of the next page or an empty string if it is the last one.
+func (p *GitHubPublisher) getPage(address string, result interface{}) (string, error) {
+	req, err := http.NewRequest("GET", address, nil)
+	if err != nil {
+		return "", err
+	}
+	header, err := p.sendWithHeader(req, result)
+	if err != nil {
+		return "", err
+	}
+	return nextLink(header), nil
+}
+
+// nextLink returns the URL of the "next" relation in the Link
+// header, e. g. `<https://...?page=2>; rel="next", <...>; rel="last"`.
+func nextLink(header http.Header) string {
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				pair := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(pair) != 2 || !strings.EqualFold(strings.TrimSpace(pair[0]), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(pair[1], `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return target[1 : len(target)-1]
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func (p *GitHubPublisher) send(req *http.Request, result interface{}) error {
+	_, err := p.sendWithHeader(req, result)
+	return err
+}
+
+// sendWithHeader sends the request like send and returns
+// the header of the response.
+func (p *GitHubPublisher) sendWithHeader(req *http.Request, result interface{}) (http.Header, error) {
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if token := p.token(); token != "" && p.isAPIHost(req.URL) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		e := &gitHubError{
+			Method:     req.Method,
+			URL:        req.URL.String(),
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(data)),
+		}
+		var message struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &message) == nil && message.Message != "" {
+			e.Message = message.Message
+		}
+		return nil, e
+	}
+
+	if result == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+	} else {
+		err = json.NewDecoder(resp.Body).Decode(result)
+	}
+	return resp.Header, err
+}
+
+// isAPIHost returns true if the URL belongs to the host of the
+// BaseURL or the UploadURL.
+func (p *GitHubPublisher) isAPIHost(u *url.URL) bool {
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultGitHubBaseURL
+	}
+	hosts := []string{}
+	for _, address := range []string{baseURL, p.UploadURL} {
+		if parsed, err := url.Parse(address); err == nil && parsed.Host != "" {
+			hosts = append(hosts, parsed.Host)
+		}
+	}
+	if p.BaseURL == "" && p.UploadURL == "" {
+		hosts = append(hosts, defaultGitHubUploadHost)
+	}
+
+	for _, host := range hosts {
+		if strings.EqualFold(u.Host, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *GitHubPublisher) token() string {
+	if p.Token != "" {
+		return p.Token
+	}
+	env := p.TokenEnv
+	if env == "" {
+		env = DefaultGitHubTokenEnv
+	}
+	return os.Getenv(env)
+}
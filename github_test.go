@@ -0,0 +1,439 @@
+package make
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGitHub is a stand-in for the parts of the GitHub Releases
+// API used by GitHubPublishers. Lists are split into pages of
+// pageSize entries linked by Link headers.
+type fakeGitHub struct {
+	t        *testing.T
+	server   *httptest.Server
+	pageSize int
+
+	mutex    sync.Mutex
+	nextID   int64
+	releases []*gitHubRelease
+	assets   map[int64][]*gitHubAsset
+	// requests contains "METHOD path" of the modifying requests.
+	requests []string
+	// authorization is the last Authorization header.
+	authorization string
+}
+
+func newFakeGitHub(t *testing.T, pageSize int) *fakeGitHub {
+	f := &fakeGitHub{t: t, pageSize: pageSize, assets: make(map[int64][]*gitHubAsset)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// addRelease and addAsset must be called with the mutex locked
+// once the server is in use.
+func (f *fakeGitHub) addRelease(tag string, draft bool) *gitHubRelease {
+	f.nextID++
+	release := &gitHubRelease{
+		ID:        f.nextID,
+		TagName:   tag,
+		Name:      tag,
+		Draft:     draft,
+		UploadURL: fmt.Sprintf("%s/uploads/repos/owner/repo/releases/%d/assets{?name,label}", f.server.URL, f.nextID),
+	}
+	f.releases = append(f.releases, release)
+	return release
+}
+
+func (f *fakeGitHub) addAsset(releaseID int64, name string, content []byte) {
+	f.nextID++
+	sum := sha256.Sum256(content)
+	f.assets[releaseID] = append(f.assets[releaseID], &gitHubAsset{
+		ID:     f.nextID,
+		Name:   name,
+		Size:   int64(len(content)),
+		State:  "uploaded",
+		Digest: "sha256:" + hex.EncodeToString(sum[:]),
+	})
+}
+
+func (f *fakeGitHub) assetNames(releaseID int64) []string {
+	names := make([]string, 0)
+	for _, asset := range f.assets[releaseID] {
+		names = append(names, asset.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *fakeGitHub) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		f.t.Error(err)
+	}
+	if strings.HasPrefix(r.URL.Path, "/uploads/") {
+		f.addUpload(w, r, body)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/")
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.authorization = r.Header.Get("Authorization")
+	if r.Method != "GET" {
+		f.requests = append(f.requests, r.Method+" "+path)
+	}
+
+	parts := strings.Split(path, "/")
+	switch {
+	case r.Method == "GET" && len(parts) == 3 && parts[1] == "tags":
+		for _, release := range f.releases {
+			if release.TagName == parts[2] && !release.Draft {
+				f.writeJSON(w, release)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"Not Found"}`))
+	case r.Method == "GET" && path == "releases":
+		items := make([]interface{}, len(f.releases))
+		for i, release := range f.releases {
+			items[i] = release
+		}
+		f.writePage(w, r, items)
+	case r.Method == "GET" && len(parts) == 3 && parts[2] == "assets":
+		id, _ := strconv.ParseInt(parts[1], 10, 64)
+		items := make([]interface{}, len(f.assets[id]))
+		for i, asset := range f.assets[id] {
+			items[i] = asset
+		}
+		f.writePage(w, r, items)
+	case r.Method == "POST" && path == "releases":
+		f.update(w, f.addRelease("", false), body)
+	case r.Method == "PATCH" && len(parts) == 2:
+		id, _ := strconv.ParseInt(parts[1], 10, 64)
+		for _, release := range f.releases {
+			if release.ID == id {
+				f.update(w, release, body)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == "DELETE" && len(parts) == 3 && parts[1] == "assets":
+		id, _ := strconv.ParseInt(parts[2], 10, 64)
+		for releaseID, assets := range f.assets {
+			for i, asset := range assets {
+				if asset.ID == id {
+					f.assets[releaseID] = append(assets[:i:i], assets[i+1:]...)
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeGitHub) update(w http.ResponseWriter, release *gitHubRelease, body []byte) {
+	update := &gitHubRelease{}
+	if err := json.Unmarshal(body, update); err != nil {
+		f.t.Error(err)
+	}
+	release.TagName = update.TagName
+	release.Name = update.Name
+	release.Body = update.Body
+	release.Draft = update.Draft
+	release.Prerelease = update.Prerelease
+	f.writeJSON(w, release)
+}
+
+func (f *fakeGitHub) addUpload(w http.ResponseWriter, r *http.Request, body []byte) {
+	parts := strings.Split(r.URL.Path, "/")
+	id, _ := strconv.ParseInt(parts[len(parts)-2], 10, 64)
+	if r.Header.Get("Content-Type") != "application/octet-stream" || r.ContentLength != int64(len(body)) {
+		f.t.Errorf("unexpected upload headers %v", r.Header)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.addAsset(id, r.URL.Query().Get("name"), body)
+	f.requests = append(f.requests, "UPLOAD "+r.URL.Query().Get("name"))
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("{}"))
+}
+
+// writePage writes the page of the items requested by the
+// "page" parameter and links the next page.
+func (f *fakeGitHub) writePage(w http.ResponseWriter, r *http.Request, items []interface{}) {
+	if r.URL.Query().Get("per_page") != "100" {
+		f.t.Errorf("expected 100 entries per page to be requested, got %s", r.URL)
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	start := (page - 1) * f.pageSize
+	end := start + f.pageSize
+	if start > len(items) {
+		start = len(items)
+	}
+	if end >= len(items) {
+		end = len(items)
+	} else {
+		query := r.URL.Query()
+		query.Set("page", strconv.Itoa(page+1))
+		next := f.server.URL + r.URL.Path + "?" + query.Encode()
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next", <%s>; rel="last"`, next, f.server.URL+r.URL.Path))
+	}
+	f.writeJSON(w, items[start:end])
+}
+
+func (f *fakeGitHub) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		f.t.Error(err)
+	}
+}
+
+func TestGitHubPublisher(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(f *fakeGitHub)
+		publisher GitHubPublisher
+		force     bool
+		requests  []string
+		assets    []string
+		draft     bool
+		wantErr   string
+	}{
+		{
+			name:     "new release",
+			requests: []string{"POST releases", "UPLOAD app.deb", "UPLOAD app.tar.gz"},
+			assets:   []string{"app.deb", "app.tar.gz"},
+		},
+		{
+			name:      "new draft",
+			publisher: GitHubPublisher{Draft: true},
+			requests:  []string{"POST releases", "UPLOAD app.deb", "UPLOAD app.tar.gz"},
+			assets:    []string{"app.deb", "app.tar.gz"},
+			draft:     true,
+		},
+		{
+			name: "draft on a later page",
+			setup: func(f *fakeGitHub) {
+				for i := 0; i < 5; i++ {
+					f.addRelease(fmt.Sprintf("v0.%d.0", i), false)
+				}
+				f.addRelease("v1.0.0", true)
+			},
+			requests: []string{"PATCH releases/6", "UPLOAD app.deb", "UPLOAD app.tar.gz"},
+			assets:   []string{"app.deb", "app.tar.gz"},
+			draft:    true,
+		},
+		{
+			name: "assets on later pages",
+			setup: func(f *fakeGitHub) {
+				release := f.addRelease("v1.0.0", false)
+				for i := 0; i < 5; i++ {
+					f.addAsset(release.ID, fmt.Sprintf("other%d", i), []byte("other"))
+				}
+				f.addAsset(release.ID, "app.tar.gz", []byte("archive"))
+				f.addAsset(release.ID, "app.deb", []byte("outdated"))
+			},
+			requests: []string{"PATCH releases/1", "DELETE releases/assets/8", "UPLOAD app.deb"},
+			assets:   []string{"app.deb", "app.tar.gz", "other0", "other1", "other2", "other3", "other4"},
+		},
+		{
+			name: "forced",
+			setup: func(f *fakeGitHub) {
+				release := f.addRelease("v1.0.0", false)
+				f.addAsset(release.ID, "app.tar.gz", []byte("archive"))
+			},
+			force:    true,
+			requests: []string{"PATCH releases/1", "UPLOAD app.deb", "DELETE releases/assets/2", "UPLOAD app.tar.gz"},
+			assets:   []string{"app.deb", "app.tar.gz"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"dist/app.tar.gz": "archive",
+				"dist/app.deb":    "package",
+			})
+			chdir(t, dir)
+			t.Setenv("RELEASE_TOKEN", "secret")
+
+			f := newFakeGitHub(t, 2)
+			if test.setup != nil {
+				test.setup(f)
+			}
+			publisher := test.publisher
+			publisher.BaseURL = f.server.URL
+			publisher.Owner = "owner"
+			publisher.Repository = "repo"
+			publisher.TokenEnv = "RELEASE_TOKEN"
+
+			err := publisher.Publish(&Release{
+				Version: BasicVersion("1.0.0"),
+				Tag:     "v1.0.0",
+				Notes:   "notes",
+				Artifacts: []*Artifact{
+					{Path: "dist/app.deb", Kind: ArtifactPackage},
+					{Path: "dist/app.tar.gz", Kind: ArtifactArchive},
+				},
+				Force: test.force,
+			})
+			checkError(t, err, test.wantErr)
+			if err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(f.requests, test.requests) {
+				t.Errorf("expected the requests %v, got %v", test.requests, f.requests)
+			}
+			var release *gitHubRelease
+			for _, r := range f.releases {
+				if r.TagName == "v1.0.0" {
+					release = r
+				}
+			}
+			if release == nil || release.Body != "notes" || release.Draft != test.draft {
+				t.Fatalf("unexpected release %+v", release)
+			}
+			if names := f.assetNames(release.ID); !reflect.DeepEqual(names, test.assets) {
+				t.Errorf("expected the assets %v, got %v", test.assets, names)
+			}
+			if f.authorization != "Bearer secret" {
+				t.Errorf("expected the token to be sent, got %q", f.authorization)
+			}
+		})
+	}
+}
+
+func TestGitHubPublisherErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message":"Resource not accessible by integration"}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		repository string
+		artifacts  []*Artifact
+		wantErr    string
+	}{
+		{
+			name:       "server error",
+			repository: "repo",
+			artifacts:  []*Artifact{{Path: "dist/app"}},
+			wantErr:    "403 Forbidden: Resource not accessible by integration",
+		},
+		{
+			name:       "same asset name",
+			repository: "repo",
+			artifacts:  []*Artifact{{Path: "dist/linux/app"}, {Path: "dist/darwin/app"}},
+			wantErr:    "would be uploaded as the same asset",
+		},
+		{
+			name:    "no repository",
+			wantErr: "no GitHub repository given",
+		},
+	}
+	for _, test := range tests {
+		publisher := &GitHubPublisher{BaseURL: server.URL, Owner: "owner", Repository: test.repository}
+		err := publisher.Publish(&Release{Tag: "v1.0.0", Artifacts: test.artifacts})
+		checkError(t, err, test.wantErr)
+	}
+}
+
+func TestGitHubPublisherAuthorization(t *testing.T) {
+	var authorization string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer other.Close()
+	f := newFakeGitHub(t, 2)
+
+	tests := []struct {
+		name       string
+		publisher  GitHubPublisher
+		address    string
+		authorized bool
+	}{
+		{name: "base URL", publisher: GitHubPublisher{BaseURL: f.server.URL}, address: other.URL, authorized: false},
+		{name: "upload URL", publisher: GitHubPublisher{BaseURL: f.server.URL, UploadURL: other.URL}, address: other.URL + "/repos", authorized: true},
+		{name: "same host", publisher: GitHubPublisher{BaseURL: other.URL + "/api/v3"}, address: other.URL + "/api/uploads", authorized: true},
+		{name: "default hosts", address: other.URL, authorized: false},
+	}
+	for _, test := range tests {
+		authorization = ""
+		test.publisher.Token = "secret"
+		req, err := http.NewRequest("GET", test.address, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := test.publisher.send(req, nil); err != nil {
+			t.Fatal(err)
+		}
+		if authorized := authorization == "Bearer secret"; authorized != test.authorized {
+			t.Errorf("%s: expected authorized %v, got header %q", test.name, test.authorized, authorization)
+		}
+	}
+
+	hosts := []struct {
+		address    string
+		authorized bool
+	}{
+		{address: "https://api.github.com/repos/owner/repo/releases", authorized: true},
+		{address: "https://uploads.github.com/repos/owner/repo/releases/1/assets", authorized: true},
+		{address: "https://objects.githubusercontent.com/asset", authorized: false},
+	}
+	publisher := &GitHubPublisher{}
+	for _, host := range hosts {
+		u, _ := url.Parse(host.address)
+		if authorized := publisher.isAPIHost(u); authorized != host.authorized {
+			t.Errorf("%s: expected authorized %v, got %v", host.address, host.authorized, authorized)
+		}
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	tests := []struct {
+		links []string
+		next  string
+	}{
+		{links: []string{`<https://api.github.com/x?page=2>; rel="next", <https://api.github.com/x?page=5>; rel="last"`}, next: "https://api.github.com/x?page=2"},
+		{links: []string{`<https://api.github.com/x?page=1>; rel="prev"`, `<https://api.github.com/x?page=3>; rel=next`}, next: "https://api.github.com/x?page=3"},
+		{links: []string{`<https://api.github.com/x?page=3>; title="a"; rel="last next"`}, next: "https://api.github.com/x?page=3"},
+		{links: []string{`<https://api.github.com/x?page=1>; rel="first", <https://api.github.com/x?page=4>; rel="prev"`}},
+		{links: []string{`https://api.github.com/x?page=2; rel="next"`}},
+		{},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		for _, link := range test.links {
+			header.Add("Link", link)
+		}
+		if next := nextLink(header); next != test.next {
+			t.Errorf("%v: expected %q, got %q", test.links, test.next, next)
+		}
+	}
+}
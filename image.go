@@ -0,0 +1,698 @@
+package make
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ImageTargetNamePrefix is the prefix all ImageTargets
+// will have in theire name.
+const ImageTargetNamePrefix = "image_"
+
+// Media types of the OCI image specification.
+const (
+	ociIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerMediaType    = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerManifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"
+
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+const defaultImagePath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// ImageTarget assembles an OCI image layout on disk containing
+// one image per Linux BuildTarget, combined by a multi-arch index.
+// No container runtime is needed, the layout can be pushed by tools
+// like skopeo or crane. The BuildTargets are executed as dependencies.
+type ImageTarget struct {
+	// ImageName is the name of the image, e. g. "example/app".
+	ImageName string
+	// Tag is the reference name of the image in the layout.
+	// Defaults to the Version or "latest" if there is none.
+	Tag string
+	// Version defaults to the Version of the first Binary.
+	Version Version
+
+	// Binaries are the Linux BuildTargets whose outputs are added
+	// to the image, one for each platform.
+	Binaries []*BuildTarget
+	// BinaryDestination is the absolute path of the binary in the
+	// image. Defaults to "/usr/local/bin/<base of ImageName>".
+	BinaryDestination string
+
+	// BaseLayout is the optional path of a local OCI image layout
+	// containing the base image. It has to contain an image for
+	// each platform of the Binaries. If empty the image is built
+	// from scratch.
+	BaseLayout string
+	// BaseTag selects the base image by its reference name if
+	// the BaseLayout contains more than one.
+	BaseTag string
+
+	// Entrypoint defaults to the BinaryDestination.
+	Entrypoint []string
+	Cmd        []string
+	// Env contains variables of the form key=value that are added
+	// to the ones of the base image.
+	Env          []string
+	ExposedPorts []string
+	User         string
+	WorkingDir   string
+	// Labels are added to the default labels
+	// "org.opencontainers.image.title", "...version", "...created"
+	// and "...revision", which are filled from the ImageName,
+	// Version, build date and git commit.
+	Labels map[string]string
+
+	// OutputDir is the directory the layout is written to.
+	// If empty it defaults to the OutputRoot of the Suite.
+	OutputDir string
+
+	// defaultOutputDir is the output directory of the Suite
+	// the target is registered with.
+	defaultOutputDir string
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Manifests     []*ociDescriptor  `json:"manifests"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        *ociDescriptor    `json:"config"`
+	Layers        []*ociDescriptor  `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ociImageConfig is the config of an image. Fields unknown to
+// this struct are kept, so they survive when the config of a base
+// image is modified.
+type ociImageConfig struct {
+	Created      string             `json:"created,omitempty"`
+	Author       string             `json:"author,omitempty"`
+	Architecture string             `json:"architecture"`
+	OS           string             `json:"os"`
+	Variant      string             `json:"variant,omitempty"`
+	Config       ociContainerConfig `json:"config"`
+	RootFS       ociRootFS          `json:"rootfs"`
+	History      []ociHistory       `json:"history,omitempty"`
+
+	extra ociExtra
+}
+
+// MarshalJSON encodes the config including the unknown fields.
+func (c ociImageConfig) MarshalJSON() ([]byte, error) {
+	type plain ociImageConfig
+	return marshalWithExtra(plain(c), c.extra)
+}
+
+// UnmarshalJSON decodes the config and keeps the unknown fields.
+func (c *ociImageConfig) UnmarshalJSON(data []byte) error {
+	type plain ociImageConfig
+	var err error
+	c.extra, err = unmarshalWithExtra(data, (*plain)(c))
+	return err
+}
+
+// ociContainerConfig is the container configuration of an
+// image. Like ociImageConfig it keeps unknown fields, e. g.
+// the Healthcheck of a docker image.
+type ociContainerConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+
+	extra ociExtra
+}
+
+// MarshalJSON encodes the config including the unknown fields.
+func (c ociContainerConfig) MarshalJSON() ([]byte, error) {
+	type plain ociContainerConfig
+	return marshalWithExtra(plain(c), c.extra)
+}
+
+// UnmarshalJSON decodes the config and keeps the unknown fields.
+func (c *ociContainerConfig) UnmarshalJSON(data []byte) error {
+	type plain ociContainerConfig
+	var err error
+	c.extra, err = unmarshalWithExtra(data, (*plain)(c))
+	return err
+}
+
+// ociExtra contains the raw JSON fields unknown to a struct.
+type ociExtra map[string]json.RawMessage
+
+// unmarshalWithExtra decodes data into the struct v points to
+// and returns the fields that have no equivalent in the struct.
+func unmarshalWithExtra(data []byte, v interface{}) (ociExtra, error) {
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	extra := make(ociExtra)
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return nil, err
+	}
+	t := reflect.TypeOf(v).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; name != "" {
+			delete(extra, name)
+		}
+	}
+	return extra, nil
+}
+
+// marshalWithExtra encodes v and adds the extra fields.
+func marshalWithExtra(v interface{}, extra ociExtra) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	fields := make(ociExtra)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		fields[key] = value
+	}
+	return json.Marshal(fields)
+}
+
+type ociRootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type ociHistory struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+// imagePlatform returns the platform of an image as used by
+// OCI indexes. The variant of Arm images is taken from GOARM,
+// which go build uses as well, and defaults to ARMv7.
+func imagePlatform(p *Platform) *ociPlatform {
+	platform := &ociPlatform{Architecture: p.Arch.String(), OS: p.OS.String()}
+	if p.Arch == Arm {
+		goarm := strings.Split(os.Getenv("GOARM"), ",")[0]
+		if goarm == "" {
+			goarm = "7"
+		}
+		platform.Variant = "v" + goarm
+	}
+	return platform
+}
+
+func (p *ociPlatform) matches(other *ociPlatform) bool {
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+	return p.Variant == "" || other.Variant == "" || p.Variant == other.Variant
+}
+
+// Execute builds the binaries and writes the image layout.
+func (t *ImageTarget) Execute(suite *Suite) error {
+	deps := make([]Target, len(t.Binaries))
+	for i, binary := range t.Binaries {
+		deps[i] = binary
+	}
+	if err := suite.executeDependencies(deps); err != nil {
+		return err
+	}
+
+	layoutDir := t.OutputName()
+	fmt.Println("Assembling image:", layoutDir)
+	err := os.MkdirAll(filepath.Dir(layoutDir), 0755)
+	if err != nil {
+		return err
+	}
+	tmpDir, err := ioutil.TempDir(filepath.Dir(layoutDir), "."+filepath.Base(layoutDir)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		return err
+	}
+
+	err = t.writeLayout(tmpDir)
+	if err != nil {
+		return fmt.Errorf("could not create image %s: %v", t.ImageName, err)
+	}
+
+	if err := os.RemoveAll(layoutDir); err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, layoutDir)
+}
+
+func (t *ImageTarget) writeLayout(dir string) error {
+	layout := &ociLayout{dir: dir}
+
+	var base *ociLayout
+	var baseIndex *ociDescriptor
+	if t.BaseLayout != "" {
+		base = &ociLayout{dir: t.BaseLayout}
+		var err error
+		baseIndex, err = base.lookupTag(t.BaseTag)
+		if err != nil {
+			return err
+		}
+	}
+
+	index := &ociIndex{SchemaVersion: 2, MediaType: ociIndexMediaType}
+	for _, binary := range t.Binaries {
+		platform := imagePlatform(binary.Platform)
+
+		manifest := &ociManifest{SchemaVersion: 2, MediaType: ociManifestMediaType}
+		config := &ociImageConfig{}
+		if base != nil {
+			var err error
+			manifest, config, err = base.image(baseIndex, platform)
+			if err != nil {
+				return err
+			}
+			for _, blob := range manifest.Layers {
+				if err := layout.copyBlob(base, blob); err != nil {
+					return err
+				}
+			}
+			manifest.MediaType = ociManifestMediaType
+			manifest.Annotations = nil
+		}
+
+		layer, diffID, err := t.layer(binary)
+		if err != nil {
+			return err
+		}
+		layerDesc, err := layout.writeBlob(ociLayerMediaType, layer)
+		if err != nil {
+			return err
+		}
+
+		t.configure(config, platform, diffID)
+		configData, err := json.Marshal(config)
+		if err != nil {
+			return err
+		}
+		manifest.Config, err = layout.writeBlob(ociConfigMediaType, configData)
+		if err != nil {
+			return err
+		}
+		manifest.Layers = append(manifest.Layers, layerDesc)
+
+		manifestDesc, err := layout.writeJSON(ociManifestMediaType, manifest)
+		if err != nil {
+			return err
+		}
+		manifestDesc.Platform = platform
+		index.Manifests = append(index.Manifests, manifestDesc)
+	}
+
+	indexDesc, err := layout.writeJSON(ociIndexMediaType, index)
+	if err != nil {
+		return err
+	}
+	indexDesc.Annotations = map[string]string{ociRefNameAnnotation: t.tag()}
+
+	data, err := json.Marshal(&ociIndex{SchemaVersion: 2, Manifests: []*ociDescriptor{indexDesc}})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), data, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644)
+}
+
+// layer returns the gzipped layer containing the binary and the
+// digest of the uncompressed layer.
+func (t *ImageTarget) layer(binary *BuildTarget) ([]byte, string, error) {
+	filename, err := binary.OutputPath()
+	if err != nil {
+		return nil, "", err
+	}
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return nil, "", err
+	}
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	diffID := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(gz, diffID))
+
+	mtime := buildDate()
+	destination := strings.TrimPrefix(t.binaryDestination(), "/")
+	var dirs []string
+	for dir := path.Dir(destination); dir != "."; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+	}
+	for _, dir := range dirs {
+		if err := writeTarDir(tw, dir+"/", mtime); err != nil {
+			return nil, "", err
+		}
+	}
+	entry := &packageEntry{source: filename, mode: 0755, size: stat.Size()}
+	if err := writeTarFile(tw, destination, entry, mtime, ioutil.Discard); err != nil {
+		return nil, "", err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "sha256:" + hex.EncodeToString(diffID.Sum(nil)), nil
+}
+
+// configure adds the layer and the container configuration to
+// the image config.
+func (t *ImageTarget) configure(config *ociImageConfig, platform *ociPlatform, diffID string) {
+	created := buildDate().UTC().Format(time.RFC3339)
+
+	config.Created = created
+	config.Architecture = platform.Architecture
+	config.OS = platform.OS
+	config.Variant = platform.Variant
+	config.RootFS.Type = "layers"
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	config.History = append(config.History, ociHistory{
+		Created:   created,
+		CreatedBy: "go-make",
+		Comment:   "add " + t.binaryDestination(),
+	})
+
+	c := &config.Config
+	if len(c.Env) == 0 {
+		c.Env = []string{defaultImagePath}
+	}
+	for _, env := range t.Env {
+		parts := strings.SplitN(env+"=", "=", 2)
+		c.Env = setEnv(c.Env, parts[0], strings.TrimSuffix(parts[1], "="))
+	}
+	if t.Entrypoint != nil {
+		c.Entrypoint = t.Entrypoint
+	} else {
+		c.Entrypoint = []string{t.binaryDestination()}
+	}
+	if t.Cmd != nil || t.Entrypoint == nil {
+		c.Cmd = t.Cmd
+	}
+	if len(t.ExposedPorts) > 0 && c.ExposedPorts == nil {
+		c.ExposedPorts = make(map[string]struct{})
+	}
+	for _, port := range t.ExposedPorts {
+		if !strings.Contains(port, "/") {
+			port += "/tcp"
+		}
+		c.ExposedPorts[port] = struct{}{}
+	}
+	if t.User != "" {
+		c.User = t.User
+	}
+	if t.WorkingDir != "" {
+		c.WorkingDir = t.WorkingDir
+	}
+
+	if c.Labels == nil {
+		c.Labels = make(map[string]string)
+	}
+	c.Labels["org.opencontainers.image.title"] = t.ImageName
+	c.Labels["org.opencontainers.image.created"] = created
+	if v := t.version(); v != nil {
+		c.Labels["org.opencontainers.image.version"] = v.String()
+	}
+	if commit := CurrentGitInfo().Commit; commit != "" {
+		c.Labels["org.opencontainers.image.revision"] = commit
+	}
+	for key, value := range t.Labels {
+		c.Labels[key] = value
+	}
+}
+
+// ociLayout is an OCI image layout directory.
+type ociLayout struct {
+	dir string
+}
+
+func (l *ociLayout) blobPath(digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.ContainsAny(parts[1], "/\\.") {
+		return "", fmt.Errorf("invalid digest \"%s\"", digest)
+	}
+	return filepath.Join(l.dir, "blobs", parts[0], parts[1]), nil
+}
+
+func (l *ociLayout) writeBlob(mediaType string, data []byte) (*ociDescriptor, error) {
+	sum := sha256.Sum256(data)
+	desc := &ociDescriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + hex.EncodeToString(sum[:]),
+		Size:      int64(len(data)),
+	}
+	filename, _ := l.blobPath(desc.Digest)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+	return desc, ioutil.WriteFile(filename, data, 0644)
+}
+
+func (l *ociLayout) writeJSON(mediaType string, v interface{}) (*ociDescriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return l.writeBlob(mediaType, data)
+}
+
+func (l *ociLayout) readBlob(desc *ociDescriptor) ([]byte, error) {
+	filename, err := l.blobPath(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != desc.Size {
+		return nil, fmt.Errorf("blob %s has an invalid size", desc.Digest)
+	}
+	return data, nil
+}
+
+func (l *ociLayout) copyBlob(from *ociLayout, desc *ociDescriptor) error {
+	source, err := from.blobPath(desc.Digest)
+	if err != nil {
+		return err
+	}
+	destination, _ := l.blobPath(desc.Digest)
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomically(destination, 0644, func(w io.Writer) error {
+		return copyFile(w, source)
+	})
+}
+
+// lookupTag returns the descriptor in the index.json with the given
+// reference name. If the tag is empty the index has to contain
+// exactly one descriptor.
+func (l *ociLayout) lookupTag(tag string) (*ociDescriptor, error) {
+	data, err := ioutil.ReadFile(filepath.Join(l.dir, "index.json"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base layout: %v", err)
+	}
+	index := &ociIndex{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("invalid base layout: %v", err)
+	}
+
+	if tag == "" {
+		if len(index.Manifests) != 1 {
+			return nil, fmt.Errorf("base layout %s contains %d images, a tag is needed", l.dir, len(index.Manifests))
+		}
+		return index.Manifests[0], nil
+	}
+	for _, desc := range index.Manifests {
+		if desc.Annotations[ociRefNameAnnotation] == tag {
+			return desc, nil
+		}
+	}
+	return nil, fmt.Errorf("base layout %s contains no image tagged %s", l.dir, tag)
+}
+
+// image returns the manifest and config of the image for the
+// platform, resolving nested indexes.
+func (l *ociLayout) image(desc *ociDescriptor, platform *ociPlatform) (*ociManifest, *ociImageConfig, error) {
+	data, err := l.readBlob(desc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch desc.MediaType {
+	case ociIndexMediaType, dockerManifestListMediaType:
+		index := &ociIndex{}
+		if err := json.Unmarshal(data, index); err != nil {
+			return nil, nil, err
+		}
+		for _, child := range index.Manifests {
+			if child.Platform != nil && !child.Platform.matches(platform) {
+				continue
+			}
+			manifest, config, err := l.image(child, platform)
+			if err == nil {
+				return manifest, config, nil
+			}
+		}
+		return nil, nil, fmt.Errorf("base layout %s contains no image for %s/%s", l.dir, platform.OS, platform.Architecture)
+
+	case ociManifestMediaType, dockerManifestMediaType:
+		manifest := &ociManifest{}
+		if err := json.Unmarshal(data, manifest); err != nil {
+			return nil, nil, err
+		}
+		configData, err := l.readBlob(manifest.Config)
+		if err != nil {
+			return nil, nil, err
+		}
+		config := &ociImageConfig{}
+		if err := json.Unmarshal(configData, config); err != nil {
+			return nil, nil, err
+		}
+		if !platform.matches(&ociPlatform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}) {
+			return nil, nil, fmt.Errorf("base image is %s/%s", config.OS, config.Architecture)
+		}
+		return manifest, config, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported media type %s", desc.MediaType)
+}
+
+func (t *ImageTarget) binaryDestination() string {
+	if t.BinaryDestination != "" {
+		return t.BinaryDestination
+	}
+	return "/usr/local/bin/" + path.Base(t.ImageName)
+}
+
+func (t *ImageTarget) version() Version {
+	if t.Version != nil || len(t.Binaries) == 0 {
+		return t.Version
+	}
+	return t.Binaries[0].Version
+}
+
+func (t *ImageTarget) tag() string {
+	if t.Tag != "" {
+		return t.Tag
+	}
+	if v := t.version(); v != nil {
+		return v.String()
+	}
+	return "latest"
+}
+
+// OutputName returns the directory of the image layout
+// including the OutputDir.
+func (t *ImageTarget) OutputName() string {
+	return filepath.Join(t.outputDir(), strings.Replace(t.ImageName, "/", "_", -1)+"_oci")
+}
+
+// outputIsDirectory makes derived CleanTargets remove the
+// layout recursively.
+func (t *ImageTarget) outputIsDirectory() bool {
+	return true
+}
+
+// Validate returns an error if there are no Binaries, one
+// of them is not a Linux BuildTarget or two of them are built
+// for the same platform.
+func (t *ImageTarget) Validate() error {
+	if t.ImageName == "" {
+		return fmt.Errorf("image has no name")
+	}
+	if len(t.Binaries) == 0 {
+		return fmt.Errorf("image %s has no binaries", t.ImageName)
+	}
+	platforms := make(PlatformSet, 0, len(t.Binaries))
+	for _, binary := range t.Binaries {
+		if binary.Platform == nil {
+			return fmt.Errorf("image %s contains a binary without platform", t.ImageName)
+		}
+		if binary.Platform.OS != Linux {
+			return fmt.Errorf("image %s can only contain linux binaries", t.ImageName)
+		}
+		if ok, _ := platforms.Contains(binary.Platform); ok {
+			return fmt.Errorf("image %s contains multiple binaries for %s", t.ImageName, binary.Platform)
+		}
+		platforms = append(platforms, binary.Platform)
+	}
+	if !path.IsAbs(t.binaryDestination()) {
+		return fmt.Errorf("destination %s of image %s is not absolute", t.BinaryDestination, t.ImageName)
+	}
+	return nil
+}
+
+func (t *ImageTarget) setDefaultOutputDir(suite *Suite) error {
+	t.defaultOutputDir = suite.OutputRoot
+	return nil
+}
+
+// outputDir returns the OutputDir or, if it is empty, the
+// output directory of the Suite.
+func (t *ImageTarget) outputDir() string {
+	if t.OutputDir != "" {
+		return t.OutputDir
+	}
+	return t.defaultOutputDir
+}
+
+// Name returns the name of this Target.
+// The name will consist of the ImageTargetNamePrefix
+// followed by the ImageName.
+func (t *ImageTarget) Name() string {
+	return ImageTargetNamePrefix + t.ImageName
+}
@@ -0,0 +1,230 @@
+package make
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImagePlatform(t *testing.T) {
+	tests := []struct {
+		platform *Platform
+		goarm    string
+		expected *ociPlatform
+	}{
+		{platform: LinuxAmd64, goarm: "6", expected: &ociPlatform{Architecture: "amd64", OS: "linux"}},
+		{platform: LinuxArm, expected: &ociPlatform{Architecture: "arm", OS: "linux", Variant: "v7"}},
+		{platform: LinuxArm, goarm: "6", expected: &ociPlatform{Architecture: "arm", OS: "linux", Variant: "v6"}},
+		{platform: LinuxArm, goarm: "5,softfloat", expected: &ociPlatform{Architecture: "arm", OS: "linux", Variant: "v5"}},
+	}
+	for _, test := range tests {
+		t.Setenv("GOARM", test.goarm)
+		if platform := imagePlatform(test.platform); !reflect.DeepEqual(platform, test.expected) {
+			t.Errorf("%s with GOARM=%s: expected %+v, got %+v", test.platform, test.goarm, test.expected, platform)
+		}
+	}
+}
+
+func TestOCIPlatformMatches(t *testing.T) {
+	tests := []struct {
+		a, b    ociPlatform
+		matches bool
+	}{
+		{ociPlatform{"amd64", "linux", ""}, ociPlatform{"amd64", "linux", ""}, true},
+		{ociPlatform{"arm", "linux", "v7"}, ociPlatform{"arm", "linux", ""}, true},
+		{ociPlatform{"arm", "linux", "v7"}, ociPlatform{"arm", "linux", "v6"}, false},
+		{ociPlatform{"amd64", "linux", ""}, ociPlatform{"arm64", "linux", ""}, false},
+		{ociPlatform{"amd64", "linux", ""}, ociPlatform{"amd64", "windows", ""}, false},
+	}
+	for _, test := range tests {
+		if matches := test.a.matches(&test.b); matches != test.matches {
+			t.Errorf("%+v and %+v: expected %v", test.a, test.b, test.matches)
+		}
+	}
+}
+
+func TestOCIImageConfigUnknownFields(t *testing.T) {
+	const base = `{"architecture":"amd64","os":"linux","os.version":"5.10","variant":"v8",` +
+		`"config":{"Env":["A=b"],"Healthcheck":{"Test":["CMD","true"]},"ArgsEscaped":true},` +
+		`"rootfs":{"type":"layers","diff_ids":["sha256:0"]}}`
+
+	config := &ociImageConfig{}
+	if err := json.Unmarshal([]byte(base), config); err != nil {
+		t.Fatal(err)
+	}
+	config.Variant = ""
+	config.Config.Env = append(config.Config.Env, "C=d")
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := make(map[string]interface{})
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	container := decoded["config"].(map[string]interface{})
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected interface{}
+	}{
+		{"os.version", decoded["os.version"], "5.10"},
+		{"variant", decoded["variant"], nil},
+		{"Healthcheck", container["Healthcheck"], map[string]interface{}{"Test": []interface{}{"CMD", "true"}}},
+		{"ArgsEscaped", container["ArgsEscaped"], true},
+		{"Env", container["Env"], []interface{}{"A=b", "C=d"}},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.value, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, test.value)
+		}
+	}
+}
+
+// writeBaseLayout writes an OCI layout tagged "base" containing
+// a linux/amd64 image with a single layer.
+func writeBaseLayout(t *testing.T, dir string) {
+	t.Helper()
+	layout := &ociLayout{dir: dir}
+	layer, err := layout.writeBlob(ociLayerMediaType, []byte("base layer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := layout.writeBlob(ociConfigMediaType, []byte(`{"architecture":"amd64","os":"linux",`+
+		`"config":{"Env":["PATH=/bin","LANG=C"],"User":"nobody","Healthcheck":{"Test":["CMD","true"]}},`+
+		`"rootfs":{"type":"layers","diff_ids":["sha256:base"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := layout.writeJSON(ociManifestMediaType, &ociManifest{SchemaVersion: 2, Config: config, Layers: []*ociDescriptor{layer}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Platform = &ociPlatform{Architecture: "amd64", OS: "linux"}
+	index, err := layout.writeJSON(ociIndexMediaType, &ociIndex{SchemaVersion: 2, Manifests: []*ociDescriptor{manifest}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Annotations = map[string]string{ociRefNameAnnotation: "base"}
+	data, _ := json.Marshal(&ociIndex{SchemaVersion: 2, Manifests: []*ociDescriptor{index}})
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImageTargetExecute(t *testing.T) {
+	tests := []struct {
+		name      string
+		platforms PlatformSet
+		base      bool
+		layers    int
+		env       []string
+		user      string
+	}{
+		{
+			name:      "scratch",
+			platforms: PlatformSet{LinuxAmd64, LinuxArm},
+			layers:    1,
+			env:       []string{defaultImagePath, "MODE=prod"},
+		},
+		{
+			name:      "base",
+			platforms: PlatformSet{LinuxAmd64},
+			base:      true,
+			layers:    2,
+			env:       []string{"PATH=/bin", "LANG=C", "MODE=prod"},
+			user:      "nobody",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeMainModule(t, dir)
+			chdir(t, dir)
+			t.Setenv("GOARM", "6")
+
+			target := &ImageTarget{
+				ImageName: "example/app",
+				Binaries:  MultiPlatformBuild(&BuildTarget{ExecutableName: DefaultNameTemplate("app"), Version: BasicVersion("1.0.0")}, test.platforms),
+				Env:       []string{"MODE=prod"},
+				OutputDir: "dist",
+			}
+			if test.base {
+				writeBaseLayout(t, filepath.Join(dir, "base"))
+				target.BaseLayout = "base"
+				target.BaseTag = "base"
+			}
+			if err := NewBuildSuite(test.platforms).Execute(target); err != nil {
+				t.Fatal(err)
+			}
+
+			layout := &ociLayout{dir: filepath.Join("dist", "example_app_oci")}
+			desc, err := layout.lookupTag("1.0.0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range test.platforms {
+				manifest, config, err := layout.image(desc, imagePlatform(p))
+				if err != nil {
+					t.Fatalf("%s: %v", p, err)
+				}
+				if len(manifest.Layers) != test.layers || len(config.RootFS.DiffIDs) != test.layers {
+					t.Errorf("%s: expected %d layers, got %d", p, test.layers, len(manifest.Layers))
+				}
+				if config.Variant != imagePlatform(p).Variant {
+					t.Errorf("%s: unexpected variant %q", p, config.Variant)
+				}
+				c := config.Config
+				if !reflect.DeepEqual(c.Env, test.env) || c.User != test.user {
+					t.Errorf("%s: unexpected env %v and user %q", p, c.Env, c.User)
+				}
+				if !reflect.DeepEqual(c.Entrypoint, []string{"/usr/local/bin/app"}) || c.Labels["org.opencontainers.image.version"] != "1.0.0" {
+					t.Errorf("%s: unexpected config %+v", p, c)
+				}
+				if _, ok := c.extra["Healthcheck"]; ok != test.base {
+					t.Errorf("%s: the healthcheck of the base image was not kept", p)
+				}
+
+				layer, err := layout.readBlob(manifest.Layers[len(manifest.Layers)-1])
+				if err != nil {
+					t.Fatal(err)
+				}
+				headers, _ := readTar(t, gunzip(t, layer))
+				names := make([]string, 0)
+				for name := range headers {
+					names = append(names, name)
+				}
+				if header := headers["usr/local/bin/app"]; header == nil || header.Mode != 0755 || len(headers) != 4 {
+					t.Errorf("%s: unexpected layer %v", p, names)
+				}
+			}
+			if strings.Contains(strings.Join(listFiles(t, "dist"), " "), ".tmp") {
+				t.Errorf("temporary files were left behind")
+			}
+		})
+	}
+}
+
+func TestImageTargetValidate(t *testing.T) {
+	amd64 := &BuildTarget{Platform: LinuxAmd64}
+	tests := []struct {
+		target  *ImageTarget
+		wantErr string
+	}{
+		{target: &ImageTarget{ImageName: "app", Binaries: []*BuildTarget{amd64, {Platform: LinuxArm64}}}},
+		{target: &ImageTarget{Binaries: []*BuildTarget{amd64}}, wantErr: "image has no name"},
+		{target: &ImageTarget{ImageName: "app"}, wantErr: "has no binaries"},
+		{target: &ImageTarget{ImageName: "app", Binaries: []*BuildTarget{{}}}, wantErr: "binary without platform"},
+		{target: &ImageTarget{ImageName: "app", Binaries: []*BuildTarget{{Platform: WindowsAmd64}}}, wantErr: "can only contain linux binaries"},
+		{target: &ImageTarget{ImageName: "app", Binaries: []*BuildTarget{amd64, amd64}}, wantErr: "multiple binaries for linux_amd64"},
+		{target: &ImageTarget{ImageName: "app", Binaries: []*BuildTarget{amd64}, BinaryDestination: "bin/app"}, wantErr: "is not absolute"},
+	}
+	for _, test := range tests {
+		checkError(t, test.target.Validate(), test.wantErr)
+	}
+}
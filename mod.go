@@ -0,0 +1,117 @@
+package make
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ModTargetName is the name of the ModTarget.
+const ModTargetName = "mod"
+
+// ModTarget runs "go mod download", "go mod tidy" and "go mod verify".
+//
+// Add it to the Dependencies of a BuildTarget to run it before
+// building. It will only be executed once per Suite.Execute,
+// regardless of how many BuildTargets depend on it.
+type ModTarget struct {
+	// Dir is the root directory of the module. Defaults to the
+	// root of the module containing the working directory.
+	Dir string
+
+	// If none of Download, Tidy and Verify is set, Tidy and
+	// Verify are run.
+	Download bool
+	Tidy     bool
+	Verify   bool
+
+	// If Check is true go.mod and go.sum are left untouched and
+	// the target fails if "go mod tidy" would change them. This
+	// requires Go 1.23 or newer.
+	Check bool
+
+	// Where to redirect the go commands stdout. If nil
+	// stdout will be redirected to this precesses stdout.
+	Stdout io.Writer
+	// Where to redirect the go commands stderr. If nil
+	// stderr will be redirected to this precesses stderr.
+	Stderr io.Writer
+}
+
+// Execute runs the go mod commands.
+func (t *ModTarget) Execute(suite *Suite) error {
+	dir := t.Dir
+	if dir == "" {
+		var err error
+		dir, err = findModuleRoot(".")
+		if err != nil {
+			return err
+		}
+	}
+
+	download, tidy, verify := t.Download, t.Tidy, t.Verify
+	if !download && !tidy && !verify {
+		tidy, verify = true, true
+	}
+
+	if download {
+		if err := t.command(dir, "download").Execute(suite); err != nil {
+			return err
+		}
+	}
+	if tidy {
+		var err error
+		if t.Check {
+			err = t.checkTidy(suite, dir)
+		} else {
+			err = t.command(dir, "tidy").Execute(suite)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if verify {
+		if err := t.command(dir, "verify").Execute(suite); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *ModTarget) command(dir, subcommand string) *CommandTarget {
+	return &CommandTarget{
+		TargetName: ModTargetName,
+		Command:    []string{"go", "mod", subcommand},
+		Dir:        escapeName(dir),
+		Stdout:     t.Stdout,
+		Stderr:     t.Stderr,
+	}
+}
+
+// checkTidy runs "go mod tidy -diff", which requires Go 1.23, and
+// returns an error containing the diffs if go.mod or go.sum would
+// change. The files are never written.
+func (t *ModTarget) checkTidy(suite *Suite, dir string) error {
+	diff := &bytes.Buffer{}
+	command := t.command(dir, "tidy")
+	command.Command = append(command.Command, "-diff")
+	command.Stdout = diff
+	err := command.Execute(suite)
+	if diff.Len() > 0 {
+		return fmt.Errorf("go mod tidy would change go.mod or go.sum:\n%s", diff)
+	}
+	if err != nil {
+		return fmt.Errorf("go mod tidy -diff failed, it requires Go 1.23 or newer: %v", err)
+	}
+	return nil
+}
+
+// Name returns the name of this Target.
+func (t *ModTarget) Name() string {
+	return ModTargetName
+}
+
+// Description returns the description of this Target.
+func (t *ModTarget) Description() string {
+	return "Tidies, downloads and verifies the module dependencies."
+}
@@ -0,0 +1,67 @@
+package make
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestModTarget(t *testing.T) {
+	const untidy = "module example.com/mod\n\ngo 1.16\n\nrequire example.com/dep v0.0.0\n\nreplace example.com/dep => ./dep\n"
+	tests := []struct {
+		name    string
+		target  *ModTarget
+		goMod   string
+		wantErr string
+	}{
+		{
+			name:   "zero value tidies",
+			target: &ModTarget{},
+		},
+		{
+			name:   "tidy",
+			target: &ModTarget{Tidy: true},
+		},
+		{
+			name:    "check",
+			target:  &ModTarget{Check: true},
+			goMod:   untidy,
+			wantErr: "would change go.mod",
+		},
+		{
+			name:   "verify only",
+			target: &ModTarget{Verify: true},
+			goMod:  untidy,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":     untidy,
+				"p.go":       "package p\n",
+				"dep/go.mod": "module example.com/dep\n",
+			})
+
+			test.target.Dir = dir
+			test.target.Stdout = ioutil.Discard
+			test.target.Stderr = ioutil.Discard
+			err := NewBuildSuite(nil).Execute(test.target)
+			checkError(t, err, test.wantErr)
+
+			goMod, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.goMod != "" {
+				if string(goMod) != test.goMod {
+					t.Errorf("expected go.mod to be unchanged, got %q", goMod)
+				}
+			} else if strings.Contains(string(goMod), "require") {
+				t.Errorf("expected go.mod to be tidied, got %q", goMod)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package make
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// findModuleRoot returns the absolute path of the directory
+// containing the go.mod file of the module dir belongs to. If
+// no go.mod file can be found the absolute dir is returned.
+func findModuleRoot(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for current := dir; ; {
+		if _, err := os.Stat(filepath.Join(current, "go.mod")); err == nil {
+			return current, nil
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return dir, nil
+		}
+		current = parent
+	}
+}
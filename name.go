@@ -1,8 +1,15 @@
 package make
 
 import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
+
+	"github.com/hashicorp/go-version"
 )
 
 const defaultPostfix = "_{{.OS}}-{{.Arch}}{{.Extension}}"
@@ -11,8 +18,13 @@ const defaultPostfix = "_{{.OS}}-{{.Arch}}{{.Extension}}"
 // consisting of the baseName followed by the OS, architecture and
 // optional extension.
 func DefaultNameTemplate(baseName string) *template.Template {
-	tmpl := template.New("executableName")
-	return template.Must(tmpl.Parse(escapeName(baseName) + defaultPostfix))
+	return template.Must(NameTemplate(escapeName(baseName) + defaultPostfix))
+}
+
+// NameTemplate parses the text as a template that can use the
+// functions of TemplateFuncs.
+func NameTemplate(text string) (*template.Template, error) {
+	return template.New("name").Funcs(TemplateFuncs()).Parse(text)
 }
 
 func escapeName(baseName string) string {
@@ -20,3 +32,109 @@ func escapeName(baseName string) string {
 	baseName = strings.Replace(baseName, "}}", "{{\"}}\"}}", -1)
 	return baseName
 }
+
+// TemplateFuncs returns the functions available in templates:
+//
+//	lower, upper                   change the case of a string
+//	replace OLD NEW S              replaces all OLD in S by NEW
+//	trimPrefix PREFIX S            removes the PREFIX from S
+//	trimSuffix SUFFIX S            removes the SUFFIX from S
+//	major, minor, patch VERSION    return a segment of a semantic version
+//	prerelease VERSION             returns the pre-release of a semantic version
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"lower": func(s interface{}) string {
+			return strings.ToLower(fmt.Sprint(s))
+		},
+		"upper": func(s interface{}) string {
+			return strings.ToUpper(fmt.Sprint(s))
+		},
+		"replace": func(old, new string, s interface{}) string {
+			return strings.Replace(fmt.Sprint(s), old, new, -1)
+		},
+		"trimPrefix": func(prefix string, s interface{}) string {
+			return strings.TrimPrefix(fmt.Sprint(s), prefix)
+		},
+		"trimSuffix": func(suffix string, s interface{}) string {
+			return strings.TrimSuffix(fmt.Sprint(s), suffix)
+		},
+		"major": semverSegment(0),
+		"minor": semverSegment(1),
+		"patch": semverSegment(2),
+		"prerelease": func(v interface{}) (string, error) {
+			parsed, err := version.NewVersion(fmt.Sprint(v))
+			if err != nil {
+				return "", err
+			}
+			return parsed.Prerelease(), nil
+		},
+	}
+}
+
+func semverSegment(i int) func(v interface{}) (int, error) {
+	return func(v interface{}) (int, error) {
+		parsed, err := version.NewVersion(fmt.Sprint(v))
+		if err != nil {
+			return 0, err
+		}
+		return parsed.Segments()[i], nil
+	}
+}
+
+// TemplateData is the data passed to templated names and
+// arguments of Targets. The Platform is embedded, so the same
+// variables as with the executable name template can be used,
+// e. g. "{{.OS}}".
+type TemplateData struct {
+	*Platform
+
+	Version Version
+	// BaseName is the base name of the binary if known.
+	BaseName string
+	// Git contains information about the git repository in the
+	// working directory. All fields are empty if there is none.
+	Git *GitInfo
+	// Date is the build date. If the environment variable
+	// SOURCE_DATE_EPOCH is set it is used instead of the time
+	// the process started, so all Targets agree on the date.
+	Date time.Time
+}
+
+// NewTemplateData returns the TemplateData for the given Platform,
+// Version and binary base name.
+func NewTemplateData(p *Platform, v Version, baseName string) *TemplateData {
+	return &TemplateData{
+		Platform: p,
+		Version:  v,
+		BaseName: baseName,
+		Git:      CurrentGitInfo(),
+		Date:     buildDate(),
+	}
+}
+
+// startTime is the build date if SOURCE_DATE_EPOCH is not set.
+var startTime = time.Now()
+
+func buildDate() time.Time {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		if seconds, err := strconv.ParseInt(epoch, 10, 64); err == nil {
+			return time.Unix(seconds, 0).UTC()
+		}
+	}
+	return startTime
+}
+
+// executeTemplate parses and executes the text as a template
+// with the given data.
+func executeTemplate(name, text string, data interface{}) (string, error) {
+	tmpl, err := template.New(name).Funcs(TemplateFuncs()).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,58 @@
+package make
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	tests := []struct {
+		template string
+		result   string
+		wantErr  string
+	}{
+		{template: "{{lower .OS}}-{{upper .Arch}}", result: "linux-AMD64"},
+		{template: "{{replace \".\" \"_\" .Version}}", result: "1_2_3-rc_1"},
+		{template: "{{trimPrefix \"v\" \"v1.0\"}} {{trimSuffix \".exe\" \"app.exe\"}}", result: "1.0 app"},
+		{template: "{{major .Version}}.{{minor .Version}}.{{patch .Version}}", result: "1.2.3"},
+		{template: "{{prerelease .Version}}", result: "rc.1"},
+		{template: "{{major \"not a version\"}}", wantErr: "error calling major"},
+		{template: "{{.BaseName}}{{.Extension}}", result: "app"},
+		{template: "{{.Date.Year}}", result: "2009"},
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1234567890")
+	data := NewTemplateData(LinuxAmd64, BasicVersion("1.2.3-rc.1"), "app")
+	for _, test := range tests {
+		tmpl, err := NameTemplate(test.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		err = tmpl.Execute(buf, data)
+		checkError(t, err, test.wantErr)
+		if err == nil && buf.String() != test.result {
+			t.Errorf("%s: expected %q, got %q", test.template, test.result, buf.String())
+		}
+	}
+}
+
+func TestDefaultNameTemplate(t *testing.T) {
+	tests := []struct {
+		baseName string
+		platform *Platform
+		name     string
+	}{
+		{"app", LinuxAmd64, "app_linux-amd64"},
+		{"app", WindowsAmd64, "app_windows-amd64.exe"},
+	}
+	for _, test := range tests {
+		buf := &bytes.Buffer{}
+		if err := DefaultNameTemplate(test.baseName).Execute(buf, NewTemplateData(test.platform, nil, "")); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.name {
+			t.Errorf("expected %s, got %s", test.name, buf.String())
+		}
+	}
+}
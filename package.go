@@ -0,0 +1,509 @@
+package make
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PackageTargetNamePrefix is the prefix all PackageTargets
+// will have in theire name.
+const PackageTargetNamePrefix = "package_"
+
+// PackageFormat represents the format of a Linux package.
+type PackageFormat string
+
+const (
+	// PackageDeb represents Debian packages.
+	PackageDeb PackageFormat = "deb"
+	// PackageRPM represents RPM packages.
+	PackageRPM PackageFormat = "rpm"
+	// PackageAPK represents Alpine packages.
+	PackageAPK PackageFormat = "apk"
+)
+
+// packageArchs maps the architectures to the names used by
+// the package formats.
+var packageArchs = map[PackageFormat]map[Arch]string{
+	PackageDeb: {
+		X386:     "i386",
+		Amd64:    "amd64",
+		Arm:      "armhf",
+		Arm64:    "arm64",
+		Ppc64:    "ppc64",
+		Ppc64LE:  "ppc64el",
+		Mips:     "mips",
+		MipsLE:   "mipsel",
+		Mips64:   "mips64",
+		Mips64LE: "mips64el",
+	},
+	PackageRPM: {
+		X386:     "i386",
+		Amd64:    "x86_64",
+		Arm:      "armv7hl",
+		Arm64:    "aarch64",
+		Ppc64:    "ppc64",
+		Ppc64LE:  "ppc64le",
+		Mips:     "mips",
+		MipsLE:   "mipsel",
+		Mips64:   "mips64",
+		Mips64LE: "mips64el",
+	},
+	PackageAPK: {
+		X386:     "x86",
+		Amd64:    "x86_64",
+		Arm:      "armv7",
+		Arm64:    "aarch64",
+		Ppc64LE:  "ppc64le",
+		Mips:     "mips",
+		MipsLE:   "mipsel",
+		Mips64:   "mips64",
+		Mips64LE: "mips64el",
+	},
+}
+
+// PackageArch returns the name of the architecture used by
+// the package format.
+func PackageArch(format PackageFormat, arch Arch) (string, error) {
+	archs, ok := packageArchs[format]
+	if !ok {
+		return "", fmt.Errorf("invalid package format \"%s\"", format)
+	}
+	name, ok := archs[arch]
+	if !ok {
+		return "", fmt.Errorf("architecture %s is not supported by %s packages", arch, format)
+	}
+	return name, nil
+}
+
+// PackageFile is a file installed by a package.
+type PackageFile struct {
+	// Source is the path of the local file.
+	Source string
+	// Destination is the absolute path of the installed file.
+	Destination string
+	// Mode contains the permission bits of the installed file.
+	// Defaults to the ones of the local file.
+	Mode os.FileMode
+	// Config marks the file as configuration file that will
+	// not be overwritten if it was modified by the user.
+	Config bool
+}
+
+// PackageTarget creates a Linux package containing the output
+// of a BuildTarget. The BuildTarget is executed as a dependency.
+type PackageTarget struct {
+	Format PackageFormat
+	// Binary is the Linux BuildTarget whose output is packaged.
+	Binary *BuildTarget
+	// BinaryDestination is the absolute path the binary is
+	// installed to. Defaults to "/usr/bin/<PackageName>".
+	BinaryDestination string
+
+	PackageName string
+	// Version defaults to the Version of the Binary.
+	Version Version
+	// Release is the release number of the package. Defaults to "1".
+	Release    string
+	Maintainer string
+	// Description is required. Its first line is used as
+	// the summary, the remaining lines as the long description.
+	Description string
+	Homepage    string
+	License     string
+	// Depends contains the names of required packages, optionally
+	// followed by a version constraint, e. g. "libc6 >= 2.17".
+	Depends []string
+
+	// Files contains additional files, including config files.
+	Files []PackageFile
+	// SystemdUnits contains paths of systemd unit files to install.
+	SystemdUnits []string
+
+	// Paths of shell scripts run before and after installation
+	// and removal.
+	PreInstallScript  string
+	PostInstallScript string
+	PreRemoveScript   string
+	PostRemoveScript  string
+
+	// OutputDir is the directory the package is written to.
+	// If empty it defaults to the output directory of the Suite.
+	OutputDir string
+
+	// defaultOutputDir is the output directory of the Suite
+	// the target is registered with.
+	defaultOutputDir string
+}
+
+// MultiFormat returns one PackageTarget based on the
+// current package target for each format.
+func (t *PackageTarget) MultiFormat(formats ...PackageFormat) []*PackageTarget {
+	newTargets := make([]*PackageTarget, len(formats))
+	for i, format := range formats {
+		copy := *t
+		copy.Format = format
+		newTargets[i] = &copy
+	}
+	return newTargets
+}
+
+// Execute builds the binary and creates the package.
+func (t *PackageTarget) Execute(suite *Suite) error {
+	if err := suite.executeDependencies([]Target{t.Binary}); err != nil {
+		return err
+	}
+
+	filename := t.OutputName()
+	info, err := t.info()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Creating package:", filename)
+	err = writeFileAtomically(filename, 0644, func(w io.Writer) error {
+		switch t.Format {
+		case PackageDeb:
+			return writeDeb(w, info)
+		case PackageRPM:
+			return writeRPM(w, info)
+		case PackageAPK:
+			return writeAPK(w, info)
+		}
+		return fmt.Errorf("invalid package format \"%s\"", t.Format)
+	})
+	if err != nil {
+		return fmt.Errorf("could not create %s package: %v", t.Format, err)
+	}
+
+	suite.AddArtifact(&Artifact{
+		Path:     filename,
+		Kind:     ArtifactPackage,
+		Platform: t.Binary.Platform,
+		Target:   t.Name(),
+	})
+	return nil
+}
+
+// packageInfo contains everything needed to write a package.
+type packageInfo struct {
+	format      PackageFormat
+	name        string
+	version     string
+	release     string
+	arch        string
+	maintainer  string
+	summary     string
+	description string
+	homepage    string
+	license     string
+	depends     []*packageDependency
+	entries     []*packageEntry
+	scripts     map[string]string
+	mtime       time.Time
+}
+
+// installedSize returns the sum of the sizes of all files.
+func (i *packageInfo) installedSize() int64 {
+	size := int64(0)
+	for _, e := range i.entries {
+		size += e.size
+	}
+	return size
+}
+
+// directories returns all parent directories of the entries.
+func (i *packageInfo) directories() []string {
+	dirs := make(map[string]bool)
+	for _, e := range i.entries {
+		for dir := path.Dir(e.path); dir != "/"; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	ret := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		ret = append(ret, dir)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// packageEntry is a file in a package.
+type packageEntry struct {
+	path   string
+	source string
+	mode   os.FileMode
+	size   int64
+	config bool
+}
+
+// packageDependency is a parsed dependency like "libc6 >= 2.17".
+type packageDependency struct {
+	name     string
+	operator string
+	version  string
+}
+
+func parsePackageDependency(text string) (*packageDependency, error) {
+	fields := strings.Fields(text)
+	switch len(fields) {
+	case 1:
+		return &packageDependency{name: fields[0]}, nil
+	case 3:
+		switch fields[1] {
+		case "<", "<=", "=", ">=", ">":
+			return &packageDependency{name: fields[0], operator: fields[1], version: fields[2]}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid dependency \"%s\"", text)
+}
+
+func (t *PackageTarget) info() (*packageInfo, error) {
+	arch, err := PackageArch(t.Format, t.Binary.Platform.Arch)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &packageInfo{
+		format:     t.Format,
+		name:       t.PackageName,
+		version:    t.packageVersion(),
+		release:    t.release(),
+		arch:       arch,
+		maintainer: t.Maintainer,
+		homepage:   t.Homepage,
+		license:    t.License,
+		scripts:    make(map[string]string),
+		mtime:      buildDate(),
+	}
+
+	lines := strings.SplitN(strings.TrimSpace(t.Description), "\n", 2)
+	info.summary = lines[0]
+	if len(lines) > 1 {
+		info.description = strings.TrimSpace(lines[1])
+	}
+
+	for _, dep := range t.Depends {
+		parsed, err := parsePackageDependency(dep)
+		if err != nil {
+			return nil, err
+		}
+		info.depends = append(info.depends, parsed)
+	}
+
+	binary, err := t.Binary.OutputPath()
+	if err != nil {
+		return nil, err
+	}
+	files := []PackageFile{{Source: binary, Destination: t.binaryDestination(), Mode: 0755}}
+	files = append(files, t.Files...)
+	unitDir := "/usr/lib/systemd/system"
+	if t.Format == PackageDeb {
+		unitDir = "/lib/systemd/system"
+	}
+	for _, unit := range t.SystemdUnits {
+		files = append(files, PackageFile{Source: unit, Destination: path.Join(unitDir, filepath.Base(unit)), Mode: 0644})
+	}
+
+	for _, file := range files {
+		stat, err := os.Stat(file.Source)
+		if err != nil {
+			return nil, err
+		}
+		if !path.IsAbs(file.Destination) {
+			return nil, fmt.Errorf("destination %s of %s is not absolute", file.Destination, file.Source)
+		}
+		mode := file.Mode
+		if mode == 0 {
+			mode = stat.Mode().Perm()
+		}
+		info.entries = append(info.entries, &packageEntry{
+			path:   path.Clean(file.Destination),
+			source: file.Source,
+			mode:   mode,
+			size:   stat.Size(),
+			config: file.Config,
+		})
+	}
+
+	scripts := map[string]string{
+		"preinst":  t.PreInstallScript,
+		"postinst": t.PostInstallScript,
+		"prerm":    t.PreRemoveScript,
+		"postrm":   t.PostRemoveScript,
+	}
+	for name, filename := range scripts {
+		if filename == "" {
+			continue
+		}
+		script, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		info.scripts[name] = string(script)
+	}
+
+	return info, nil
+}
+
+func (t *PackageTarget) binaryDestination() string {
+	if t.BinaryDestination != "" {
+		return t.BinaryDestination
+	}
+	return "/usr/bin/" + t.PackageName
+}
+
+func (t *PackageTarget) version() Version {
+	if t.Version != nil {
+		return t.Version
+	}
+	return t.Binary.Version
+}
+
+var (
+	// gitDescribeSuffix matches the commits since the last tag
+	// appended by git describe, e. g. "-4-gabc1234".
+	gitDescribeSuffix = regexp.MustCompile(`-(\d+)-g([0-9a-f]+)(-dirty)?$`)
+	semverPattern     = regexp.MustCompile(`^(\d+(?:\.\d+)*)(?:-([0-9A-Za-z.-]+))?(?:\+([0-9A-Za-z.-]+))?$`)
+	apkPreRelease     = regexp.MustCompile(`^([a-zA-Z]*)[.-]?(\d*)`)
+)
+
+// packageVersion returns the version in the form accepted by the
+// package format. Semantic version pre-releases are separated by
+// "~" for deb and rpm and become a "_alpha", "_beta", "_pre" or
+// "_rc" suffix for apk, so they sort before the release. Commits
+// since a tag as described by git are appended as "+<n>.g<hash>"
+// for deb and rpm and as "_p<n>" for apk, so they sort after it.
+func (t *PackageTarget) packageVersion() string {
+	v := t.version()
+	if v == nil {
+		return "0.0.0"
+	}
+	text := strings.TrimPrefix(v.String(), "v")
+
+	commits, hash := "", ""
+	if m := gitDescribeSuffix.FindStringSubmatch(text); m != nil {
+		commits, hash = m[1], m[2]
+		text = strings.TrimSuffix(text, m[0])
+	}
+
+	m := semverPattern.FindStringSubmatch(text)
+	if m == nil {
+		// Not a semantic version, e. g. a commit hash.
+		return strings.Replace(strings.TrimPrefix(v.String(), "v"), "-", ".", -1)
+	}
+	release, preRelease, build := m[1], m[2], m[3]
+
+	if t.Format == PackageAPK {
+		if preRelease != "" {
+			parts := apkPreRelease.FindStringSubmatch(preRelease)
+			label := strings.ToLower(parts[1])
+			switch label {
+			case "alpha", "beta", "pre", "rc":
+			default:
+				label = "pre"
+			}
+			release += "_" + label + parts[2]
+		}
+		if commits != "" {
+			release += "_p" + commits
+		}
+		return release
+	}
+
+	if preRelease != "" {
+		release += "~" + strings.Replace(preRelease, "-", ".", -1)
+	}
+	if build != "" {
+		release += "+" + build
+	}
+	if commits != "" {
+		release += "+" + commits + ".g" + hash
+	}
+	return release
+}
+
+func (t *PackageTarget) release() string {
+	if t.Release == "" {
+		return "1"
+	}
+	return t.Release
+}
+
+// OutputName returns the name of the package file including
+// the OutputDir, following the naming conventions of the format.
+func (t *PackageTarget) OutputName() string {
+	if t.Binary == nil || t.Binary.Platform == nil {
+		return ""
+	}
+	arch, _ := PackageArch(t.Format, t.Binary.Platform.Arch)
+
+	var name string
+	switch t.Format {
+	case PackageDeb:
+		name = fmt.Sprintf("%s_%s-%s_%s.deb", t.PackageName, t.packageVersion(), t.release(), arch)
+	case PackageRPM:
+		name = fmt.Sprintf("%s-%s-%s.%s.rpm", t.PackageName, t.packageVersion(), t.release(), arch)
+	case PackageAPK:
+		name = fmt.Sprintf("%s-%s-r%s_%s.apk", t.PackageName, t.packageVersion(), t.release(), arch)
+	}
+	return filepath.Join(t.outputDir(), name)
+}
+
+// Validate returns an error if the package can not be
+// created for the Binary.
+func (t *PackageTarget) Validate() error {
+	if t.PackageName == "" {
+		return fmt.Errorf("package has no name")
+	}
+	if t.Binary == nil || t.Binary.Platform == nil {
+		return fmt.Errorf("package %s has no binary", t.PackageName)
+	}
+	if t.Binary.Platform.OS != Linux {
+		return fmt.Errorf("package %s can only contain a linux binary", t.PackageName)
+	}
+	if strings.TrimSpace(t.Description) == "" {
+		return fmt.Errorf("package %s has no description", t.PackageName)
+	}
+	for _, dep := range t.Depends {
+		if _, err := parsePackageDependency(dep); err != nil {
+			return err
+		}
+	}
+	_, err := PackageArch(t.Format, t.Binary.Platform.Arch)
+	return err
+}
+
+func (t *PackageTarget) setDefaultOutputDir(suite *Suite) error {
+	var err error
+	t.defaultOutputDir, err = suite.OutputDir(t.Binary.Platform, t.version())
+	return err
+}
+
+// outputDir returns the OutputDir or, if it is empty, the
+// output directory of the Suite.
+func (t *PackageTarget) outputDir() string {
+	if t.OutputDir != "" {
+		return t.OutputDir
+	}
+	return t.defaultOutputDir
+}
+
+// TargetPlatform returns the Platform of the Binary.
+func (t *PackageTarget) TargetPlatform() *Platform {
+	return t.Binary.Platform
+}
+
+// Name returns the name of this Target.
+// The name will consist of the PackageTargetNamePrefix followed
+// by the PackageName, the format and the Platform name.
+func (t *PackageTarget) Name() string {
+	return PackageTargetNamePrefix + t.PackageName + "_" + string(t.Format) + "_" + t.Binary.Platform.String()
+}
@@ -0,0 +1,124 @@
+package make
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// apkScripts maps the script names to the ones used by apk.
+var apkScripts = map[string]string{
+	"preinst":  ".pre-install",
+	"postinst": ".post-install",
+	"prerm":    ".pre-deinstall",
+	"postrm":   ".post-deinstall",
+}
+
+// writeAPK writes an unsigned Alpine package, which consists of
+// the concatenated gzip streams of the control and the data
+// tarballs.
+func writeAPK(w io.Writer, info *packageInfo) error {
+	data, err := apkData(info)
+	if err != nil {
+		return err
+	}
+	control, err := apkControl(info, data)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(control); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func apkData(info *packageInfo) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+
+	for _, dir := range info.directories() {
+		if err := writeTarDir(tw, dir[1:]+"/", info.mtime); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range info.entries {
+		hash := sha1.New()
+		if err := copyFile(hash, e.source); err != nil {
+			return nil, err
+		}
+		records := map[string]string{
+			"APK-TOOLS.checksum.SHA1": hex.EncodeToString(hash.Sum(nil)),
+		}
+		if err := writeTarFileWithRecords(tw, e.path[1:], e, info.mtime, ioutil.Discard, records); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// apkControl returns the gzipped control tarball. It is not
+// terminated, as apk expects the tarballs to be concatenated.
+func apkControl(info *packageInfo, data []byte) ([]byte, error) {
+	dataHash := sha256.Sum256(data)
+
+	pkginfo := &strings.Builder{}
+	fmt.Fprintf(pkginfo, "pkgname = %s\n", info.name)
+	fmt.Fprintf(pkginfo, "pkgver = %s-r%s\n", info.version, info.release)
+	fmt.Fprintf(pkginfo, "pkgdesc = %s\n", info.summary)
+	if info.homepage != "" {
+		fmt.Fprintf(pkginfo, "url = %s\n", info.homepage)
+	}
+	fmt.Fprintf(pkginfo, "builddate = %d\n", info.mtime.Unix())
+	if info.maintainer != "" {
+		fmt.Fprintf(pkginfo, "packager = %s\n", info.maintainer)
+		fmt.Fprintf(pkginfo, "maintainer = %s\n", info.maintainer)
+	}
+	fmt.Fprintf(pkginfo, "size = %d\n", info.installedSize())
+	fmt.Fprintf(pkginfo, "arch = %s\n", info.arch)
+	fmt.Fprintf(pkginfo, "origin = %s\n", info.name)
+	if info.license != "" {
+		fmt.Fprintf(pkginfo, "license = %s\n", info.license)
+	}
+	for _, dep := range info.depends {
+		fmt.Fprintf(pkginfo, "depend = %s%s%s\n", dep.name, dep.operator, dep.version)
+	}
+	fmt.Fprintf(pkginfo, "datahash = %s\n", hex.EncodeToString(dataHash[:]))
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	if err := writeTarContent(tw, ".PKGINFO", 0644, pkginfo.String(), info.mtime); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"preinst", "postinst", "prerm", "postrm"} {
+		if script, ok := info.scripts[name]; ok {
+			if err := writeTarContent(tw, apkScripts[name], 0755, script, info.mtime); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,62 @@
+package make
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestWriteAPK(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := writeAPK(buf, testPackageInfo(t, PackageAPK)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Split the concatenated gzip streams.
+	r := bytes.NewReader(buf.Bytes())
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz.Multistream(false)
+	control, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()[buf.Len()-r.Len():]
+
+	_, controlFiles := readTar(t, bytes.NewReader(control))
+	dataHash := sha256.Sum256(data)
+	pkginfo := controlFiles[".PKGINFO"]
+	for _, line := range []string{
+		"pkgname = app",
+		"pkgver = 1.2.3-r1",
+		"pkgdesc = An app",
+		"builddate = 1234567890",
+		"arch = amd64",
+		"depend = libc6>=2.17",
+		"depend = ca-certificates",
+		"datahash = " + hex.EncodeToString(dataHash[:]),
+	} {
+		if !strings.Contains(pkginfo, line+"\n") {
+			t.Errorf("expected %q in .PKGINFO, got\n%s", line, pkginfo)
+		}
+	}
+	if !strings.Contains(controlFiles[".post-install"], "echo installed") {
+		t.Errorf("missing the post-install script, got %v", controlFiles)
+	}
+
+	headers, files := readTar(t, gunzip(t, data))
+	binarySHA1 := sha1.Sum([]byte("binary"))
+	if header := headers["usr/bin/app"]; header == nil || header.PAXRecords["APK-TOOLS.checksum.SHA1"] != hex.EncodeToString(binarySHA1[:]) {
+		t.Errorf("unexpected binary entry %+v", header)
+	}
+	if files["etc/app/app.conf"] != "key = value\n" || headers["etc/app/"] == nil {
+		t.Errorf("unexpected data %v", files)
+	}
+}
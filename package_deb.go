@@ -0,0 +1,234 @@
+package make
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// writeDeb writes a Debian package, which is an ar archive
+// containing the format version, the control and the data tarballs.
+func writeDeb(w io.Writer, info *packageInfo) error {
+	data, md5sums, err := debData(info)
+	if err != nil {
+		return err
+	}
+	control, err := debControl(info, md5sums)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, "!<arch>\n"); err != nil {
+		return err
+	}
+	members := []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", control},
+		{"data.tar.gz", data},
+	}
+	for _, member := range members {
+		if err := writeArMember(w, member.name, member.data, info.mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeArMember(w io.Writer, name string, data []byte, mtime time.Time) error {
+	header := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8s%-10d`\n", name, mtime.Unix(), 0, 0, "100644", len(data))
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if len(data)%2 != 0 {
+		_, err := w.Write([]byte{'\n'})
+		return err
+	}
+	return nil
+}
+
+// debData returns the gzipped data tarball and the md5sums
+// file of all contained files.
+func debData(info *packageInfo) ([]byte, string, error) {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+
+	for _, dir := range info.directories() {
+		if err := writeTarDir(tw, "."+dir+"/", info.mtime); err != nil {
+			return nil, "", err
+		}
+	}
+
+	md5sums := &strings.Builder{}
+	for _, e := range info.entries {
+		hash := md5.New()
+		err := writeTarFile(tw, "."+e.path, e, info.mtime, hash)
+		if err != nil {
+			return nil, "", err
+		}
+		fmt.Fprintf(md5sums, "%s  %s\n", hex.EncodeToString(hash.Sum(nil)), strings.TrimPrefix(e.path, "/"))
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), md5sums.String(), nil
+}
+
+func debControl(info *packageInfo, md5sums string) ([]byte, error) {
+	control := &strings.Builder{}
+	fmt.Fprintf(control, "Package: %s\n", info.name)
+	fmt.Fprintf(control, "Version: %s-%s\n", info.version, info.release)
+	fmt.Fprintf(control, "Architecture: %s\n", info.arch)
+	if info.maintainer != "" {
+		fmt.Fprintf(control, "Maintainer: %s\n", info.maintainer)
+	}
+	fmt.Fprintf(control, "Installed-Size: %d\n", (info.installedSize()+1023)/1024)
+	if len(info.depends) > 0 {
+		depends := make([]string, len(info.depends))
+		for i, dep := range info.depends {
+			depends[i] = dep.name
+			if dep.operator != "" {
+				operator := dep.operator
+				if operator == "<" || operator == ">" {
+					operator += operator
+				}
+				depends[i] += fmt.Sprintf(" (%s %s)", operator, dep.version)
+			}
+		}
+		fmt.Fprintf(control, "Depends: %s\n", strings.Join(depends, ", "))
+	}
+	if info.homepage != "" {
+		fmt.Fprintf(control, "Homepage: %s\n", info.homepage)
+	}
+	fmt.Fprintf(control, "Description: %s\n", info.summary)
+	for _, line := range strings.Split(info.description, "\n") {
+		if info.description == "" {
+			break
+		}
+		if strings.TrimSpace(line) == "" {
+			line = "."
+		}
+		fmt.Fprintf(control, " %s\n", line)
+	}
+
+	files := map[string]string{
+		"control": control.String(),
+		"md5sums": md5sums,
+	}
+	conffiles := &strings.Builder{}
+	for _, e := range info.entries {
+		if e.config {
+			fmt.Fprintln(conffiles, e.path)
+		}
+	}
+	if conffiles.Len() > 0 {
+		files["conffiles"] = conffiles.String()
+	}
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range []string{"control", "md5sums", "conffiles"} {
+		if content, ok := files[name]; ok {
+			if err := writeTarContent(tw, "./"+name, 0644, content, info.mtime); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, name := range []string{"preinst", "postinst", "prerm", "postrm"} {
+		if script, ok := info.scripts[name]; ok {
+			if err := writeTarContent(tw, "./"+name, 0755, script, info.mtime); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeTarDir(tw *tar.Writer, name string, mtime time.Time) error {
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name,
+		Mode:     0755,
+		ModTime:  mtime,
+		Uname:    "root",
+		Gname:    "root",
+	})
+}
+
+func writeTarContent(tw *tar.Writer, name string, mode int64, content string, mtime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     mode,
+		Size:     int64(len(content)),
+		ModTime:  mtime,
+		Uname:    "root",
+		Gname:    "root",
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(tw, content)
+	return err
+}
+
+// writeTarFile writes the entry to the tarball. The content is
+// also written to hash.
+func writeTarFile(tw *tar.Writer, name string, e *packageEntry, mtime time.Time, hash io.Writer) error {
+	return writeTarFileWithRecords(tw, name, e, mtime, hash, nil)
+}
+
+func writeTarFileWithRecords(tw *tar.Writer, name string, e *packageEntry, mtime time.Time, hash io.Writer, records map[string]string) error {
+	header := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       name,
+		Mode:       int64(e.mode),
+		Size:       e.size,
+		ModTime:    mtime,
+		Uname:      "root",
+		Gname:      "root",
+		PAXRecords: records,
+	}
+	if len(records) > 0 {
+		header.Format = tar.FormatPAX
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	f, err := os.Open(e.source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(io.MultiWriter(tw, hash), f)
+	if err == nil && n != e.size {
+		err = fmt.Errorf("%s changed while packaging", e.source)
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package make
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readAr returns the members of an ar archive in order.
+func readAr(t *testing.T, data []byte) ([]string, map[string][]byte) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("!<arch>\n")) {
+		t.Fatalf("missing ar magic")
+	}
+	data = data[8:]
+	names := make([]string, 0)
+	members := make(map[string][]byte)
+	for len(data) > 0 {
+		if len(data) < 60 || string(data[58:60]) != "`\n" {
+			t.Fatalf("invalid ar header %q", data)
+		}
+		name := strings.TrimSpace(string(data[:16]))
+		size, err := strconv.Atoi(strings.TrimSpace(string(data[48:58])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+		members[name] = data[60 : 60+size]
+		data = data[60+size+size%2:]
+	}
+	return names, members
+}
+
+// gunzip decompresses a single gzip stream.
+func gunzip(t *testing.T, data []byte) *gzip.Reader {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz.Multistream(false)
+	return gz
+}
+
+func TestWriteDeb(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := writeDeb(buf, testPackageInfo(t, PackageDeb)); err != nil {
+		t.Fatal(err)
+	}
+	names, members := readAr(t, buf.Bytes())
+	if strings.Join(names, " ") != "debian-binary control.tar.gz data.tar.gz" {
+		t.Fatalf("unexpected members %v", names)
+	}
+	if string(members["debian-binary"]) != "2.0\n" {
+		t.Errorf("unexpected format version %q", members["debian-binary"])
+	}
+
+	_, control := readTar(t, gunzip(t, members["control.tar.gz"]))
+	tests := []struct {
+		file     string
+		contains string
+	}{
+		{"./control", "Package: app\nVersion: 1.2.3-1\nArchitecture: amd64\n"},
+		{"./control", "Installed-Size: 1\n"},
+		{"./control", "Depends: libc6 (>= 2.17), ca-certificates\n"},
+		{"./control", "Description: An app\n It does things.\n .\n Really.\n"},
+		{"./md5sums", "  usr/bin/app\n"},
+		{"./conffiles", "/etc/app/app.conf\n"},
+		{"./postinst", "echo installed"},
+	}
+	for _, test := range tests {
+		if !strings.Contains(control[test.file], test.contains) {
+			t.Errorf("expected %q in %s, got\n%s", test.contains, test.file, control[test.file])
+		}
+	}
+
+	headers, data := readTar(t, gunzip(t, members["data.tar.gz"]))
+	for _, dir := range []string{"./etc/", "./etc/app/", "./usr/", "./usr/bin/"} {
+		if headers[dir] == nil {
+			t.Errorf("missing directory %s", dir)
+		}
+	}
+	if header := headers["./usr/bin/app"]; header == nil || header.Mode != 0755 || data["./usr/bin/app"] != "binary" {
+		t.Errorf("unexpected binary entry %+v", header)
+	}
+	if header := headers["./etc/app/app.conf"]; header == nil || header.ModTime.Unix() != 1234567890 || header.Uname != "root" {
+		t.Errorf("unexpected config entry %+v", header)
+	}
+}
@@ -0,0 +1,409 @@
+package make
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// Types of the values in an RPM header.
+const (
+	rpmInt16       = 3
+	rpmInt32       = 4
+	rpmString      = 6
+	rpmBin         = 7
+	rpmStringArray = 8
+	rpmI18NString  = 9
+)
+
+// Region tags of the signature and the main header.
+const (
+	rpmTagHeaderSignatures = 62
+	rpmTagHeaderImmutable  = 63
+)
+
+// Dependency flags.
+const (
+	rpmSenseLess    = 1 << 1
+	rpmSenseGreater = 1 << 2
+	rpmSenseEqual   = 1 << 3
+	rpmSenseRPMLib  = 1 << 24
+)
+
+const (
+	rpmFileConfig    = 1 << 0
+	rpmFileNoReplace = 1 << 4
+
+	rpmDigestSHA256 = 8
+)
+
+var rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0}
+
+// writeRPM writes an RPM package consisting of the lead, the
+// signature header, the main header and the gzipped cpio payload.
+func writeRPM(w io.Writer, info *packageInfo) error {
+	entries := make([]*packageEntry, len(info.entries))
+	copy(entries, info.entries)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].path < entries[j].path
+	})
+
+	payload := &bytes.Buffer{}
+	digests, payloadSize, err := rpmPayload(payload, entries, info.mtime)
+	if err != nil {
+		return err
+	}
+
+	header := rpmMainHeader(info, entries, digests).bytes(rpmTagHeaderImmutable)
+
+	headerSHA1 := sha1.Sum(header)
+	headerSHA256 := sha256.Sum256(header)
+	md5sum := md5.New()
+	md5sum.Write(header)
+	md5sum.Write(payload.Bytes())
+
+	sig := &rpmHeader{}
+	sig.addInt32(1000, uint32(len(header)+payload.Len()))
+	sig.addBin(1004, md5sum.Sum(nil))
+	sig.addInt32(1007, uint32(payloadSize))
+	sig.addString(269, hex.EncodeToString(headerSHA1[:]))
+	sig.addString(273, hex.EncodeToString(headerSHA256[:]))
+	signature := sig.bytes(rpmTagHeaderSignatures)
+	if padding := len(signature) % 8; padding != 0 {
+		signature = append(signature, make([]byte, 8-padding)...)
+	}
+
+	for _, part := range [][]byte{rpmLead(info), signature, header, payload.Bytes()} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rpmLead(info *packageInfo) []byte {
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+	name := fmt.Sprintf("%s-%s-%s", info.name, info.version, info.release)
+	if len(name) > 65 {
+		name = name[:65]
+	}
+	copy(lead[10:76], name)
+	// The operating system is Linux and the signature type is
+	// a header signature.
+	binary.BigEndian.PutUint16(lead[76:], 1)
+	binary.BigEndian.PutUint16(lead[78:], 5)
+	return lead
+}
+
+func rpmMainHeader(info *packageInfo, entries []*packageEntry, digests []string) *rpmHeader {
+	h := &rpmHeader{}
+	h.addStringArray(100, []string{"C"})
+	h.addString(1000, info.name)
+	h.addString(1001, info.version)
+	h.addString(1002, info.release)
+	h.addI18NString(1004, info.summary)
+	description := info.description
+	if description == "" {
+		description = info.summary
+	}
+	h.addI18NString(1005, description)
+	h.addInt32(1006, uint32(info.mtime.Unix()))
+	h.addString(1007, "localhost")
+	h.addInt32(1009, uint32(info.installedSize()))
+	license := info.license
+	if license == "" {
+		license = "unknown"
+	}
+	h.addString(1014, license)
+	if info.maintainer != "" {
+		h.addString(1015, info.maintainer)
+	}
+	h.addI18NString(1016, "Unspecified")
+	if info.homepage != "" {
+		h.addString(1020, info.homepage)
+	}
+	h.addString(1021, "linux")
+	h.addString(1022, info.arch)
+	h.addString(1044, fmt.Sprintf("%s-%s-%s.src.rpm", info.name, info.version, info.release))
+
+	scripts := []struct {
+		name         string
+		tag, progTag uint32
+	}{
+		{"preinst", 1023, 1085},
+		{"postinst", 1024, 1086},
+		{"prerm", 1025, 1087},
+		{"postrm", 1026, 1088},
+	}
+	for _, script := range scripts {
+		if content, ok := info.scripts[script.name]; ok {
+			h.addString(script.tag, content)
+			h.addString(script.progTag, "/bin/sh")
+		}
+	}
+
+	var (
+		sizes, mtimes, flags, devices, inodes, dirIndexes []uint32
+		modes, rdevs                                      []uint16
+		linkTos, users, groups, langs, baseNames, dirs    []string
+	)
+	dirIndex := make(map[string]uint32)
+	for i, e := range entries {
+		sizes = append(sizes, uint32(e.size))
+		mtimes = append(mtimes, uint32(info.mtime.Unix()))
+		modes = append(modes, uint16(0100000|e.mode.Perm()))
+		rdevs = append(rdevs, 0)
+		var flag uint32
+		if e.config {
+			flag = rpmFileConfig | rpmFileNoReplace
+		}
+		flags = append(flags, flag)
+		devices = append(devices, 1)
+		inodes = append(inodes, uint32(i+1))
+		linkTos = append(linkTos, "")
+		users = append(users, "root")
+		groups = append(groups, "root")
+		langs = append(langs, "")
+
+		dir := path.Dir(e.path) + "/"
+		index, ok := dirIndex[dir]
+		if !ok {
+			index = uint32(len(dirs))
+			dirIndex[dir] = index
+			dirs = append(dirs, dir)
+		}
+		dirIndexes = append(dirIndexes, index)
+		baseNames = append(baseNames, path.Base(e.path))
+	}
+	h.addInt32(1028, sizes...)
+	h.addInt16(1030, modes...)
+	h.addInt16(1033, rdevs...)
+	h.addInt32(1034, mtimes...)
+	h.addStringArray(1035, digests)
+	h.addStringArray(1036, linkTos)
+	h.addInt32(1037, flags...)
+	h.addStringArray(1039, users)
+	h.addStringArray(1040, groups)
+	h.addInt32(1095, devices...)
+	h.addInt32(1096, inodes...)
+	h.addStringArray(1097, langs)
+	h.addInt32(1116, dirIndexes...)
+	h.addStringArray(1117, baseNames)
+	h.addStringArray(1118, dirs)
+	h.addInt32(5011, rpmDigestSHA256)
+
+	h.addStringArray(1047, []string{info.name})
+	h.addInt32(1112, rpmSenseEqual)
+	h.addStringArray(1113, []string{info.version + "-" + info.release})
+
+	requireNames := []string{
+		"rpmlib(CompressedFileNames)",
+		"rpmlib(FileDigests)",
+		"rpmlib(PayloadFilesHavePrefix)",
+	}
+	requireVersions := []string{"3.0.4-1", "4.6.0-1", "4.0-1"}
+	requireFlags := []uint32{
+		rpmSenseRPMLib | rpmSenseLess | rpmSenseEqual,
+		rpmSenseRPMLib | rpmSenseLess | rpmSenseEqual,
+		rpmSenseRPMLib | rpmSenseLess | rpmSenseEqual,
+	}
+	for _, dep := range info.depends {
+		requireNames = append(requireNames, dep.name)
+		requireVersions = append(requireVersions, dep.version)
+		requireFlags = append(requireFlags, rpmSenseFlags(dep.operator))
+	}
+	h.addInt32(1048, requireFlags...)
+	h.addStringArray(1049, requireNames)
+	h.addStringArray(1050, requireVersions)
+
+	h.addString(1124, "cpio")
+	h.addString(1125, "gzip")
+	h.addString(1126, "9")
+	return h
+}
+
+func rpmSenseFlags(operator string) uint32 {
+	switch operator {
+	case "<":
+		return rpmSenseLess
+	case "<=":
+		return rpmSenseLess | rpmSenseEqual
+	case "=":
+		return rpmSenseEqual
+	case ">=":
+		return rpmSenseGreater | rpmSenseEqual
+	case ">":
+		return rpmSenseGreater
+	}
+	return 0
+}
+
+// rpmPayload writes the gzipped cpio archive of the entries to w.
+// It returns the sha256 digests of the files and the size of the
+// uncompressed archive.
+func rpmPayload(w io.Writer, entries []*packageEntry, mtime time.Time) ([]string, int64, error) {
+	gz, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return nil, 0, err
+	}
+	cw := &countingWriter{w: gz}
+
+	digests := make([]string, len(entries))
+	for i, e := range entries {
+		err := writeCpioHeader(cw, "."+e.path, uint32(i+1), uint32(0100000|e.mode.Perm()), uint32(mtime.Unix()), e.size)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		f, err := os.Open(e.source)
+		if err != nil {
+			return nil, 0, err
+		}
+		hash := sha256.New()
+		n, err := io.Copy(io.MultiWriter(cw, hash), f)
+		f.Close()
+		if err != nil {
+			return nil, 0, err
+		}
+		if n != e.size {
+			return nil, 0, fmt.Errorf("%s changed while packaging", e.source)
+		}
+		if err := writeCpioPadding(cw); err != nil {
+			return nil, 0, err
+		}
+		digests[i] = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	if err := writeCpioHeader(cw, "TRAILER!!!", 0, 0, 0, 0); err != nil {
+		return nil, 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, 0, err
+	}
+	return digests, cw.n, nil
+}
+
+// writeCpioHeader writes a header in the "new ASCII" cpio format
+// followed by the padded name.
+func writeCpioHeader(cw *countingWriter, name string, inode, mode, mtime uint32, size int64) error {
+	_, err := fmt.Fprintf(cw, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%s\x00",
+		inode, mode, 0, 0, 1, mtime, size, 0, 0, 0, 0, len(name)+1, 0, name)
+	if err != nil {
+		return err
+	}
+	return writeCpioPadding(cw)
+}
+
+func writeCpioPadding(cw *countingWriter) error {
+	if padding := cw.n % 4; padding != 0 {
+		_, err := cw.Write(make([]byte, 4-padding))
+		return err
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// rpmHeader collects the tags of an RPM header.
+type rpmHeader struct {
+	entries []*rpmEntry
+}
+
+type rpmEntry struct {
+	tag   uint32
+	typ   uint32
+	count uint32
+	align int
+	data  []byte
+}
+
+func (h *rpmHeader) add(tag, typ uint32, count uint32, align int, data []byte) {
+	h.entries = append(h.entries, &rpmEntry{tag: tag, typ: typ, count: count, align: align, data: data})
+}
+
+func (h *rpmHeader) addString(tag uint32, value string) {
+	h.add(tag, rpmString, 1, 1, append([]byte(value), 0))
+}
+
+func (h *rpmHeader) addI18NString(tag uint32, value string) {
+	h.add(tag, rpmI18NString, 1, 1, append([]byte(value), 0))
+}
+
+func (h *rpmHeader) addStringArray(tag uint32, values []string) {
+	data := []byte{}
+	for _, value := range values {
+		data = append(append(data, value...), 0)
+	}
+	h.add(tag, rpmStringArray, uint32(len(values)), 1, data)
+}
+
+func (h *rpmHeader) addBin(tag uint32, value []byte) {
+	h.add(tag, rpmBin, uint32(len(value)), 1, value)
+}
+
+func (h *rpmHeader) addInt16(tag uint32, values ...uint16) {
+	data := make([]byte, 2*len(values))
+	for i, value := range values {
+		binary.BigEndian.PutUint16(data[2*i:], value)
+	}
+	h.add(tag, rpmInt16, uint32(len(values)), 2, data)
+}
+
+func (h *rpmHeader) addInt32(tag uint32, values ...uint32) {
+	data := make([]byte, 4*len(values))
+	for i, value := range values {
+		binary.BigEndian.PutUint32(data[4*i:], value)
+	}
+	h.add(tag, rpmInt32, uint32(len(values)), 4, data)
+}
+
+// bytes returns the encoded header. The entries are sorted by tag
+// and preceded by the region tag whose trailer is appended to the
+// data.
+func (h *rpmHeader) bytes(regionTag uint32) []byte {
+	sort.SliceStable(h.entries, func(i, j int) bool {
+		return h.entries[i].tag < h.entries[j].tag
+	})
+
+	index := &bytes.Buffer{}
+	data := &bytes.Buffer{}
+	for _, e := range h.entries {
+		if padding := data.Len() % e.align; padding != 0 {
+			data.Write(make([]byte, e.align-padding))
+		}
+		binary.Write(index, binary.BigEndian, []uint32{e.tag, e.typ, uint32(data.Len()), e.count})
+		data.Write(e.data)
+	}
+
+	count := len(h.entries) + 1
+	region := []uint32{regionTag, rpmBin, uint32(data.Len()), 16}
+	binary.Write(data, binary.BigEndian, []uint32{regionTag, rpmBin, uint32(int32(-16 * count)), 16})
+
+	buf := &bytes.Buffer{}
+	buf.Write(rpmHeaderMagic)
+	binary.Write(buf, binary.BigEndian, []uint32{uint32(count), uint32(data.Len())})
+	binary.Write(buf, binary.BigEndian, region)
+	buf.Write(index.Bytes())
+	buf.Write(data.Bytes())
+	return buf.Bytes()
+}
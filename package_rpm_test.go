@@ -0,0 +1,161 @@
+package make
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readRPMHeader parses the header at the start of data and
+// returns the raw values by tag and the header length.
+func readRPMHeader(t *testing.T, data []byte) (map[uint32][]byte, int) {
+	t.Helper()
+	if !bytes.HasPrefix(data, rpmHeaderMagic) {
+		t.Fatalf("missing header magic")
+	}
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	size := int(binary.BigEndian.Uint32(data[12:]))
+	index := data[16 : 16+16*count]
+	store := data[16+16*count : 16+16*count+size]
+
+	values := make(map[uint32][]byte)
+	for i := 0; i < count; i++ {
+		entry := index[16*i:]
+		tag := binary.BigEndian.Uint32(entry)
+		offset := binary.BigEndian.Uint32(entry[8:])
+		values[tag] = store[offset:]
+	}
+	return values, 16 + 16*count + size
+}
+
+// rpmStrings returns the first n null terminated strings.
+func rpmStrings(value []byte, n int) []string {
+	return strings.SplitN(string(value), "\x00", n+1)[:n]
+}
+
+// readCpio returns the contents of a "new ASCII" cpio archive
+// by name.
+func readCpio(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	align := func(n int) int { return (n + 3) &^ 3 }
+	for offset := 0; ; {
+		header := string(data[offset : offset+110])
+		if !strings.HasPrefix(header, "070701") {
+			t.Fatalf("invalid cpio header %q", header)
+		}
+		field := func(i int) int {
+			value, err := strconv.ParseUint(header[6+8*i:14+8*i], 16, 32)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return int(value)
+		}
+		size, nameSize := field(6), field(11)
+		name := string(data[offset+110 : offset+110+nameSize-1])
+		if name == "TRAILER!!!" {
+			return files
+		}
+		start := align(offset + 110 + nameSize)
+		files[name] = string(data[start : start+size])
+		offset = align(start + size)
+	}
+}
+
+func TestWriteRPM(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := writeRPM(buf, testPackageInfo(t, PackageRPM)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte{0xed, 0xab, 0xee, 0xdb}) || !bytes.HasPrefix(data[10:], []byte("app-1.2.3-1\x00")) {
+		t.Fatalf("invalid lead %q", data[:96])
+	}
+
+	signature, size := readRPMHeader(t, data[96:])
+	data = data[96+(size+7)&^7:]
+	header, size := readRPMHeader(t, data)
+	payload := data[size:]
+
+	if length := binary.BigEndian.Uint32(signature[1000]); int(length) != len(data) {
+		t.Errorf("expected the signature size %d, got %d", len(data), length)
+	}
+	headerSHA256 := sha256.Sum256(data[:size])
+	if digest := rpmStrings(signature[273], 1)[0]; digest != hex.EncodeToString(headerSHA256[:]) {
+		t.Errorf("invalid header digest %s", digest)
+	}
+
+	tests := []struct {
+		tag    uint32
+		values []string
+	}{
+		{1000, []string{"app"}},
+		{1001, []string{"1.2.3"}},
+		{1002, []string{"1"}},
+		{1004, []string{"An app"}},
+		{1005, []string{"It does things.\n\nReally."}},
+		{1014, []string{"MIT"}},
+		{1022, []string{"amd64"}},
+		{1049, []string{"rpmlib(CompressedFileNames)", "rpmlib(FileDigests)", "rpmlib(PayloadFilesHavePrefix)", "libc6", "ca-certificates"}},
+		{1050, []string{"3.0.4-1", "4.6.0-1", "4.0-1", "2.17", ""}},
+		{1117, []string{"app.conf", "app"}},
+		{1118, []string{"/etc/app/", "/usr/bin/"}},
+		{1085, nil},
+	}
+	for _, test := range tests {
+		value, ok := header[test.tag]
+		if test.values == nil {
+			if ok {
+				t.Errorf("unexpected tag %d", test.tag)
+			}
+			continue
+		}
+		if values := rpmStrings(value, len(test.values)); !reflect.DeepEqual(values, test.values) {
+			t.Errorf("tag %d: expected %q, got %q", test.tag, test.values, values)
+		}
+	}
+	if flags := header[1037]; binary.BigEndian.Uint32(flags) != rpmFileConfig|rpmFileNoReplace || binary.BigEndian.Uint32(flags[4:]) != 0 {
+		t.Errorf("expected only the config file to be flagged")
+	}
+	if modes := header[1030]; binary.BigEndian.Uint16(modes) != 0100644 || binary.BigEndian.Uint16(modes[2:]) != 0100755 {
+		t.Errorf("unexpected file modes %x", modes[:4])
+	}
+
+	gz := gunzip(t, payload)
+	archive, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payloadSize := binary.BigEndian.Uint32(signature[1007]); int(payloadSize) != len(archive) {
+		t.Errorf("expected the payload size %d, got %d", len(archive), payloadSize)
+	}
+	expected := map[string]string{"./etc/app/app.conf": "key = value\n", "./usr/bin/app": "binary"}
+	if files := readCpio(t, archive); !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected payload %q, got %q", expected, files)
+	}
+}
+
+func TestRPMSenseFlags(t *testing.T) {
+	tests := []struct {
+		operator string
+		flags    uint32
+	}{
+		{"", 0},
+		{"<", rpmSenseLess},
+		{"<=", rpmSenseLess | rpmSenseEqual},
+		{"=", rpmSenseEqual},
+		{">=", rpmSenseGreater | rpmSenseEqual},
+		{">", rpmSenseGreater},
+	}
+	for _, test := range tests {
+		if flags := rpmSenseFlags(test.operator); flags != test.flags {
+			t.Errorf("%q: expected %#x, got %#x", test.operator, test.flags, flags)
+		}
+	}
+}
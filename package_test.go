@@ -0,0 +1,193 @@
+package make
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testPackageInfo returns a packageInfo with a binary
+// and a config file.
+func testPackageInfo(t *testing.T, format PackageFormat) *packageInfo {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"app":        "binary",
+		"app.conf":   "key = value\n",
+		"postinst":   "#!/bin/sh\necho installed\n",
+		"unused.txt": "",
+	})
+	return &packageInfo{
+		format:      format,
+		name:        "app",
+		version:     "1.2.3",
+		release:     "1",
+		arch:        "amd64",
+		maintainer:  "Jane Doe <jane@example.com>",
+		summary:     "An app",
+		description: "It does things.\n\nReally.",
+		license:     "MIT",
+		depends:     []*packageDependency{{name: "libc6", operator: ">=", version: "2.17"}, {name: "ca-certificates"}},
+		entries: []*packageEntry{
+			{path: "/usr/bin/app", source: filepath.Join(dir, "app"), mode: 0755, size: 6},
+			{path: "/etc/app/app.conf", source: filepath.Join(dir, "app.conf"), mode: 0644, size: 12, config: true},
+		},
+		scripts: map[string]string{"postinst": "#!/bin/sh\necho installed\n"},
+		mtime:   time.Unix(1234567890, 0),
+	}
+}
+
+// readTar returns the headers and contents of all entries
+// of the tarball by name.
+func readTar(t *testing.T, r io.Reader) (map[string]*tar.Header, map[string]string) {
+	t.Helper()
+	headers := make(map[string]*tar.Header)
+	contents := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return headers, contents
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers[header.Name] = header
+		contents[header.Name] = string(content)
+	}
+}
+
+func TestPackageVersion(t *testing.T) {
+	tests := []struct {
+		version Version
+		deb     string
+		rpm     string
+		apk     string
+	}{
+		{version: nil, deb: "0.0.0", rpm: "0.0.0", apk: "0.0.0"},
+		{version: BasicVersion("1.2.3"), deb: "1.2.3", rpm: "1.2.3", apk: "1.2.3"},
+		{version: BasicVersion("v1.2.3"), deb: "1.2.3", rpm: "1.2.3", apk: "1.2.3"},
+		{version: BasicVersion("1.2.3-rc.1"), deb: "1.2.3~rc.1", rpm: "1.2.3~rc.1", apk: "1.2.3_rc1"},
+		{version: BasicVersion("1.2.3-beta2"), deb: "1.2.3~beta2", rpm: "1.2.3~beta2", apk: "1.2.3_beta2"},
+		{version: BasicVersion("1.2.3-alpha"), deb: "1.2.3~alpha", rpm: "1.2.3~alpha", apk: "1.2.3_alpha"},
+		{version: BasicVersion("1.2.3-nightly.5"), deb: "1.2.3~nightly.5", rpm: "1.2.3~nightly.5", apk: "1.2.3_pre5"},
+		{version: BasicVersion("1.2.3+build.7"), deb: "1.2.3+build.7", rpm: "1.2.3+build.7", apk: "1.2.3"},
+		{version: BasicVersion("v1.2.3-4-gabc1234"), deb: "1.2.3+4.gabc1234", rpm: "1.2.3+4.gabc1234", apk: "1.2.3_p4"},
+		{version: BasicVersion("1.2.3-4-gabc1234-dirty"), deb: "1.2.3+4.gabc1234", rpm: "1.2.3+4.gabc1234", apk: "1.2.3_p4"},
+		{version: BasicVersion("1.2.3-rc.1-4-gabc1234"), deb: "1.2.3~rc.1+4.gabc1234", rpm: "1.2.3~rc.1+4.gabc1234", apk: "1.2.3_rc1_p4"},
+		{version: BasicVersion("abc1234"), deb: "abc1234", rpm: "abc1234", apk: "abc1234"},
+	}
+	for _, test := range tests {
+		for format, expected := range map[PackageFormat]string{PackageDeb: test.deb, PackageRPM: test.rpm, PackageAPK: test.apk} {
+			target := &PackageTarget{Format: format, Binary: &BuildTarget{}, Version: test.version}
+			if v := target.packageVersion(); v != expected {
+				t.Errorf("%v as %s: expected %q, got %q", test.version, format, expected, v)
+			}
+		}
+	}
+}
+
+func TestPackageTargetValidate(t *testing.T) {
+	binary := &BuildTarget{ExecutableName: DefaultNameTemplate("app"), Platform: LinuxAmd64}
+	tests := []struct {
+		name    string
+		target  *PackageTarget
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			target: &PackageTarget{Format: PackageDeb, Binary: binary, PackageName: "app", Description: "An app", Depends: []string{"libc6 >= 2.17"}},
+		},
+		{
+			name:    "no name",
+			target:  &PackageTarget{Format: PackageDeb, Binary: binary, Description: "An app"},
+			wantErr: "package has no name",
+		},
+		{
+			name:    "no binary",
+			target:  &PackageTarget{Format: PackageDeb, PackageName: "app", Description: "An app"},
+			wantErr: "has no binary",
+		},
+		{
+			name:    "windows binary",
+			target:  &PackageTarget{Format: PackageDeb, Binary: &BuildTarget{Platform: WindowsAmd64}, PackageName: "app", Description: "An app"},
+			wantErr: "can only contain a linux binary",
+		},
+		{
+			name:    "no description",
+			target:  &PackageTarget{Format: PackageDeb, Binary: binary, PackageName: "app", Description: " \n"},
+			wantErr: "has no description",
+		},
+		{
+			name:    "invalid dependency",
+			target:  &PackageTarget{Format: PackageRPM, Binary: binary, PackageName: "app", Description: "An app", Depends: []string{"libc6 ~ 2"}},
+			wantErr: "invalid dependency",
+		},
+		{
+			name:    "unsupported architecture",
+			target:  &PackageTarget{Format: PackageAPK, Binary: &BuildTarget{Platform: LinuxPpc64}, PackageName: "app", Description: "An app"},
+			wantErr: "not supported by apk packages",
+		},
+		{
+			name:    "invalid format",
+			target:  &PackageTarget{Format: "msi", Binary: binary, PackageName: "app", Description: "An app"},
+			wantErr: "invalid package format",
+		},
+	}
+	for _, test := range tests {
+		checkError(t, test.target.Validate(), test.wantErr)
+	}
+}
+
+func TestPackageTargetOutputName(t *testing.T) {
+	base := &PackageTarget{
+		Binary:      &BuildTarget{Platform: LinuxArm64, Version: BasicVersion("1.2.3-rc.1")},
+		PackageName: "app",
+		OutputDir:   "dist",
+	}
+	expected := []string{
+		filepath.Join("dist", "app_1.2.3~rc.1-1_arm64.deb"),
+		filepath.Join("dist", "app-1.2.3~rc.1-1.aarch64.rpm"),
+		filepath.Join("dist", "app-1.2.3_rc1-r1_aarch64.apk"),
+	}
+
+	names := make([]string, 0)
+	for _, target := range base.MultiFormat(PackageDeb, PackageRPM, PackageAPK) {
+		names = append(names, target.OutputName())
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestPackageTargetExecute(t *testing.T) {
+	dir := t.TempDir()
+	writeMainModule(t, dir)
+	chdir(t, dir)
+
+	suite := NewBuildSuite(PlatformSet{LinuxAmd64})
+	binary := &BuildTarget{ExecutableName: DefaultNameTemplate("app"), Platform: LinuxAmd64, Version: BasicVersion("1.0.0")}
+	target := &PackageTarget{Format: PackageDeb, Binary: binary, PackageName: "app", Description: "An app"}
+	if err := suite.RegisterTargetE(target); err != nil {
+		t.Fatal(err)
+	}
+	if err := suite.ExecuteNamedTarget(target.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*Artifact{
+		{Path: "app_linux-amd64", Kind: ArtifactExecutable, Platform: LinuxAmd64, Target: binary.Name()},
+		{Path: "app_1.0.0-1_amd64.deb", Kind: ArtifactPackage, Platform: LinuxAmd64, Target: "package_app_deb_linux_amd64"},
+	}
+	if artifacts := suite.Artifacts(); !reflect.DeepEqual(artifacts, expected) {
+		t.Errorf("expected artifacts %v, got %v", expected, artifacts)
+	}
+}
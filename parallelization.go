@@ -4,14 +4,26 @@ package make
 // executed in paralell.
 type ParallelTargets struct {
 	SubTargets []Target
+	// Jobs limits the number of sub targets executed at
+	// the same time. Zero means no limit.
+	Jobs int
 }
 
 // Execute executes all sub targets in parallel.
 func (t *ParallelTargets) Execute(suite *Suite) error {
 	errors := make(chan error, len(t.SubTargets))
 
+	var jobs chan struct{}
+	if t.Jobs > 0 {
+		jobs = make(chan struct{}, t.Jobs)
+	}
+
 	for _, st := range t.SubTargets {
 		go func(st Target, errors chan<- error) {
+			if jobs != nil {
+				jobs <- struct{}{}
+				defer func() { <-jobs }()
+			}
 			errors <- st.Execute(suite)
 		}(st, errors)
 	}
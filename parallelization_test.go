@@ -0,0 +1,85 @@
+package make
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrencyTarget records the maximum number of its executions
+// running at the same time.
+type concurrencyTarget struct {
+	mutex   sync.Mutex
+	running int
+	max     int
+}
+
+func (t *concurrencyTarget) Execute(suite *Suite) error {
+	t.mutex.Lock()
+	t.running++
+	if t.running > t.max {
+		t.max = t.running
+	}
+	t.mutex.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	t.mutex.Lock()
+	t.running--
+	t.mutex.Unlock()
+	return nil
+}
+
+func TestParallelTargetsJobs(t *testing.T) {
+	tests := []struct {
+		jobs int
+		max  int
+	}{
+		{jobs: 0, max: 6},
+		{jobs: 1, max: 1},
+		{jobs: 2, max: 2},
+		{jobs: 10, max: 6},
+	}
+	for _, test := range tests {
+		target := &concurrencyTarget{}
+		subTargets := make([]Target, 6)
+		for i := range subTargets {
+			subTargets[i] = target
+		}
+		parallel := &ParallelTargets{SubTargets: subTargets, Jobs: test.jobs}
+		if err := parallel.Execute(NewBuildSuite(nil)); err != nil {
+			t.Fatal(err)
+		}
+		if target.max > test.max || test.jobs > 0 && test.jobs < len(subTargets) && target.max != test.max {
+			t.Errorf("jobs %d: expected at most %d targets at the same time, got %d", test.jobs, test.max, target.max)
+		}
+	}
+}
+
+func TestParallelTargetsErrors(t *testing.T) {
+	tests := []struct {
+		errs  []error
+		count int
+	}{
+		{errs: []error{nil, nil}},
+		{errs: []error{errors.New("first"), nil, errors.New("second")}, count: 2},
+	}
+	for _, test := range tests {
+		subTargets := make([]Target, len(test.errs))
+		for i, err := range test.errs {
+			subTargets[i] = &countingTarget{err: err}
+		}
+		err := (&ParallelTargets{SubTargets: subTargets, Jobs: 1}).Execute(NewBuildSuite(nil))
+		if test.count == 0 {
+			if err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			continue
+		}
+		multi, ok := err.(*MultiError)
+		if !ok || len(multi.Errors) != test.count {
+			t.Errorf("expected %d errors, got %v", test.count, err)
+		}
+	}
+}
@@ -80,6 +80,9 @@ const (
 	Mips64 Arch = "mips64"
 	// Mips64LE represents the Mips64LE architecture.
 	Mips64LE Arch = "mips64le"
+	// Universal represents a macOS universal binary containing
+	// multiple architectures. It can not be built by go directly.
+	Universal Arch = "universal"
 )
 
 // ParseArch checks the text and returns an equivalent Arch if possible.
@@ -90,7 +93,7 @@ func ParseArch(text string) (arch Arch, err error) {
 		text = runtime.GOARCH
 	}
 
-	for _, a := range []Arch{Arm, Arm64, X386, Amd64, Ppc64, Ppc64LE, Mips, MipsLE, Mips64, Mips64LE} {
+	for _, a := range []Arch{Arm, Arm64, X386, Amd64, Ppc64, Ppc64LE, Mips, MipsLE, Mips64, Mips64LE, Universal} {
 		if string(a) == text {
 			arch = a
 			return
@@ -114,7 +117,9 @@ type Platform struct {
 }
 
 // ParsePlatform tries to parse the given OS and Arch and checks if
-// the combination of those is supported by go.
+// the combination of those is supported by go. The combination of
+// darwin and universal results in DarwinUniversal, which selects
+// UniversalBinaryTargets.
 func ParsePlatform(osText, archText string) (p *Platform, err error) {
 	os, err := ParseOS(osText)
 	if err != nil {
@@ -124,6 +129,9 @@ func ParsePlatform(osText, archText string) (p *Platform, err error) {
 	if err != nil {
 		return
 	}
+	if DarwinUniversal.Equals(&Platform{OS: os, Arch: arch}) {
+		return DarwinUniversal, nil
+	}
 
 	// Note return the platform in SupportedPlatforms because that has more information (e. g. the file extension).
 	ok, p := SupportedPlatforms.Contains(&Platform{OS: os, Arch: arch})
@@ -174,6 +182,7 @@ var Plan9Amd64 = &Platform{OS: Plan9, Arch: Amd64, Extension: ""}
 var SolarisAmd64 = &Platform{OS: Solaris, Arch: Amd64, Extension: ""}
 var WindowsX386 = &Platform{OS: Windows, Arch: X386, Extension: ".exe"}
 var WindowsAmd64 = &Platform{OS: Windows, Arch: Amd64, Extension: ".exe"}
+var DarwinUniversal = &Platform{OS: Darwin, Arch: Universal, Extension: ""}
 var PlatformNone = &Platform{OS: "NONE", Arch: "NONE", Extension: ""}
 
 // SupportedPlatforms contains all platforms supported by go.
@@ -0,0 +1,37 @@
+package make
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		os, arch string
+		platform *Platform
+		wantErr  string
+	}{
+		{os: "linux", arch: "amd64", platform: LinuxAmd64},
+		{os: "Windows", arch: "AMD64", platform: WindowsAmd64},
+		{os: "darwin", arch: "universal", platform: DarwinUniversal},
+		{os: "linux", arch: "universal", wantErr: "unsupported by go"},
+		{os: "windows", arch: "mips", wantErr: "unsupported by go"},
+		{os: "beos", arch: "amd64", wantErr: "invalid OS"},
+		{os: "linux", arch: "sparc", wantErr: "invalid architecture"},
+	}
+	for _, test := range tests {
+		platform, err := ParsePlatform(test.os, test.arch)
+		checkError(t, err, test.wantErr)
+		if test.platform != nil && platform != test.platform {
+			t.Errorf("%s/%s: expected %v, got %v", test.os, test.arch, test.platform, platform)
+		}
+	}
+
+	native, err := ParsePlatform("native", "native")
+	if err != nil {
+		t.Skip("unsupported platform:", err)
+	}
+	if native.OS.String() != runtime.GOOS || native.Arch.String() != runtime.GOARCH {
+		t.Errorf("unexpected native platform %v", native)
+	}
+}
@@ -0,0 +1,305 @@
+package make
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-version"
+)
+
+const (
+	// PublishTargetName is the name of PublishTargets.
+	PublishTargetName = "publish"
+	// DefaultReleaseRoot is the default root directory
+	// of PublishTargets.
+	DefaultReleaseRoot = "releases"
+)
+
+// Release is a version to be published.
+type Release struct {
+	Version Version
+	// Tag is the name of the git tag of the release.
+	Tag string
+	// Notes are the release notes in Markdown.
+	Notes     string
+	Artifacts []*Artifact
+	// If Force is true an already published version or already
+	// uploaded artifacts are replaced.
+	Force bool
+}
+
+// Publisher publishes releases, e. g. to a directory or a
+// release hosting service.
+type Publisher interface {
+	// Publish publishes the artifacts of the release. Publishing
+	// the same release again must not fail or duplicate anything,
+	// so failed attempts can be retried.
+	Publish(release *Release) error
+}
+
+// PublishTarget publishes the artifacts recorded by the Suite.
+// The content of the last recorded release notes artifact, e. g.
+// written by a ChangelogTarget, is used as notes of the release.
+type PublishTarget struct {
+	// Publisher defaults to a DirectoryPublisher with the Root.
+	Publisher Publisher
+	// Root is the directory of the default DirectoryPublisher.
+	// Defaults to DefaultReleaseRoot.
+	Root    string
+	Version Version
+	// Tag defaults to the original version string if the Version
+	// is parsed and to the Version itself otherwise.
+	Tag string
+	// Dependencies are executed sequentially before publishing,
+	// e. g. the Targets producing the artifacts. If empty the
+	// registered Targets whose names start with one of the
+	// DefaultPublishPrefixes are executed in that order.
+	Dependencies []Target
+	// Kinds restricts the published artifacts to the given kinds.
+	// If empty all artifacts are published.
+	Kinds []ArtifactKind
+	// If Force is true an already published version is replaced,
+	// otherwise the DirectoryPublisher fails.
+	Force bool
+}
+
+// DefaultPublishPrefixes are the name prefixes of the registered
+// Targets a PublishTarget without Dependencies executes, so the
+// artifacts are recorded before signing them.
+var DefaultPublishPrefixes = []string{
+	BuildTargetNamePrefix,
+	ArchiveTargetNamePrefix,
+	PackageTargetNamePrefix,
+	SBOMTargetNamePrefix,
+	ChangelogTargetName,
+	SignTargetNamePrefix,
+}
+
+// Execute executes the Dependencies and publishes the artifacts.
+func (t *PublishTarget) Execute(suite *Suite) error {
+	if err := suite.executeDependencies(t.dependencies(suite)); err != nil {
+		return err
+	}
+	if t.Version == nil {
+		return fmt.Errorf("no version to publish")
+	}
+
+	release := &Release{
+		Version:   t.Version,
+		Tag:       t.tag(),
+		Artifacts: t.artifacts(suite),
+		Force:     t.Force,
+	}
+	if len(release.Artifacts) == 0 {
+		return fmt.Errorf("no artifacts to publish, none of the dependencies recorded any")
+	}
+	for _, artifact := range release.Artifacts {
+		if artifact.Kind == ArtifactReleaseNotes {
+			notes, err := ioutil.ReadFile(artifact.Path)
+			if err != nil {
+				return err
+			}
+			release.Notes = string(notes)
+		}
+	}
+
+	publisher := t.Publisher
+	if publisher == nil {
+		publisher = &DirectoryPublisher{Root: t.Root}
+	}
+	return publisher.Publish(release)
+}
+
+// dependencies returns the Dependencies or the registered Targets
+// matching the DefaultPublishPrefixes.
+func (t *PublishTarget) dependencies(suite *Suite) []Target {
+	if len(t.Dependencies) > 0 {
+		return t.Dependencies
+	}
+	dependencies := make([]Target, 0)
+	for _, prefix := range DefaultPublishPrefixes {
+		dependencies = append(dependencies, selectTargets(suite, prefix, nil)...)
+	}
+	return dependencies
+}
+
+// artifacts returns the recorded artifacts of the Kinds.
+func (t *PublishTarget) artifacts(suite *Suite) []*Artifact {
+	if len(t.Kinds) == 0 {
+		return suite.Artifacts()
+	}
+
+	artifacts := make([]*Artifact, 0)
+	for _, artifact := range suite.Artifacts() {
+		for _, kind := range t.Kinds {
+			if artifact.Kind == kind {
+				artifacts = append(artifacts, artifact)
+				break
+			}
+		}
+	}
+	return artifacts
+}
+
+func (t *PublishTarget) tag() string {
+	if t.Tag != "" {
+		return t.Tag
+	}
+	if o, ok := t.Version.(interface{ Original() string }); ok {
+		return o.Original()
+	}
+	return t.Version.String()
+}
+
+// Name returns PublishTargetName.
+func (t *PublishTarget) Name() string {
+	return PublishTargetName
+}
+
+// DirectoryPublisher copies releases into a directory based
+// release repository. Artifacts are copied to
+// "<Root>/<version>/<platform>/" or to "<Root>/<version>/" if they
+// are not platform specific. An "index.json" listing the artifacts
+// with their checksums is written to the version directory and the
+// file "<Root>/latest" contains the name of the latest version.
+//
+// Already published versions are only replaced if the release
+// is forced.
+type DirectoryPublisher struct {
+	// Root is the directory of the repository.
+	// Defaults to DefaultReleaseRoot.
+	Root string
+}
+
+// ReleaseIndex is the content of the "index.json" of a version.
+type ReleaseIndex struct {
+	Version   string          `json:"version"`
+	Date      time.Time       `json:"date"`
+	Artifacts []*ReleaseEntry `json:"artifacts"`
+}
+
+// ReleaseEntry describes a published artifact.
+type ReleaseEntry struct {
+	// Path is relative to the version directory and uses
+	// forward slashes.
+	Path   string       `json:"path"`
+	Kind   ArtifactKind `json:"kind"`
+	OS     OS           `json:"os,omitempty"`
+	Arch   Arch         `json:"arch,omitempty"`
+	Size   int64        `json:"size"`
+	SHA256 string       `json:"sha256"`
+}
+
+// Publish copies the artifacts of the release.
+func (p *DirectoryPublisher) Publish(release *Release) error {
+	root := p.root()
+	name := release.Version.String()
+	dir := filepath.Join(root, name)
+	if _, err := os.Stat(dir); err == nil && !release.Force {
+		return fmt.Errorf("version %s is already published in %s", name, root)
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	tmpDir, err := ioutil.TempDir(root, "."+name+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		return err
+	}
+
+	fmt.Println("Publishing version:", dir)
+	index := &ReleaseIndex{Version: name, Date: buildDate().UTC().Truncate(time.Second)}
+	for _, artifact := range release.Artifacts {
+		entry, err := p.copyArtifact(tmpDir, artifact)
+		if err != nil {
+			return fmt.Errorf("could not publish %s: %v", artifact.Path, err)
+		}
+		index.Artifacts = append(index.Artifacts, entry)
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "index.json"), append(data, '\n'), 0644); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return err
+	}
+	return p.updateLatest(root, name)
+}
+
+func (p *DirectoryPublisher) copyArtifact(dir string, artifact *Artifact) (*ReleaseEntry, error) {
+	entry := &ReleaseEntry{Kind: artifact.Kind}
+	relative := filepath.Base(artifact.Path)
+	if artifact.Platform != nil {
+		entry.OS = artifact.Platform.OS
+		entry.Arch = artifact.Platform.Arch
+		relative = filepath.Join(artifact.Platform.String(), relative)
+	}
+	entry.Path = filepath.ToSlash(relative)
+
+	info, err := os.Stat(artifact.Path)
+	if err != nil {
+		return nil, err
+	}
+	entry.Size = info.Size()
+	entry.SHA256, err = fileSHA256(artifact.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := filepath.Join(dir, relative)
+	if _, err := os.Stat(filename); err == nil {
+		return nil, fmt.Errorf("%s is published by multiple artifacts", entry.Path)
+	}
+	err = writeFileAtomically(filename, info.Mode().Perm(), func(w io.Writer) error {
+		return copyFile(w, artifact.Path)
+	})
+	return entry, err
+}
+
+// updateLatest points the latest file to the version unless
+// the current latest version is greater.
+func (p *DirectoryPublisher) updateLatest(root, name string) error {
+	filename := filepath.Join(root, "latest")
+	current, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if latest := strings.TrimSpace(string(current)); latest != "" {
+		latestVersion, errLatest := version.NewVersion(latest)
+		newVersion, errNew := version.NewVersion(name)
+		if errLatest == nil && errNew == nil && latestVersion.GreaterThan(newVersion) {
+			return nil
+		}
+	}
+
+	return writeFileAtomically(filename, 0644, func(w io.Writer) error {
+		_, err := io.WriteString(w, name+"\n")
+		return err
+	})
+}
+
+func (p *DirectoryPublisher) root() string {
+	if p.Root == "" {
+		return DefaultReleaseRoot
+	}
+	return p.Root
+}
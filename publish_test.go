@@ -0,0 +1,270 @@
+package make
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-version"
+)
+
+// recordingPublisher is a Publisher remembering the releases.
+type recordingPublisher struct {
+	releases []*Release
+}
+
+func (p *recordingPublisher) Publish(release *Release) error {
+	p.releases = append(p.releases, release)
+	return nil
+}
+
+// artifactTarget records an artifact when executed.
+type artifactTarget struct {
+	name     string
+	artifact *Artifact
+	executed int
+}
+
+func (t *artifactTarget) Execute(suite *Suite) error {
+	t.executed++
+	suite.AddArtifact(t.artifact)
+	return nil
+}
+func (t *artifactTarget) Name() string { return t.name }
+
+func TestPublishTarget(t *testing.T) {
+	parsed, err := version.NewVersion("v1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		target    *PublishTarget
+		artifacts []*Artifact
+		// registered Targets record the artifacts instead.
+		registered []NamedTarget
+		tag        string
+		paths      []string
+		notes      string
+		wantErr    string
+	}{
+		{
+			name:      "all artifacts",
+			target:    &PublishTarget{Version: BasicVersion("1.2.0")},
+			artifacts: []*Artifact{{Path: "app", Kind: ArtifactExecutable}, {Path: "app.tar.gz", Kind: ArtifactArchive}},
+			tag:       "1.2.0",
+			paths:     []string{"app", "app.tar.gz"},
+		},
+		{
+			name:      "original tag",
+			target:    &PublishTarget{Version: parsed, Kinds: []ArtifactKind{ArtifactArchive}},
+			artifacts: []*Artifact{{Path: "app", Kind: ArtifactExecutable}, {Path: "app.tar.gz", Kind: ArtifactArchive}},
+			tag:       "v1.2.0",
+			paths:     []string{"app.tar.gz"},
+		},
+		{
+			name:      "explicit tag and notes",
+			target:    &PublishTarget{Version: BasicVersion("1.2.0"), Tag: "release-1.2"},
+			artifacts: []*Artifact{{Path: "app", Kind: ArtifactExecutable}, {Path: ReleaseNotesName, Kind: ArtifactReleaseNotes}},
+			tag:       "release-1.2",
+			paths:     []string{"app", ReleaseNotesName},
+			notes:     "## 1.2.0\n",
+		},
+		{
+			name:   "registered targets",
+			target: &PublishTarget{Version: BasicVersion("1.2.0")},
+			registered: []NamedTarget{
+				&artifactTarget{name: "sign_app", artifact: &Artifact{Path: "app.sig", Kind: ArtifactSignature}},
+				&artifactTarget{name: "build_app", artifact: &Artifact{Path: "app", Kind: ArtifactExecutable}},
+				&artifactTarget{name: "archive_app", artifact: &Artifact{Path: "app.tar.gz", Kind: ArtifactArchive}},
+				&artifactTarget{name: "vet", artifact: &Artifact{Path: "vet.txt"}},
+			},
+			tag:   "1.2.0",
+			paths: []string{"app", "app.tar.gz", "app.sig"},
+		},
+		{
+			name:   "explicit dependencies",
+			target: &PublishTarget{Version: BasicVersion("1.2.0"), Dependencies: []Target{&countingTarget{name: "nothing"}}},
+			registered: []NamedTarget{
+				&artifactTarget{name: "build_app", artifact: &Artifact{Path: "app", Kind: ArtifactExecutable}},
+			},
+			wantErr: "no artifacts to publish",
+		},
+		{
+			name:    "no version",
+			target:  &PublishTarget{},
+			wantErr: "no version to publish",
+		},
+		{
+			name:      "no matching artifacts",
+			target:    &PublishTarget{Version: BasicVersion("1.2.0"), Kinds: []ArtifactKind{ArtifactPackage}},
+			artifacts: []*Artifact{{Path: "app", Kind: ArtifactExecutable}},
+			wantErr:   "no artifacts to publish",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{ReleaseNotesName: "## 1.2.0\n"})
+			chdir(t, dir)
+
+			suite := NewBuildSuite(nil)
+			for _, artifact := range test.artifacts {
+				suite.AddArtifact(artifact)
+			}
+			suite.RegisterTargets(test.registered...)
+			publisher := &recordingPublisher{}
+			test.target.Publisher = publisher
+			err := test.target.Execute(suite)
+			checkError(t, err, test.wantErr)
+			if err != nil {
+				return
+			}
+
+			if len(publisher.releases) != 1 {
+				t.Fatalf("expected one release, got %d", len(publisher.releases))
+			}
+			release := publisher.releases[0]
+			paths := make([]string, len(release.Artifacts))
+			for i, artifact := range release.Artifacts {
+				paths[i] = artifact.Path
+			}
+			if release.Tag != test.tag || release.Notes != test.notes || !reflect.DeepEqual(paths, test.paths) {
+				t.Errorf("unexpected release %+v with artifacts %v", release, paths)
+			}
+		})
+	}
+}
+
+func TestDirectoryPublisher(t *testing.T) {
+	tests := []struct {
+		name      string
+		published []string
+		version   string
+		force     bool
+		artifacts []*Artifact
+		latest    string
+		files     []string
+		wantErr   string
+	}{
+		{
+			name:    "first release",
+			version: "1.0.0",
+			artifacts: []*Artifact{
+				{Path: "dist/app", Kind: ArtifactExecutable, Platform: LinuxAmd64},
+				{Path: "dist/app.exe", Kind: ArtifactExecutable, Platform: WindowsAmd64},
+				{Path: "dist/" + ReleaseNotesName, Kind: ArtifactReleaseNotes},
+			},
+			latest: "1.0.0",
+			files:  []string{"RELEASE_NOTES.md", "index.json", "linux_amd64", "linux_amd64/app", "windows_amd64", "windows_amd64/app.exe"},
+		},
+		{
+			name:      "older release",
+			published: []string{"2.0.0"},
+			version:   "1.0.0",
+			artifacts: []*Artifact{{Path: "dist/app", Kind: ArtifactExecutable, Platform: LinuxAmd64}},
+			latest:    "2.0.0",
+			files:     []string{"index.json", "linux_amd64", "linux_amd64/app"},
+		},
+		{
+			name:      "already published",
+			published: []string{"1.0.0"},
+			version:   "1.0.0",
+			artifacts: []*Artifact{{Path: "dist/app", Kind: ArtifactExecutable, Platform: LinuxAmd64}},
+			wantErr:   "already published",
+		},
+		{
+			name:      "forced",
+			published: []string{"1.0.0"},
+			version:   "1.0.0",
+			force:     true,
+			artifacts: []*Artifact{{Path: "dist/" + ReleaseNotesName, Kind: ArtifactReleaseNotes}},
+			latest:    "1.0.0",
+			files:     []string{"RELEASE_NOTES.md", "index.json"},
+		},
+		{
+			name:    "conflicting artifacts",
+			version: "1.0.0",
+			artifacts: []*Artifact{
+				{Path: "dist/app", Kind: ArtifactExecutable},
+				{Path: "dist/other/app", Kind: ArtifactExecutable},
+			},
+			wantErr: "published by multiple artifacts",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"dist/app":              "linux",
+				"dist/app.exe":          "windows",
+				"dist/other/app":        "other",
+				"dist/RELEASE_NOTES.md": "notes",
+			})
+			chdir(t, dir)
+			publisher := &DirectoryPublisher{Root: "releases"}
+			for _, published := range test.published {
+				release := &Release{Version: BasicVersion(published), Artifacts: []*Artifact{{Path: "dist/app", Kind: ArtifactExecutable}}}
+				if err := publisher.Publish(release); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := publisher.Publish(&Release{Version: BasicVersion(test.version), Artifacts: test.artifacts, Force: test.force})
+			checkError(t, err, test.wantErr)
+			if err != nil {
+				return
+			}
+
+			versionDir := filepath.Join("releases", test.version)
+			if files := listFiles(t, versionDir); !reflect.DeepEqual(files, test.files) {
+				t.Errorf("expected %v, got %v", test.files, files)
+			}
+			entries, err := ioutil.ReadDir("releases")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if strings.HasPrefix(entry.Name(), ".") {
+					t.Errorf("temporary directory %s was left", entry.Name())
+				}
+			}
+			latest, err := ioutil.ReadFile(filepath.Join("releases", "latest"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(latest) != test.latest+"\n" {
+				t.Errorf("expected latest %s, got %q", test.latest, latest)
+			}
+
+			data, err := ioutil.ReadFile(filepath.Join(versionDir, "index.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			index := &ReleaseIndex{}
+			if err := json.Unmarshal(data, index); err != nil {
+				t.Fatal(err)
+			}
+			if index.Version != test.version || len(index.Artifacts) != len(test.artifacts) {
+				t.Fatalf("unexpected index %s", data)
+			}
+			for i, entry := range index.Artifacts {
+				sum, err := fileSHA256(test.artifacts[i].Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				info, err := os.Stat(filepath.Join(versionDir, filepath.FromSlash(entry.Path)))
+				if err != nil || entry.SHA256 != sum || entry.Size != info.Size() {
+					t.Errorf("entry %+v does not match the published file", entry)
+				}
+			}
+		})
+	}
+}
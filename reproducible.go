@@ -0,0 +1,246 @@
+package make
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReproducibilityTarget verifies that BuildTargets are reproducible.
+// Each BuildTarget is built twice in reproducible mode, each time into
+// a separate temporary directory with an empty build cache, and the
+// hashes of the executables are compared. The second build uses a
+// copy of the sources in another directory, which is the git work
+// tree or, if there is none, the module. The OutputRoot and CacheDir
+// of the Suite are not copied and the copy shares the objects of the
+// git repository, so the version control information embedded by go
+// build stays the same.
+//
+// The dependencies of the BuildTargets are executed once using the
+// given Suite. The configured outputs are left untouched.
+type ReproducibilityTarget struct {
+	Targets []*BuildTarget
+	// KeepDirs keeps the temporary directories for inspection.
+	KeepDirs bool
+}
+
+// ReproducibilityError is returned if the executables of
+// some platforms differ between builds.
+type ReproducibilityError struct {
+	Platforms []*Platform
+}
+
+func (e *ReproducibilityError) Error() string {
+	names := make([]string, len(e.Platforms))
+	for i, p := range e.Platforms {
+		names[i] = p.String()
+	}
+	return "builds are not reproducible for " + strings.Join(names, ", ")
+}
+
+// Execute builds and compares the executables.
+func (t *ReproducibilityTarget) Execute(suite *Suite) error {
+	// A separate Suite keeps the temporary executables
+	// out of the artifacts.
+	scratch := NewBuildSuite(suite.SupportedPlatforms)
+
+	root, wd, git, err := reproducibilitySources()
+	if err != nil {
+		return err
+	}
+
+	// The dependencies may generate sources, so they
+	// are executed before copying.
+	for _, target := range t.Targets {
+		if err := suite.executeDependencies(target.Dependencies); err != nil {
+			return err
+		}
+	}
+	dir, err := ioutil.TempDir("", "gomake-reproducible")
+	if err != nil {
+		return err
+	}
+	if t.KeepDirs {
+		fmt.Println("Keeping:", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	sources := filepath.Join(dir, "src")
+	if err := copySources(suite, root, sources, git); err != nil {
+		return fmt.Errorf("could not copy the sources: %v", err)
+	}
+
+	differing := make([]*Platform, 0)
+	for _, target := range t.Targets {
+		hashes := make([]string, 2)
+		for i := range hashes {
+			dir, err := ioutil.TempDir("", "gomake-reproducible")
+			if err != nil {
+				return err
+			}
+			if t.KeepDirs {
+				fmt.Println("Keeping:", dir)
+			} else {
+				defer os.RemoveAll(dir)
+			}
+
+			build := target.Copy()
+			build.Reproducible = true
+			build.OutputDir = filepath.Join(dir, "out")
+			build.buildCache = filepath.Join(dir, "cache")
+			build.Dependencies = nil
+			if i == 1 {
+				build.dir = filepath.Join(sources, wd)
+			}
+			if err := build.Execute(scratch); err != nil {
+				return err
+			}
+
+			hashes[i], err = fileSHA256(build.OutputName())
+			if err != nil {
+				return err
+			}
+		}
+
+		if hashes[0] == hashes[1] {
+			fmt.Printf("Reproducible: %s (sha256 %s)\n", target.Platform, hashes[0])
+		} else {
+			fmt.Printf("Not reproducible: %s (sha256 %s and %s)\n", target.Platform, hashes[0], hashes[1])
+			differing = append(differing, target.Platform)
+		}
+	}
+
+	if len(differing) > 0 {
+		return &ReproducibilityError{Platforms: differing}
+	}
+	return nil
+}
+
+// reproducibilitySources returns the directory to copy for the
+// second build, the working directory relative to it and whether
+// it is a git work tree with a commit.
+func reproducibilitySources() (string, string, bool, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", "", false, err
+	}
+	wd, err = filepath.EvalSymlinks(wd)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	git := true
+	root, err := gitOutput("", "rev-parse", "--show-toplevel")
+	if err == nil {
+		_, err = gitOutput(root, "rev-parse", "--verify", "-q", "HEAD")
+		git = err == nil
+	} else {
+		git = false
+		root, err = findModuleRoot(wd)
+		if err != nil {
+			return "", "", false, err
+		}
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	rel, err := filepath.Rel(root, wd)
+	return root, rel, git, err
+}
+
+// copySources copies root to dst without the OutputRoot and the
+// CacheDir of the Suite. If git is true dst shares the objects of
+// the repository in root instead of copying the .git directory.
+func copySources(suite *Suite, root, dst string, git bool) error {
+	resolve := func(path string) string {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			return resolved
+		}
+		return path
+	}
+	// dst is skipped if it is inside root, e. g. if TMPDIR is.
+	skipped := map[string]bool{
+		filepath.Join(resolve(filepath.Dir(dst)), filepath.Base(dst)): true,
+	}
+	for _, dir := range []string{suite.OutputRoot, suite.CacheDir} {
+		if dir == "" {
+			continue
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		skipped[resolve(abs)] = true
+	}
+
+	if git {
+		if _, err := gitOutput(root, "clone", "-q", "--shared", "--no-checkout", root, dst); err != nil {
+			return err
+		}
+		// The clone checks out the default branch, while
+		// the work tree may be at any commit.
+		commit, err := gitOutput(root, "rev-parse", "HEAD")
+		if err != nil {
+			return err
+		}
+		if _, err := gitOutput(dst, "update-ref", "--no-deref", "HEAD", commit); err != nil {
+			return err
+		}
+		if _, err := gitOutput(dst, "reset", "-q"); err != nil {
+			return err
+		}
+	}
+
+	return copyTree(root, dst, func(path string, info os.FileInfo) bool {
+		return skipped[path] || git && info.Name() == ".git"
+	})
+}
+
+// copyTree copies the directory src to dst keeping the permissions.
+// Symbolic links are copied as links. Files and directories for which
+// skip returns true are left out.
+func copyTree(src, dst string, skip func(path string, info os.FileInfo) bool) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if skip(path, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+			if err != nil {
+				return err
+			}
+			err = copyFile(f, path)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}
+		// Sockets, devices and named pipes are skipped.
+		return nil
+	})
+}
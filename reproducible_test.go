@@ -0,0 +1,227 @@
+package make
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildTargetDate(t *testing.T) {
+	const commitTime = 1600000000
+	tests := []struct {
+		name         string
+		reproducible bool
+		epoch        string
+		git          bool
+		date         time.Time
+		wantErr      string
+	}{
+		{name: "not reproducible", epoch: "", date: startTime},
+		{name: "not reproducible with epoch", epoch: "1500000000", date: time.Unix(1500000000, 0).UTC()},
+		{name: "epoch", reproducible: true, epoch: "1500000000", git: true, date: time.Unix(1500000000, 0).UTC()},
+		{name: "git commit", reproducible: true, git: true, date: time.Unix(commitTime, 0).UTC()},
+		{name: "invalid epoch", reproducible: true, epoch: "yesterday", wantErr: "invalid SOURCE_DATE_EPOCH"},
+		{name: "no git", reproducible: true, wantErr: "need SOURCE_DATE_EPOCH or a git commit"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if test.git {
+				t.Setenv("GIT_COMMITTER_DATE", "@1600000000 +0000")
+				initGitRepo(t, dir, map[string]string{"README": "readme"})
+			}
+			t.Setenv("SOURCE_DATE_EPOCH", test.epoch)
+
+			target := &BuildTarget{Reproducible: test.reproducible, dir: dir}
+			date, err := target.date()
+			checkError(t, err, test.wantErr)
+			if !date.Equal(test.date) {
+				t.Errorf("expected %v, got %v", test.date, date)
+			}
+			if err == nil && test.reproducible {
+				expected := "SOURCE_DATE_EPOCH=" + strconv.FormatInt(date.Unix(), 10)
+				env := target.environment(date)
+				found := false
+				for _, variable := range env {
+					found = found || variable == expected
+				}
+				if !found {
+					t.Errorf("expected %s in the environment, got %v", expected, env)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildTargetVariablesDate(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	data := NewTemplateData(LinuxAmd64, BasicVersion("1.2.3"), "app")
+	data.Date = time.Unix(1500000000, 0).UTC()
+
+	target := &BuildTarget{Variables: map[string]string{"main.date": "{{.Date.Unix}}"}}
+	flags, err := target.variables(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flags) != 1 || flags[0] != "-X main.date=1500000000" {
+		t.Errorf("unexpected flags %v", flags)
+	}
+
+	if first, second := buildDate(), buildDate(); !first.Equal(second) {
+		t.Errorf("the build date changed from %v to %v", first, second)
+	}
+}
+
+func TestReproducibilityTarget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("every build starts with an empty build cache")
+	}
+	tests := []struct {
+		name  string
+		git   bool
+		sub   string
+		epoch string
+	}{
+		{name: "module in a subdirectory", git: true, sub: "app"},
+		{name: "module without git", epoch: "1500000000"},
+	}
+
+	native := nativePlatform(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			module := filepath.Join(root, test.sub)
+			writeMainModule(t, module)
+			if test.git {
+				initGitRepo(t, root, map[string]string{"README": "readme"})
+			}
+			chdir(t, module)
+			t.Setenv("SOURCE_DATE_EPOCH", test.epoch)
+
+			target := &ReproducibilityTarget{Targets: []*BuildTarget{{
+				ExecutableName: DefaultNameTemplate("app"),
+				Platform:       native,
+				Variables:      map[string]string{"main.version": "{{.Date.Unix}}"},
+				Stdout:         &bytes.Buffer{},
+				Stderr:         &bytes.Buffer{},
+			}}}
+			if err := target.Execute(NewBuildSuite(PlatformSet{native})); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join(module, "app")); !os.IsNotExist(err) {
+				t.Errorf("expected the configured output to be untouched")
+			}
+		})
+	}
+}
+
+func TestBuildTargetReproducibleEnvironment(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{name: "GOAMD64", value: "v3", expected: "GOAMD64=v3"},
+		{name: "GOFLAGS", value: "-mod=vendor", expected: "GOFLAGS=-mod=vendor"},
+		{name: "GOEXPERIMENT", value: "loopvar", expected: "GOEXPERIMENT=loopvar"},
+		{name: "HTTPS_PROXY", value: "http://proxy:3128", expected: "HTTPS_PROXY=http://proxy:3128"},
+		{name: "CGO_ENABLED", value: "1", expected: "CGO_ENABLED=0"},
+		{name: "CC", value: "clang"},
+	}
+	for _, test := range tests {
+		t.Setenv(test.name, test.value)
+		env := (&BuildTarget{Reproducible: true}).environment(time.Unix(0, 0))
+		found := ""
+		for _, variable := range env {
+			if strings.HasPrefix(variable, test.name+"=") {
+				found = variable
+			}
+		}
+		if found != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, found)
+		}
+	}
+}
+
+func TestCopySources(t *testing.T) {
+	tests := []struct {
+		name string
+		git  bool
+	}{
+		{name: "git work tree", git: true},
+		{name: "module", git: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root, err := filepath.EvalSymlinks(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := map[string]string{
+				".gitignore": "dist/\n.gomake/\ntmp/\n",
+				"go.mod":     "module example.com/app\n",
+				"main.go":    "package main\n",
+			}
+			if test.git {
+				initGitRepo(t, root, files)
+				git(t, root, "commit", "-q", "--allow-empty", "-m", "second commit")
+				git(t, root, "checkout", "-q", "HEAD~1")
+			} else {
+				writeFiles(t, root, files)
+			}
+			writeFiles(t, root, map[string]string{
+				"main.go":       "package main\n\nfunc main() {}\n",
+				"new.go":        "package main\n",
+				"dist/app":      "binary",
+				".gomake/cache": "cache",
+			})
+			chdir(t, root)
+
+			suite := NewBuildSuite(nil)
+			suite.OutputRoot = "dist"
+			dst := filepath.Join(root, "tmp", "src")
+			if err := copySources(suite, root, dst, test.git); err != nil {
+				t.Fatal(err)
+			}
+
+			// Parents of dst inside root may be copied as
+			// empty directories.
+			copied := make([]string, 0)
+			for _, file := range listFiles(t, dst) {
+				info, err := os.Stat(filepath.Join(dst, file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !info.IsDir() && !strings.HasPrefix(file, ".git/") {
+					copied = append(copied, file)
+				}
+			}
+			if expected := []string{".gitignore", "go.mod", "main.go", "new.go"}; !reflect.DeepEqual(copied, expected) {
+				t.Errorf("expected %v, got %v", expected, copied)
+			}
+			if !test.git {
+				return
+			}
+			for _, args := range [][]string{{"rev-parse", "HEAD"}, {"status", "--porcelain"}} {
+				original, err := gitOutput(root, args...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				copy, err := gitOutput(dst, args...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if original != copy {
+					t.Errorf("git %s: expected %q, got %q", args[0], original, copy)
+				}
+			}
+		})
+	}
+}
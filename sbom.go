@@ -0,0 +1,462 @@
+package make
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SBOMTargetNamePrefix is the prefix all SBOMTargets
+// will have in theire name.
+const SBOMTargetNamePrefix = "sbom_"
+
+// SBOMFormat represents the format of a software bill of materials.
+type SBOMFormat string
+
+const (
+	// SBOMCycloneDX represents CycloneDX 1.5 JSON documents.
+	SBOMCycloneDX SBOMFormat = "cyclonedx"
+	// SBOMSPDX represents SPDX 2.3 JSON documents.
+	SBOMSPDX SBOMFormat = "spdx"
+)
+
+// sbomExtensions contains the conventional file extensions
+// of the formats.
+var sbomExtensions = map[SBOMFormat]string{
+	SBOMCycloneDX: ".cdx.json",
+	SBOMSPDX:      ".spdx.json",
+}
+
+// SBOMTarget writes a software bill of materials describing
+// the output of a BuildTarget, which is executed as a dependency.
+// The modules are read from the build information embedded in
+// the executable.
+type SBOMTarget struct {
+	Format SBOMFormat
+	Binary *BuildTarget
+	// If UseGoList is true the modules are listed using
+	// "go list -m -json all" instead. This is also done if the
+	// executable contains no build information.
+	UseGoList bool
+	// Version is the version of the main module. Defaults to
+	// the Version of the Binary.
+	Version Version
+
+	// OutputDir is the directory the document is written to.
+	// If empty it defaults to the output directory of the Suite.
+	OutputDir string
+
+	// Where to redirect the stderr of go list. If nil
+	// stderr will be redirected to this precesses stderr.
+	Stderr io.Writer
+
+	// defaultOutputDir is the output directory of the Suite
+	// the target is registered with.
+	defaultOutputDir string
+}
+
+// SBOMTargetsFromBuildTargets creates one SBOMTarget of the
+// given format for each BuildTarget.
+func SBOMTargetsFromBuildTargets(format SBOMFormat, targets ...*BuildTarget) []*SBOMTarget {
+	sboms := make([]*SBOMTarget, len(targets))
+	for i, t := range targets {
+		sboms[i] = &SBOMTarget{Format: format, Binary: t}
+	}
+	return sboms
+}
+
+// MultiFormat returns one SBOMTarget based on the
+// current SBOM target for each format.
+func (t *SBOMTarget) MultiFormat(formats ...SBOMFormat) []*SBOMTarget {
+	newTargets := make([]*SBOMTarget, len(formats))
+	for i, format := range formats {
+		copy := *t
+		copy.Format = format
+		newTargets[i] = &copy
+	}
+	return newTargets
+}
+
+// sbomModule is a Go module contained in an executable.
+type sbomModule struct {
+	Path    string
+	Version string
+}
+
+func (m *sbomModule) purl() string {
+	if m.Version == "" {
+		return "pkg:golang/" + m.Path
+	}
+	return "pkg:golang/" + m.Path + "@" + m.Version
+}
+
+// sbomInfo contains everything needed to write a document.
+type sbomInfo struct {
+	main      *sbomModule
+	modules   []*sbomModule
+	platform  *Platform
+	filename  string
+	sha1      string
+	sha256    string
+	goVersion string
+	created   time.Time
+}
+
+// Execute builds the binary and writes the document.
+func (t *SBOMTarget) Execute(suite *Suite) error {
+	if err := suite.executeDependencies([]Target{t.Binary}); err != nil {
+		return err
+	}
+
+	info, err := t.info()
+	if err != nil {
+		return fmt.Errorf("could not create SBOM: %v", err)
+	}
+
+	filename := t.OutputName()
+	fmt.Println("Writing SBOM:", filename)
+	err = writeFileAtomically(filename, 0644, func(w io.Writer) error {
+		var doc interface{}
+		switch t.Format {
+		case SBOMCycloneDX:
+			doc = cycloneDXDocument(info)
+		case SBOMSPDX:
+			doc = spdxDocument(info)
+		default:
+			return fmt.Errorf("invalid SBOM format \"%s\"", t.Format)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(doc)
+	})
+	if err != nil {
+		return fmt.Errorf("could not create SBOM: %v", err)
+	}
+
+	suite.AddArtifact(&Artifact{
+		Path:     filename,
+		Kind:     ArtifactSBOM,
+		Platform: t.Binary.Platform,
+		Target:   t.Name(),
+	})
+	return nil
+}
+
+func (t *SBOMTarget) info() (*sbomInfo, error) {
+	filename, err := t.Binary.OutputPath()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &sbomInfo{
+		platform: t.Binary.Platform,
+		filename: filepath.Base(filename),
+		created:  buildDate().UTC(),
+	}
+
+	content := &bytes.Buffer{}
+	if err := copyFile(content, filename); err != nil {
+		return nil, err
+	}
+	sum1 := sha1.Sum(content.Bytes())
+	sum256 := sha256.Sum256(content.Bytes())
+	info.sha1 = hex.EncodeToString(sum1[:])
+	info.sha256 = hex.EncodeToString(sum256[:])
+
+	var bi *buildinfo.BuildInfo
+	if !t.UseGoList {
+		bi, _ = buildinfo.Read(bytes.NewReader(content.Bytes()))
+	}
+	if bi != nil {
+		info.goVersion = bi.GoVersion
+		info.main = &sbomModule{Path: bi.Main.Path, Version: bi.Main.Version}
+		for _, dep := range bi.Deps {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			info.modules = append(info.modules, &sbomModule{Path: dep.Path, Version: dep.Version})
+		}
+	} else {
+		info.main, info.modules, err = t.listModules()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v := t.version(); v != nil {
+		info.main.Version = v.String()
+	}
+	return info, nil
+}
+
+// listModules returns the main module and its dependencies
+// as listed by "go list -m -json all".
+func (t *SBOMTarget) listModules() (*sbomModule, []*sbomModule, error) {
+	cmd := exec.Command("go", "list", "-m", "-json", "all")
+	if t.Stderr != nil {
+		cmd.Stderr = t.Stderr
+	} else {
+		cmd.Stderr = os.Stderr
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error running go list: %v", err)
+	}
+
+	type listedModule struct {
+		Path    string
+		Version string
+		Main    bool
+		Replace *listedModule
+	}
+
+	var main *sbomModule
+	modules := make([]*sbomModule, 0)
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	for decoder.More() {
+		m := &listedModule{}
+		if err := decoder.Decode(m); err != nil {
+			return nil, nil, fmt.Errorf("could not parse go list output: %v", err)
+		}
+		if m.Main {
+			main = &sbomModule{Path: m.Path}
+			continue
+		}
+		if m.Replace != nil {
+			m = m.Replace
+		}
+		modules = append(modules, &sbomModule{Path: m.Path, Version: m.Version})
+	}
+	if main == nil {
+		return nil, nil, fmt.Errorf("go list returned no main module")
+	}
+	return main, modules, nil
+}
+
+// sbomSerial returns a UUID derived from the checksum of the
+// artifact, so the documents are reproducible.
+func sbomSerial(info *sbomInfo, format SBOMFormat) string {
+	sum := sha256.Sum256([]byte(string(format) + info.sha256))
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func cycloneDXDocument(info *sbomInfo) interface{} {
+	type hash struct {
+		Alg     string `json:"alg"`
+		Content string `json:"content"`
+	}
+	type property struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	type component struct {
+		Type       string     `json:"type"`
+		BOMRef     string     `json:"bom-ref"`
+		Name       string     `json:"name"`
+		Version    string     `json:"version,omitempty"`
+		PURL       string     `json:"purl"`
+		Hashes     []hash     `json:"hashes,omitempty"`
+		Properties []property `json:"properties,omitempty"`
+	}
+	type dependency struct {
+		Ref       string   `json:"ref"`
+		DependsOn []string `json:"dependsOn"`
+	}
+
+	main := component{
+		Type:    "application",
+		BOMRef:  info.main.purl(),
+		Name:    info.main.Path,
+		Version: info.main.Version,
+		PURL:    info.main.purl(),
+		Hashes: []hash{
+			{Alg: "SHA-1", Content: info.sha1},
+			{Alg: "SHA-256", Content: info.sha256},
+		},
+		Properties: []property{
+			{Name: "file", Value: info.filename},
+			{Name: "platform", Value: info.platform.OS.String() + "/" + info.platform.Arch.String()},
+		},
+	}
+	if info.goVersion != "" {
+		main.Properties = append(main.Properties, property{Name: "go", Value: info.goVersion})
+	}
+
+	components := make([]component, 0, len(info.modules))
+	root := dependency{Ref: main.BOMRef, DependsOn: make([]string, 0, len(info.modules))}
+	for _, m := range info.modules {
+		components = append(components, component{
+			Type:    "library",
+			BOMRef:  m.purl(),
+			Name:    m.Path,
+			Version: m.Version,
+			PURL:    m.purl(),
+		})
+		root.DependsOn = append(root.DependsOn, m.purl())
+	}
+
+	return map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + sbomSerial(info, SBOMCycloneDX),
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"timestamp": info.created.Format(time.RFC3339),
+			"tools": map[string]interface{}{
+				"components": []component{{Type: "application", BOMRef: "go-make", Name: "go-make", PURL: "pkg:golang/github.com/targodan/go-make"}},
+			},
+			"component": main,
+		},
+		"components":   components,
+		"dependencies": []dependency{root},
+	}
+}
+
+func spdxDocument(info *sbomInfo) interface{} {
+	type checksum struct {
+		Algorithm string `json:"algorithm"`
+		Value     string `json:"checksumValue"`
+	}
+	type externalRef struct {
+		Category string `json:"referenceCategory"`
+		Type     string `json:"referenceType"`
+		Locator  string `json:"referenceLocator"`
+	}
+	type pkg struct {
+		ID               string        `json:"SPDXID"`
+		Name             string        `json:"name"`
+		Version          string        `json:"versionInfo,omitempty"`
+		FileName         string        `json:"packageFileName,omitempty"`
+		DownloadLocation string        `json:"downloadLocation"`
+		FilesAnalyzed    bool          `json:"filesAnalyzed"`
+		Checksums        []checksum    `json:"checksums,omitempty"`
+		ExternalRefs     []externalRef `json:"externalRefs"`
+		Purpose          string        `json:"primaryPackagePurpose,omitempty"`
+	}
+	type relationship struct {
+		Element string `json:"spdxElementId"`
+		Type    string `json:"relationshipType"`
+		Related string `json:"relatedSpdxElement"`
+	}
+
+	purlRef := func(m *sbomModule) []externalRef {
+		return []externalRef{{Category: "PACKAGE-MANAGER", Type: "purl", Locator: m.purl()}}
+	}
+
+	mainID := "SPDXRef-Package-main"
+	packages := []pkg{{
+		ID:               mainID,
+		Name:             info.main.Path,
+		Version:          info.main.Version,
+		FileName:         info.filename,
+		DownloadLocation: "NOASSERTION",
+		Checksums: []checksum{
+			{Algorithm: "SHA1", Value: info.sha1},
+			{Algorithm: "SHA256", Value: info.sha256},
+		},
+		ExternalRefs: purlRef(info.main),
+		Purpose:      "APPLICATION",
+	}}
+	relationships := []relationship{{Element: "SPDXRef-DOCUMENT", Type: "DESCRIBES", Related: mainID}}
+	for i, m := range info.modules {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+		packages = append(packages, pkg{
+			ID:               id,
+			Name:             m.Path,
+			Version:          m.Version,
+			DownloadLocation: "NOASSERTION",
+			ExternalRefs:     purlRef(m),
+			Purpose:          "LIBRARY",
+		})
+		relationships = append(relationships, relationship{Element: mainID, Type: "DEPENDS_ON", Related: id})
+	}
+
+	name := strings.TrimSuffix(info.filename, filepath.Ext(info.filename))
+	return map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              name,
+		"documentNamespace": "https://spdx.org/spdxdocs/" + name + "-" + sbomSerial(info, SBOMSPDX),
+		"creationInfo": map[string]interface{}{
+			"created":  info.created.Format(time.RFC3339),
+			"creators": []string{"Tool: go-make"},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+}
+
+func (t *SBOMTarget) version() Version {
+	if t.Version != nil {
+		return t.Version
+	}
+	return t.Binary.Version
+}
+
+// OutputName returns the name of the document including the
+// OutputDir. It consists of the name of the executable followed
+// by the extension of the format, e. g. ".cdx.json".
+func (t *SBOMTarget) OutputName() string {
+	filename, err := t.Binary.OutputPath()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(t.outputDir(), filepath.Base(filename)+sbomExtensions[t.Format])
+}
+
+// Validate returns an error if the format is unknown or the
+// Binary has no platform.
+func (t *SBOMTarget) Validate() error {
+	if _, ok := sbomExtensions[t.Format]; !ok {
+		return fmt.Errorf("invalid SBOM format \"%s\"", t.Format)
+	}
+	if t.Binary == nil || t.Binary.Platform == nil {
+		return fmt.Errorf("SBOM has no binary")
+	}
+	_, err := t.Binary.OutputPath()
+	return err
+}
+
+func (t *SBOMTarget) setDefaultOutputDir(suite *Suite) error {
+	var err error
+	t.defaultOutputDir, err = suite.OutputDir(t.Binary.Platform, t.version())
+	return err
+}
+
+// outputDir returns the OutputDir or, if it is empty, the
+// output directory of the Suite.
+func (t *SBOMTarget) outputDir() string {
+	if t.OutputDir != "" {
+		return t.OutputDir
+	}
+	return t.defaultOutputDir
+}
+
+// TargetPlatform returns the Platform of the Binary.
+func (t *SBOMTarget) TargetPlatform() *Platform {
+	return t.Binary.Platform
+}
+
+// Name returns the name of this Target.
+// The name will consist of the SBOMTargetNamePrefix followed
+// by the format, the Binary of the BuildTarget, if set, and
+// the Platform name.
+func (t *SBOMTarget) Name() string {
+	if t.Binary.Binary != "" {
+		return SBOMTargetNamePrefix + string(t.Format) + "_" + t.Binary.Binary + "_" + t.Binary.Platform.String()
+	}
+	return SBOMTargetNamePrefix + string(t.Format) + "_" + t.Binary.Platform.String()
+}
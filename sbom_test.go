@@ -0,0 +1,136 @@
+package make
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"testing"
+)
+
+func TestSBOMTargetName(t *testing.T) {
+	tests := []struct {
+		target *SBOMTarget
+		name   string
+	}{
+		{target: &SBOMTarget{Format: SBOMCycloneDX, Binary: &BuildTarget{Platform: LinuxAmd64}}, name: "sbom_cyclonedx_linux_amd64"},
+		{target: &SBOMTarget{Format: SBOMSPDX, Binary: &BuildTarget{Binary: "server", Platform: LinuxAmd64}}, name: "sbom_spdx_server_linux_amd64"},
+		{target: &SBOMTarget{Format: SBOMSPDX, Binary: &BuildTarget{Binary: "client", Platform: LinuxAmd64}}, name: "sbom_spdx_client_linux_amd64"},
+	}
+	for _, test := range tests {
+		if name := test.target.Name(); name != test.name {
+			t.Errorf("expected %s, got %s", test.name, name)
+		}
+	}
+}
+
+func TestSBOMTargetValidate(t *testing.T) {
+	binary := &BuildTarget{ExecutableName: DefaultNameTemplate("app"), Platform: LinuxAmd64}
+	tests := []struct {
+		target  *SBOMTarget
+		wantErr string
+	}{
+		{target: &SBOMTarget{Format: SBOMSPDX, Binary: binary}},
+		{target: &SBOMTarget{Format: "swid", Binary: binary}, wantErr: "invalid SBOM format"},
+		{target: &SBOMTarget{Format: SBOMCycloneDX}, wantErr: "SBOM has no binary"},
+		{target: &SBOMTarget{Format: SBOMCycloneDX, Binary: &BuildTarget{Platform: LinuxAmd64}}, wantErr: "no executable name template"},
+	}
+	for _, test := range tests {
+		checkError(t, test.target.Validate(), test.wantErr)
+	}
+}
+
+func TestSBOMTargetExecute(t *testing.T) {
+	native := nativePlatform(t)
+	dir := t.TempDir()
+	writeMainModule(t, dir)
+	chdir(t, dir)
+
+	tests := []struct {
+		format    SBOMFormat
+		useGoList bool
+		paths     map[string]string
+	}{
+		{format: SBOMCycloneDX, paths: map[string]string{"bomFormat": "CycloneDX", "name": "example.com/app", "version": "1.2.3"}},
+		{format: SBOMSPDX, paths: map[string]string{"spdxVersion": "SPDX-2.3", "name": "example.com/app", "version": "1.2.3"}},
+		{format: SBOMCycloneDX, useGoList: true, paths: map[string]string{"name": "example.com/app", "version": "1.2.3"}},
+	}
+
+	for _, test := range tests {
+		suite := NewBuildSuite(PlatformSet{native})
+		binary := &BuildTarget{ExecutableName: DefaultNameTemplate("app"), Platform: native, Version: BasicVersion("1.2.3")}
+		target := &SBOMTarget{Format: test.format, Binary: binary, UseGoList: test.useGoList, Stderr: ioutil.Discard}
+		if err := suite.Execute(target); err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := ioutil.ReadFile(target.OutputName())
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := make(map[string]interface{})
+		if err := json.Unmarshal(content, &doc); err != nil {
+			t.Fatal(err)
+		}
+		executable, err := ioutil.ReadFile(binary.OutputName())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(executable)
+
+		var main map[string]interface{}
+		var checksums []interface{}
+		var checksumKey string
+		if test.format == SBOMCycloneDX {
+			main = doc["metadata"].(map[string]interface{})["component"].(map[string]interface{})
+			checksums = main["hashes"].([]interface{})
+			checksumKey = "content"
+		} else {
+			main = doc["packages"].([]interface{})[0].(map[string]interface{})
+			main["version"] = main["versionInfo"]
+			checksums = main["checksums"].([]interface{})
+			checksumKey = "checksumValue"
+		}
+		for key, expected := range test.paths {
+			value := doc[key]
+			switch key {
+			case "name", "version":
+				value = main[key]
+			}
+			if value != expected {
+				t.Errorf("%s: expected %s %q, got %v", test.format, key, expected, value)
+			}
+		}
+		if value := checksums[1].(map[string]interface{})[checksumKey]; value != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: unexpected sha256 %v", test.format, value)
+		}
+	}
+}
+
+func TestSBOMSerial(t *testing.T) {
+	info := &sbomInfo{sha256: "abc"}
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	tests := []struct {
+		format SBOMFormat
+		info   *sbomInfo
+	}{
+		{SBOMCycloneDX, info},
+		{SBOMSPDX, info},
+		{SBOMSPDX, &sbomInfo{sha256: "def"}},
+	}
+	serials := make(map[string]bool)
+	for _, test := range tests {
+		serial := sbomSerial(test.info, test.format)
+		if !uuid.MatchString(serial) {
+			t.Errorf("%s is not a version 5 UUID", serial)
+		}
+		if serial != sbomSerial(test.info, test.format) {
+			t.Errorf("the serial is not reproducible")
+		}
+		serials[serial] = true
+	}
+	if len(serials) != len(tests) {
+		t.Errorf("expected distinct serials, got %v", serials)
+	}
+}
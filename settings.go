@@ -0,0 +1,271 @@
+package make
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/urfave/cli.v1"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// SettingsFileName is the name of the project level file in
+	// the working directory CLIApp reads flag values from, e. g.
+	//
+	//	parallel: true
+	//	jobs: 4
+	//
+	// It is unrelated to the configuration files in
+	// DefaultConfigFiles.
+	SettingsFileName = ".gomake-flags.yml"
+	// SettingsEnvPrefix is the prefix of the environment variables
+	// CLIApp reads flag values from. The rest of the name is the
+	// upper case flag name with "-" replaced by "_", e. g.
+	// GOMAKE_PARALLEL or GOMAKE_DRY_RUN.
+	SettingsEnvPrefix = "GOMAKE_"
+)
+
+// cliSettings binds the flags of a cli.App to environment variables
+// and the settings file. Values given on the command line take
+// precedence over environment variables, which take precedence over
+// the settings file, which takes precedence over the defaults.
+// Flags with the same name share the variable and the setting, e. g.
+// GOMAKE_RELEASE applies to all commands with a --release flag.
+type cliSettings struct {
+	filename string
+	loaded   bool
+	values   map[string]string
+	// sources records where the values of the applied flags
+	// came from.
+	sources map[string]string
+	// warnings receives the warnings about unknown settings.
+	warnings io.Writer
+}
+
+func newCLISettings(filename string) *cliSettings {
+	return &cliSettings{
+		filename: filename,
+		values:   make(map[string]string),
+		sources:  make(map[string]string),
+		warnings: os.Stderr,
+	}
+}
+
+// settingsEnvVar returns the name of the environment variable
+// of the flag.
+func settingsEnvVar(name string) string {
+	return SettingsEnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// flagName returns the long name of the flag.
+func flagName(f cli.Flag) string {
+	return strings.TrimSpace(strings.Split(f.GetName(), ",")[0])
+}
+
+// flagDefault returns the default value of the flag.
+func flagDefault(f cli.Flag) string {
+	switch f := f.(type) {
+	case cli.StringFlag:
+		return f.Value
+	case cli.IntFlag:
+		return strconv.Itoa(f.Value)
+	case cli.BoolFlag:
+		return "false"
+	case cli.BoolTFlag:
+		return "true"
+	}
+	return ""
+}
+
+// withEnvUsage returns the flags with the environment variable
+// added to the usage, like the cli package does.
+func withEnvUsage(flags []cli.Flag) []cli.Flag {
+	ret := make([]cli.Flag, len(flags))
+	for i, f := range flags {
+		suffix := " [$" + settingsEnvVar(flagName(f)) + "]"
+		switch f := f.(type) {
+		case cli.StringFlag:
+			f.Usage += suffix
+			ret[i] = f
+		case cli.IntFlag:
+			f.Usage += suffix
+			ret[i] = f
+		case cli.BoolFlag:
+			f.Usage += suffix
+			ret[i] = f
+		case cli.BoolTFlag:
+			f.Usage += suffix
+			ret[i] = f
+		default:
+			ret[i] = f
+		}
+	}
+	return ret
+}
+
+// bind makes the global and command flags of the app read
+// their values from the environment and the settings file.
+func (s *cliSettings) bind(app *cli.App) {
+	app.Flags = withEnvUsage(app.Flags)
+	appBefore := app.Before
+	app.Before = func(c *cli.Context) error {
+		if err := s.load(app); err != nil {
+			return cli.NewExitError(err, -1)
+		}
+		if err := s.apply(c, app.Flags); err != nil {
+			return cli.NewExitError(err, -1)
+		}
+		if appBefore != nil {
+			return appBefore(c)
+		}
+		return nil
+	}
+
+	for i := range app.Commands {
+		command := &app.Commands[i]
+		command.Flags = withEnvUsage(command.Flags)
+		flags := command.Flags
+		before := command.Before
+		command.Before = func(c *cli.Context) error {
+			if err := s.apply(c, flags); err != nil {
+				return cli.NewExitError(err, -1)
+			}
+			if before != nil {
+				return before(c)
+			}
+			return nil
+		}
+	}
+}
+
+// load reads the settings file if it exists. Settings that do
+// not belong to a flag of the app are ignored with a warning, so
+// a stale file does not break commands like help.
+func (s *cliSettings) load(app *cli.App) error {
+	if s.loaded {
+		return nil
+	}
+	s.loaded = true
+
+	data, err := ioutil.ReadFile(s.filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	settings := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("invalid settings file %s: %v", s.filename, err)
+	}
+
+	known := make(map[string]bool)
+	for _, f := range app.Flags {
+		known[flagName(f)] = true
+	}
+	for _, command := range app.Commands {
+		for _, f := range command.Flags {
+			known[flagName(f)] = true
+		}
+	}
+
+	unknown := make([]string, 0)
+	for name, value := range settings {
+		if !known[name] {
+			unknown = append(unknown, name)
+			continue
+		}
+		switch value := value.(type) {
+		case nil:
+			continue
+		case []interface{}:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			s.values[name] = strings.Join(items, ",")
+		default:
+			s.values[name] = fmt.Sprint(value)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		fmt.Fprintf(s.warnings, "Warning: ignoring unknown setting \"%s\" in %s\n", name, s.filename)
+	}
+	return nil
+}
+
+// lookup returns the value of the flag from the environment
+// or the settings file and a description of the source.
+func (s *cliSettings) lookup(name string) (value, source string, ok bool) {
+	env := settingsEnvVar(name)
+	if value := os.Getenv(env); value != "" {
+		return value, "env " + env, true
+	}
+	if value, ok := s.values[name]; ok {
+		return value, "file " + s.filename, true
+	}
+	return "", "", false
+}
+
+// apply sets the flags that are not given on the command line.
+func (s *cliSettings) apply(c *cli.Context, flags []cli.Flag) error {
+	for _, f := range flags {
+		name := flagName(f)
+		if c.IsSet(name) {
+			s.sources[name] = "flag"
+			continue
+		}
+		value, source, ok := s.lookup(name)
+		if !ok {
+			s.sources[name] = "default"
+			continue
+		}
+		if err := c.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value \"%s\" of %s from %s: %v", value, name, source, err)
+		}
+		s.sources[name] = source
+	}
+	return nil
+}
+
+// print writes the effective values of all flags and their
+// sources. The global flags are taken from the context, the
+// flags of other commands are resolved without the command line.
+func (s *cliSettings) print(w io.Writer, c *cli.Context, app *cli.App) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tVALUE\tSOURCE")
+
+	printed := map[string]bool{
+		flagName(cli.HelpFlag):    true,
+		flagName(cli.VersionFlag): true,
+	}
+	for _, f := range app.Flags {
+		name := flagName(f)
+		if printed[name] {
+			continue
+		}
+		printed[name] = true
+		fmt.Fprintf(tw, "%s\t%v\t%s\n", name, c.GlobalGeneric(name), s.sources[name])
+	}
+	for _, command := range app.Commands {
+		for _, f := range command.Flags {
+			name := flagName(f)
+			if printed[name] {
+				continue
+			}
+			printed[name] = true
+			value, source, ok := s.lookup(name)
+			if !ok {
+				value, source = flagDefault(f), "default"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", name, value, source)
+		}
+	}
+	return tw.Flush()
+}
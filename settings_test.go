@@ -0,0 +1,225 @@
+package make
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+// runApp runs the app without exiting the process or writing
+// help texts and returns the error.
+func runApp(t *testing.T, app *cli.App, args ...string) error {
+	t.Helper()
+	exiter, errWriter := cli.OsExiter, cli.ErrWriter
+	cli.OsExiter = func(int) {}
+	cli.ErrWriter = ioutil.Discard
+	defer func() {
+		cli.OsExiter, cli.ErrWriter = exiter, errWriter
+	}()
+	app.Writer = ioutil.Discard
+	return app.Run(append([]string{"make"}, args...))
+}
+
+// settingsApp returns an app with a global jobs flag and a
+// build command recording the flag values.
+func settingsApp(values map[string]string) *cli.App {
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		cli.IntFlag{Name: "jobs, j"},
+	}
+	app.Commands = []cli.Command{{
+		Name: "build",
+		Flags: []cli.Flag{
+			cli.BoolFlag{Name: "release"},
+			cli.StringFlag{Name: "platforms"},
+			cli.StringFlag{Name: "os", Value: "native"},
+		},
+		Action: func(c *cli.Context) error {
+			values["jobs"] = fmt.Sprint(c.GlobalGeneric("jobs"))
+			for _, name := range []string{"release", "platforms", "os"} {
+				values[name] = fmt.Sprint(c.Generic(name))
+			}
+			return nil
+		},
+	}}
+	return app
+}
+
+func TestCLISettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		values  map[string]string
+		sources map[string]string
+		warning string
+		wantErr string
+	}{
+		{
+			name:    "defaults",
+			args:    []string{"build"},
+			values:  map[string]string{"jobs": "0", "release": "false", "platforms": "", "os": "native"},
+			sources: map[string]string{"jobs": "default", "release": "default", "os": "default"},
+		},
+		{
+			name:    "file",
+			file:    "jobs: 2\nrelease: true\nplatforms: [linux/amd64, windows/amd64]\n",
+			args:    []string{"build"},
+			values:  map[string]string{"jobs": "2", "release": "true", "platforms": "linux/amd64,windows/amd64", "os": "native"},
+			sources: map[string]string{"jobs": "file", "release": "file", "platforms": "file", "os": "default"},
+		},
+		{
+			name:    "env over file",
+			file:    "jobs: 2\nos: linux\n",
+			env:     map[string]string{"GOMAKE_JOBS": "3", "GOMAKE_RELEASE": "true"},
+			args:    []string{"build"},
+			values:  map[string]string{"jobs": "3", "release": "true", "os": "linux"},
+			sources: map[string]string{"jobs": "env GOMAKE_JOBS", "release": "env GOMAKE_RELEASE", "os": "file"},
+		},
+		{
+			name:    "flag over env",
+			file:    "jobs: 2\nos: linux\n",
+			env:     map[string]string{"GOMAKE_JOBS": "3", "GOMAKE_OS": "windows"},
+			args:    []string{"-j", "4", "build", "--os", "darwin"},
+			values:  map[string]string{"jobs": "4", "os": "darwin"},
+			sources: map[string]string{"jobs": "flag", "os": "flag"},
+		},
+		{
+			name:    "unknown setting",
+			file:    "job: 2\nos: linux\n",
+			args:    []string{"build"},
+			values:  map[string]string{"jobs": "0", "os": "linux"},
+			sources: map[string]string{"jobs": "default", "os": "file"},
+			warning: "ignoring unknown setting \"job\"",
+		},
+		{
+			name:    "help with unknown setting",
+			file:    "job: 2\n",
+			args:    []string{"help"},
+			warning: "ignoring unknown setting \"job\"",
+		},
+		{
+			name:    "invalid file",
+			file:    "jobs: [",
+			args:    []string{"build"},
+			wantErr: "invalid settings file",
+		},
+		{
+			name:    "invalid env value",
+			env:     map[string]string{"GOMAKE_JOBS": "many"},
+			args:    []string{"build"},
+			wantErr: "invalid value \"many\" of jobs from env GOMAKE_JOBS",
+		},
+		{
+			name:    "invalid file value",
+			file:    "release: maybe\n",
+			args:    []string{"build"},
+			wantErr: "invalid value \"maybe\" of release from file",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filename := filepath.Join(dir, SettingsFileName)
+			if test.file != "" {
+				writeFiles(t, dir, map[string]string{SettingsFileName: test.file})
+			}
+			for _, name := range []string{"GOMAKE_JOBS", "GOMAKE_RELEASE", "GOMAKE_PLATFORMS", "GOMAKE_OS"} {
+				t.Setenv(name, test.env[name])
+			}
+
+			values := make(map[string]string)
+			app := settingsApp(values)
+			settings := newCLISettings(filename)
+			warnings := &bytes.Buffer{}
+			settings.warnings = warnings
+			settings.bind(app)
+			err := runApp(t, app, test.args...)
+			checkError(t, err, test.wantErr)
+			if err != nil {
+				return
+			}
+			if !strings.Contains(warnings.String(), test.warning) || (test.warning == "") != (warnings.Len() == 0) {
+				t.Errorf("expected the warning %q, got %q", test.warning, warnings.String())
+			}
+
+			for name, value := range test.values {
+				if values[name] != value {
+					t.Errorf("%s: expected %q, got %q", name, value, values[name])
+				}
+			}
+			for name, source := range test.sources {
+				if source == "file" {
+					source = "file " + filename
+				}
+				if settings.sources[name] != source {
+					t.Errorf("%s: expected source %q, got %q", name, source, settings.sources[name])
+				}
+			}
+		})
+	}
+}
+
+func TestCLISettingsPrint(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, SettingsFileName)
+	writeFiles(t, dir, map[string]string{SettingsFileName: "os: linux\nplatforms: [linux/amd64]\n"})
+	t.Setenv("GOMAKE_JOBS", "")
+	t.Setenv("GOMAKE_RELEASE", "true")
+	t.Setenv("GOMAKE_OS", "")
+	t.Setenv("GOMAKE_PLATFORMS", "")
+
+	app := settingsApp(make(map[string]string))
+	settings := newCLISettings(filename)
+	output := &bytes.Buffer{}
+	app.Commands = append(app.Commands, cli.Command{
+		Name: "config",
+		Action: func(c *cli.Context) error {
+			return settings.print(output, c, app)
+		},
+	})
+	settings.bind(app)
+	if err := runApp(t, app, "--jobs", "2", "config"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"jobs":      {"2", "flag"},
+		"release":   {"true", "env GOMAKE_RELEASE"},
+		"platforms": {"linux/amd64", "file " + filename},
+		"os":        {"linux", "file " + filename},
+	}
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != len(expected)+1 {
+		t.Fatalf("expected a header and %d settings, got\n%s", len(expected), output)
+	}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		want, ok := expected[fields[0]]
+		if !ok || len(fields) < 3 || fields[1] != want[0] || strings.Join(fields[2:], " ") != want[1] {
+			t.Errorf("unexpected line %q", line)
+		}
+	}
+}
+
+func TestSettingsEnvVar(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "parallel", expected: "GOMAKE_PARALLEL"},
+		{name: "dry-run", expected: "GOMAKE_DRY_RUN"},
+	}
+	for _, test := range tests {
+		if env := settingsEnvVar(test.name); env != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, env)
+		}
+	}
+}
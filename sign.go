@@ -0,0 +1,540 @@
+package make
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// SignTargetNamePrefix is the prefix all SignTargets
+	// will have in theire name.
+	SignTargetNamePrefix = "sign_"
+	// VerifyTargetNamePrefix is the prefix all VerifyTargets
+	// will have in theire name.
+	VerifyTargetNamePrefix = "verify_"
+)
+
+// SignatureMethod represents the kind of key used for signing.
+type SignatureMethod string
+
+const (
+	// SignatureEd25519 signs with an ed25519 key. The key files are
+	// PEM encoded PKCS #8 private keys and PKIX public keys. The
+	// detached ".sig" files contain the base64 encoded Ed25519ph
+	// signature of the SHA-512 digest of the file, so files are
+	// streamed instead of being read into memory.
+	SignatureEd25519 SignatureMethod = "ed25519"
+	// SignatureOpenPGP signs with an OpenPGP key using gpg, version
+	// 2.1 or later, which has to be installed. The key files are
+	// armored or binary key rings, which are imported into a
+	// temporary home directory. The detached ".asc" files contain
+	// armored signatures.
+	SignatureOpenPGP SignatureMethod = "openpgp"
+)
+
+// signatureExtensions contains the extensions of the detached
+// signature files.
+var signatureExtensions = map[SignatureMethod]string{
+	SignatureEd25519: ".sig",
+	SignatureOpenPGP: ".asc",
+}
+
+// SignatureKey describes where a key is read from.
+type SignatureKey struct {
+	// File is the path of the key file.
+	File string
+	// Env is the name of an environment variable containing
+	// the key itself. It is preferred over the File if set.
+	Env string
+	// PassphraseEnv is the name of an environment variable
+	// containing the passphrase of an encrypted OpenPGP key.
+	PassphraseEnv string
+}
+
+func (k *SignatureKey) read() ([]byte, error) {
+	if k.Env != "" {
+		if key := os.Getenv(k.Env); key != "" {
+			return []byte(key), nil
+		}
+	}
+	if k.File == "" {
+		if k.Env != "" {
+			return nil, fmt.Errorf("environment variable %s is not set", k.Env)
+		}
+		return nil, fmt.Errorf("no key file or environment variable given")
+	}
+	return ioutil.ReadFile(k.File)
+}
+
+// SignTarget writes detached signatures for the outputs of the
+// Targets, which are executed as dependencies. If there are no
+// Targets all artifacts recorded by the Suite are signed. The
+// signatures are recorded as artifacts themselves.
+//
+// If the Suite derives CleanTargets the signatures are added to
+// them, so the SignTarget should be registered after the Targets.
+// Without Targets the signatures of the outputs of all registered
+// Targets are added.
+//
+// Directories, such as the layouts of ImageTargets, are skipped.
+type SignTarget struct {
+	Method  SignatureMethod
+	Key     SignatureKey
+	Targets []OutputTarget
+}
+
+// Execute executes the Targets and signs their outputs.
+func (t *SignTarget) Execute(suite *Suite) error {
+	deps := make([]Target, len(t.Targets))
+	for i, target := range t.Targets {
+		deps[i] = target
+	}
+	if err := suite.executeDependencies(deps); err != nil {
+		return err
+	}
+
+	sign, closeSigner, err := t.signer()
+	if err != nil {
+		return fmt.Errorf("could not read signing key: %v", err)
+	}
+	defer closeSigner()
+
+	for _, artifact := range t.artifacts(suite) {
+		info, err := os.Stat(artifact.Path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			fmt.Println("Skipping directory:", artifact.Path)
+			continue
+		}
+		filename := artifact.Path + signatureExtensions[t.Method]
+		fmt.Println("Signing:", artifact.Path)
+
+		signature, err := signFile(sign, artifact.Path)
+		if err != nil {
+			return fmt.Errorf("could not sign %s: %v", artifact.Path, err)
+		}
+		err = writeFileAtomically(filename, 0644, func(w io.Writer) error {
+			_, err := w.Write(signature)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		suite.AddArtifact(&Artifact{
+			Path:     filename,
+			Kind:     ArtifactSignature,
+			Platform: artifact.Platform,
+			Target:   t.Name(),
+		})
+	}
+	return nil
+}
+
+// signFile signs the content of the file without reading
+// it into memory.
+func signFile(sign func(content io.Reader) ([]byte, error), filename string) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return sign(file)
+}
+
+// artifacts returns the outputs of the Targets or all recorded
+// artifacts except signatures if there are no Targets.
+func (t *SignTarget) artifacts(suite *Suite) []*Artifact {
+	if len(t.Targets) == 0 {
+		artifacts := make([]*Artifact, 0)
+		for _, artifact := range suite.Artifacts() {
+			if artifact.Kind != ArtifactSignature {
+				artifacts = append(artifacts, artifact)
+			}
+		}
+		return artifacts
+	}
+
+	artifacts := make([]*Artifact, len(t.Targets))
+	for i, target := range t.Targets {
+		artifacts[i] = &Artifact{Path: target.OutputName()}
+		if pt, ok := target.(PlatformTarget); ok {
+			artifacts[i].Platform = pt.TargetPlatform()
+		}
+	}
+	return artifacts
+}
+
+// signer returns a function signing content and a function
+// releasing the resources of the signer.
+func (t *SignTarget) signer() (func(content io.Reader) ([]byte, error), func(), error) {
+	key, err := t.Key.read()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch t.Method {
+	case SignatureEd25519:
+		privateKey, err := parseEd25519PrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return func(content io.Reader) ([]byte, error) {
+			digest, err := sha512Digest(content)
+			if err != nil {
+				return nil, err
+			}
+			signature, err := privateKey.Sign(nil, digest, &ed25519.Options{Hash: crypto.SHA512})
+			if err != nil {
+				return nil, err
+			}
+			return []byte(base64.StdEncoding.EncodeToString(signature) + "\n"), nil
+		}, func() {}, nil
+
+	case SignatureOpenPGP:
+		keyRing, err := newGPGKeyRing(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := keyRing.checkSecretKey(); err != nil {
+			keyRing.Close()
+			return nil, nil, err
+		}
+		passphrase := os.Getenv(t.Key.PassphraseEnv)
+		return func(content io.Reader) ([]byte, error) {
+			return keyRing.sign(content, passphrase)
+		}, keyRing.Close, nil
+	}
+	return nil, nil, fmt.Errorf("invalid signature method \"%s\"", t.Method)
+}
+
+// sha512Digest returns the SHA-512 digest of the content, which
+// is signed with Ed25519ph.
+func sha512Digest(content io.Reader) ([]byte, error) {
+	hash := sha512.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+func parseEd25519PrivateKey(key []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(key)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("no PEM encoded private key found")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an ed25519 key")
+	}
+	return privateKey, nil
+}
+
+// parseEd25519PublicKey parses a public key or derives it from
+// a private key.
+func parseEd25519PublicKey(key []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded key found")
+	}
+	if block.Type == "PRIVATE KEY" {
+		privateKey, err := parseEd25519PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return privateKey.Public().(ed25519.PublicKey), nil
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not an ed25519 key")
+	}
+	return publicKey, nil
+}
+
+// gpgKeyRing is a temporary gpg home directory containing
+// the imported keys.
+type gpgKeyRing struct {
+	home string
+}
+
+// newGPGKeyRing imports the armored or binary keys into a new
+// temporary home directory.
+func newGPGKeyRing(key []byte) (*gpgKeyRing, error) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return nil, fmt.Errorf("OpenPGP signatures need gpg: %v", err)
+	}
+	home, err := ioutil.TempDir("", "go-make-gpg")
+	if err != nil {
+		return nil, err
+	}
+	k := &gpgKeyRing{home: home}
+	if _, err := k.gpg(bytes.NewReader(key), "--import"); err != nil {
+		k.Close()
+		return nil, fmt.Errorf("could not import key: %v", err)
+	}
+	return k, nil
+}
+
+// gpg runs gpg in the home directory and returns its stdout.
+// The stderr is part of the error.
+func (k *gpgKeyRing) gpg(stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command("gpg", append([]string{"--homedir", k.home, "--batch", "--no-tty"}, args...)...)
+	cmd.Stdin = stdin
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// checkSecretKey returns an error if no private key was imported.
+func (k *gpgKeyRing) checkSecretKey() error {
+	out, err := k.gpg(nil, "--list-secret-keys", "--with-colons")
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(out, []byte("sec:")) {
+		return fmt.Errorf("no private key found")
+	}
+	return nil
+}
+
+// sign returns the armored detached signature of the content made
+// with the first private key, which is unlocked by the passphrase
+// if it is encrypted.
+func (k *gpgKeyRing) sign(content io.Reader, passphrase string) ([]byte, error) {
+	passphraseFile := filepath.Join(k.home, "passphrase")
+	if err := ioutil.WriteFile(passphraseFile, []byte(passphrase), 0600); err != nil {
+		return nil, err
+	}
+	defer os.Remove(passphraseFile)
+
+	return k.gpg(content, "--pinentry-mode", "loopback", "--passphrase-file", passphraseFile,
+		"--armor", "--detach-sign", "--output", "-")
+}
+
+// verify checks the armored detached signature of the content.
+func (k *gpgKeyRing) verify(content io.Reader, signature []byte) error {
+	signatureFile, err := ioutil.TempFile(k.home, "signature")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(signatureFile.Name())
+	_, err = signatureFile.Write(signature)
+	if closeErr := signatureFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = k.gpg(content, "--verify", signatureFile.Name(), "-")
+	return err
+}
+
+// Close stops the gpg agent and removes the home directory.
+func (k *gpgKeyRing) Close() {
+	exec.Command("gpgconf", "--homedir", k.home, "--kill", "gpg-agent").Run()
+	os.RemoveAll(k.home)
+}
+
+// Validate returns an error if the signature method is unknown.
+func (t *SignTarget) Validate() error {
+	_, ok := signatureExtensions[t.Method]
+	if !ok {
+		return fmt.Errorf("invalid signature method \"%s\"", t.Method)
+	}
+	return nil
+}
+
+// derivedCleanTargets returns CleanTargets for the signatures
+// of the outputs of the Targets or, if there are none, of the
+// outputs of all Targets registered with the Suite. Directories
+// are not signed, so they are left out.
+func (t *SignTarget) derivedCleanTargets(suite *Suite) []*CleanTarget {
+	candidates := t.Targets
+	if len(candidates) == 0 {
+		for _, name := range suite.TargetNames() {
+			if target, ok := suite.Lookup(name).(NamedOutputTarget); ok {
+				candidates = append(candidates, target)
+			}
+		}
+	}
+	targets := make([]OutputTarget, 0, len(candidates))
+	for _, target := range candidates {
+		if dt, ok := target.(directoryOutputTarget); ok && dt.outputIsDirectory() {
+			continue
+		}
+		targets = append(targets, target)
+	}
+
+	cleans := CleanTargetsFromOutputTargets(targets...)
+	for _, clean := range cleans {
+		clean.Filename += signatureExtensions[t.Method]
+	}
+	return cleans
+}
+
+// Name returns the name of this Target.
+// The name will consist of the SignTargetNamePrefix
+// followed by the signature method.
+func (t *SignTarget) Name() string {
+	return SignTargetNamePrefix + string(t.Method)
+}
+
+// VerifyTarget verifies the detached signatures of the outputs
+// of the Targets. If there are no Targets the signatures recorded
+// as artifacts by the Suite are verified. The Targets are not
+// executed and directories are skipped.
+type VerifyTarget struct {
+	Method SignatureMethod
+	// Key is the public key. For ed25519 the private key
+	// can also be used.
+	Key     SignatureKey
+	Targets []OutputTarget
+}
+
+// Execute verifies the signatures.
+func (t *VerifyTarget) Execute(suite *Suite) error {
+	verify, closeVerifier, err := t.verifier()
+	if err != nil {
+		return fmt.Errorf("could not read verification key: %v", err)
+	}
+	defer closeVerifier()
+
+	errs := make([]error, 0)
+	for _, filename := range t.filenames(suite) {
+		info, err := os.Stat(filename)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if info.IsDir() {
+			fmt.Println("Skipping directory:", filename)
+			continue
+		}
+		fmt.Println("Verifying:", filename)
+
+		signature, err := ioutil.ReadFile(filename + signatureExtensions[t.Method])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := verifyFile(verify, filename, signature); err != nil {
+			errs = append(errs, fmt.Errorf("invalid signature of %s: %v", filename, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return &MultiError{Errors: errs}
+	}
+	return nil
+}
+
+// verifyFile verifies the signature of the file without reading
+// it into memory.
+func verifyFile(verify func(content io.Reader, signature []byte) error, filename string, signature []byte) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return verify(file, signature)
+}
+
+func (t *VerifyTarget) filenames(suite *Suite) []string {
+	filenames := make([]string, 0)
+	if len(t.Targets) == 0 {
+		for _, artifact := range suite.Artifacts() {
+			if artifact.Kind == ArtifactSignature && strings.HasSuffix(artifact.Path, signatureExtensions[t.Method]) {
+				filenames = append(filenames, strings.TrimSuffix(artifact.Path, signatureExtensions[t.Method]))
+			}
+		}
+		return filenames
+	}
+
+	for _, target := range t.Targets {
+		filenames = append(filenames, target.OutputName())
+	}
+	return filenames
+}
+
+// verifier returns a function verifying signatures and a
+// function releasing the resources of the verifier.
+func (t *VerifyTarget) verifier() (func(content io.Reader, signature []byte) error, func(), error) {
+	key, err := t.Key.read()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch t.Method {
+	case SignatureEd25519:
+		publicKey, err := parseEd25519PublicKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return func(content io.Reader, signature []byte) error {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+			if err != nil {
+				return err
+			}
+			digest, err := sha512Digest(content)
+			if err != nil {
+				return err
+			}
+			if ed25519.VerifyWithOptions(publicKey, digest, decoded, &ed25519.Options{Hash: crypto.SHA512}) != nil {
+				return fmt.Errorf("signature does not match")
+			}
+			return nil
+		}, func() {}, nil
+
+	case SignatureOpenPGP:
+		keyRing, err := newGPGKeyRing(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return keyRing.verify, keyRing.Close, nil
+	}
+	return nil, nil, fmt.Errorf("invalid signature method \"%s\"", t.Method)
+}
+
+// Validate returns an error if the signature method is unknown.
+func (t *VerifyTarget) Validate() error {
+	_, ok := signatureExtensions[t.Method]
+	if !ok {
+		return fmt.Errorf("invalid signature method \"%s\"", t.Method)
+	}
+	return nil
+}
+
+// Name returns the name of this Target.
+// The name will consist of the VerifyTargetNamePrefix
+// followed by the signature method.
+func (t *VerifyTarget) Name() string {
+	return VerifyTargetNamePrefix + string(t.Method)
+}
@@ -0,0 +1,241 @@
+package make
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fileTarget is a NamedOutputTarget whose output already exists.
+type fileTarget struct {
+	name   string
+	output string
+}
+
+func (t *fileTarget) Execute(suite *Suite) error { return nil }
+func (t *fileTarget) Name() string               { return t.name }
+func (t *fileTarget) OutputName() string         { return t.output }
+
+// ed25519Keys returns PEM encoded private and public keys.
+func ed25519Keys(t *testing.T) (string, string) {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+}
+
+// openPGPKeys returns an armored private key encrypted with
+// the passphrase and the armored public key.
+func openPGPKeys(t *testing.T, passphrase string) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	home := t.TempDir()
+	gpg := func(args ...string) string {
+		args = append([]string{"--homedir", home, "--batch", "--pinentry-mode", "loopback", "--passphrase", passphrase}, args...)
+		out, err := exec.Command("gpg", args...).Output()
+		if err != nil {
+			t.Fatalf("gpg %v: %v", args, err)
+		}
+		return string(out)
+	}
+	defer exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+
+	gpg("--quick-gen-key", "Release <release@example.com>", "ed25519", "sign", "never")
+	return gpg("--armor", "--export-secret-keys"), gpg("--armor", "--export")
+}
+
+func TestSignTarget(t *testing.T) {
+	ed25519Private, ed25519Public := ed25519Keys(t)
+	_, otherPublic := ed25519Keys(t)
+	pgpPrivate, pgpPublic := openPGPKeys(t, "secret")
+
+	tests := []struct {
+		name       string
+		method     SignatureMethod
+		signKey    string
+		passphrase string
+		verifyKey  string
+		tamper     bool
+		signErr    string
+		verifyErr  string
+	}{
+		{name: "ed25519", method: SignatureEd25519, signKey: ed25519Private, verifyKey: ed25519Public},
+		{name: "ed25519 private key", method: SignatureEd25519, signKey: ed25519Private, verifyKey: ed25519Private},
+		{name: "ed25519 other key", method: SignatureEd25519, signKey: ed25519Private, verifyKey: otherPublic, verifyErr: "signature does not match"},
+		{name: "ed25519 tampered", method: SignatureEd25519, signKey: ed25519Private, verifyKey: ed25519Public, tamper: true, verifyErr: "signature does not match"},
+		{name: "ed25519 public key", method: SignatureEd25519, signKey: ed25519Public, signErr: "no PEM encoded private key"},
+		{name: "openpgp", method: SignatureOpenPGP, signKey: pgpPrivate, passphrase: "secret", verifyKey: pgpPublic},
+		{name: "openpgp tampered", method: SignatureOpenPGP, signKey: pgpPrivate, passphrase: "secret", verifyKey: pgpPublic, tamper: true, verifyErr: "invalid signature"},
+		{name: "openpgp wrong passphrase", method: SignatureOpenPGP, signKey: pgpPrivate, passphrase: "wrong", signErr: "could not sign"},
+		{name: "openpgp public key", method: SignatureOpenPGP, signKey: pgpPublic, signErr: "no private key found"},
+		{name: "openpgp invalid key", method: SignatureOpenPGP, signKey: "not a key", signErr: "could not import key"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"app.tar.gz": "archive", "app.deb": "package"})
+			t.Setenv("SIGNING_KEY", test.signKey)
+			t.Setenv("SIGNING_PASSPHRASE", test.passphrase)
+			t.Setenv("VERIFY_KEY", test.verifyKey)
+
+			archive := &fileTarget{name: "archive", output: filepath.Join(dir, "app.tar.gz")}
+			suite := NewBuildSuite(nil)
+			suite.AddArtifact(&Artifact{Path: filepath.Join(dir, "app.deb"), Kind: ArtifactPackage})
+			sign := &SignTarget{
+				Method:  test.method,
+				Key:     SignatureKey{Env: "SIGNING_KEY", PassphraseEnv: "SIGNING_PASSPHRASE"},
+				Targets: []OutputTarget{archive},
+			}
+			err := suite.Execute(sign)
+			checkError(t, err, test.signErr)
+			if err != nil {
+				return
+			}
+
+			extension := signatureExtensions[test.method]
+			if files := listFiles(t, dir); !reflect.DeepEqual(files, []string{"app.deb", "app.tar.gz", "app.tar.gz" + extension}) {
+				t.Errorf("unexpected files %v", files)
+			}
+			if test.tamper {
+				writeFiles(t, dir, map[string]string{"app.tar.gz": "tampered"})
+			}
+
+			verify := &VerifyTarget{Method: test.method, Key: SignatureKey{Env: "VERIFY_KEY"}}
+			checkError(t, suite.Execute(verify), test.verifyErr)
+		})
+	}
+}
+
+func TestSignTargetAllArtifacts(t *testing.T) {
+	private, public := ed25519Keys(t)
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	if err := ioutil.WriteFile(keyFile, []byte(private), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"app.tar.gz": "archive", "app.deb": "package", "app_oci/index.json": "{}"})
+
+	suite := NewBuildSuite(nil)
+	for _, name := range []string{"app.tar.gz", "app.deb"} {
+		suite.AddArtifact(&Artifact{Path: filepath.Join(dir, name), Kind: ArtifactArchive, Target: name})
+	}
+	// Directories are skipped.
+	suite.AddArtifact(&Artifact{Path: filepath.Join(dir, "app_oci"), Kind: ArtifactArchive, Target: "image"})
+	sign := &SignTarget{Method: SignatureEd25519, Key: SignatureKey{File: keyFile}}
+	if err := suite.Execute(sign); err != nil {
+		t.Fatal(err)
+	}
+	// Signing again must not sign the signatures.
+	if err := suite.Execute(sign); err != nil {
+		t.Fatal(err)
+	}
+
+	if files := listFiles(t, dir); !reflect.DeepEqual(files, []string{"app.deb", "app.deb.sig", "app.tar.gz", "app.tar.gz.sig", "app_oci", "app_oci/index.json"}) {
+		t.Errorf("unexpected files %v", files)
+	}
+	t.Setenv("VERIFY_KEY", public)
+	if err := suite.Execute(&VerifyTarget{Method: SignatureEd25519, Key: SignatureKey{Env: "VERIFY_KEY"}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSignTargetDerivedCleanTargets(t *testing.T) {
+	binary := &BuildTarget{ExecutableName: DefaultNameTemplate("app"), Platform: LinuxAmd64}
+	image := &ImageTarget{ImageName: "app", Binaries: []*BuildTarget{binary}}
+	tests := []struct {
+		name     string
+		targets  []OutputTarget
+		patterns []string
+	}{
+		{
+			name:     "targets",
+			targets:  []OutputTarget{binary},
+			patterns: []string{"app_linux-amd64", "app_linux-amd64.asc"},
+		},
+		{
+			name:     "all artifacts",
+			patterns: []string{"app_linux-amd64", "app_linux-amd64.asc"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			suite := NewBuildSuite(PlatformSet{LinuxAmd64})
+			suite.RegisterTargets(binary, image, &SignTarget{Method: SignatureOpenPGP, Targets: test.targets})
+
+			clean, ok := suite.Lookup(CleanTargetNamePrefix + LinuxAmd64.String()).(*CleanTarget)
+			if !ok {
+				t.Fatal("no clean target derived")
+			}
+			if patterns := clean.patterns(); !reflect.DeepEqual(patterns, test.patterns) {
+				t.Errorf("expected %v, got %v", test.patterns, patterns)
+			}
+			if _, ok := suite.Lookup(CleanTargetNamePrefix + "app_oci.asc").(*CleanTarget); ok {
+				t.Errorf("signatures of directories must not be derived")
+			}
+		})
+	}
+}
+
+func TestSignTargetValidate(t *testing.T) {
+	tests := []struct {
+		method  SignatureMethod
+		wantErr string
+	}{
+		{method: SignatureEd25519},
+		{method: SignatureOpenPGP},
+		{method: "rsa", wantErr: "invalid signature method"},
+	}
+	for _, test := range tests {
+		checkError(t, (&SignTarget{Method: test.method}).Validate(), test.wantErr)
+		checkError(t, (&VerifyTarget{Method: test.method}).Validate(), test.wantErr)
+	}
+}
+
+func TestSignatureKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := ioutil.WriteFile(keyFile, []byte("from file"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SET_KEY", "from env")
+	t.Setenv("EMPTY_KEY", "")
+
+	tests := []struct {
+		key      SignatureKey
+		expected string
+		wantErr  string
+	}{
+		{key: SignatureKey{File: keyFile}, expected: "from file"},
+		{key: SignatureKey{Env: "SET_KEY", File: keyFile}, expected: "from env"},
+		{key: SignatureKey{Env: "EMPTY_KEY", File: keyFile}, expected: "from file"},
+		{key: SignatureKey{Env: "EMPTY_KEY"}, wantErr: "environment variable EMPTY_KEY is not set"},
+		{key: SignatureKey{}, wantErr: "no key file or environment variable given"},
+	}
+	for _, test := range tests {
+		key, err := test.key.read()
+		checkError(t, err, test.wantErr)
+		if string(key) != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, key)
+		}
+	}
+}
@@ -1,22 +1,102 @@
 package make
 
 import (
+	"bytes"
 	"fmt"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"text/template"
+
+	"gopkg.in/urfave/cli.v1"
 )
 
+// DefaultCacheDir is the default directory in which Targets
+// store caches and fingerprints.
+const DefaultCacheDir = ".gomake"
+
 // Suite represents the build suite of a product.
 type Suite struct {
 	SupportedPlatforms PlatformSet
+	// CacheDir is the directory in which Targets store caches
+	// and fingerprints between executions.
+	CacheDir string
+	// If AutoClean is true a CleanTarget is registered for each
+	// registered NamedOutputTarget. Outputs of the same Platform
+	// share one CleanTarget.
+	AutoClean bool
+
+	// OutputRoot is the directory in which Targets write their
+	// outputs, e. g. "dist". If empty the working directory is used.
+	// It must be set before registering Targets, as their output
+	// directories and CleanTargets are determined on registration.
+	OutputRoot string
+	// OutputLayout is the optional templated sub-directory of the
+	// OutputRoot. It will receive TemplateData as ".", e. g.
+	// "{{.Version}}/{{.OS}}_{{.Arch}}". Use NameTemplate to parse it.
+	// Like the OutputRoot it must be set before registering Targets.
+	OutputLayout *template.Template
 
 	registeredTargets map[string]Target
+	derivedCleans     map[string]*CleanTarget
+
+	// layoutUsed is true if the output directory of a registered
+	// Target was determined from registeredRoot and registeredLayout.
+	layoutUsed       bool
+	registeredRoot   string
+	registeredLayout *template.Template
+
+	// onceResults records the results of ExecuteOnce during the
+	// executions of Execute, which may be nested.
+	onceMutex   sync.Mutex
+	onceResults map[string]*onceResult
+	executions  int
+
+	artifactsMutex sync.Mutex
+	artifacts      []*Artifact
+
+	// commands and flags are added to the CLIApp,
+	// which records the flagValues.
+	commands   []*PlatformCommand
+	flags      []cli.Flag
+	flagsMutex sync.RWMutex
+	flagValues map[string]string
+}
+
+// outputDirTarget is a Target whose output directory
+// defaults to the one determined by the Suite.
+type outputDirTarget interface {
+	NamedOutputTarget
+
+	// setDefaultOutputDir sets the output directory used if
+	// the OutputDir of the Target is empty to the one determined
+	// by the suite.
+	setDefaultOutputDir(suite *Suite) error
+}
+
+// cleanDerivingTarget is a Target with multiple outputs
+// that are removed by derived CleanTargets.
+type cleanDerivingTarget interface {
+	NamedTarget
+
+	// derivedCleanTargets returns CleanTargets for the outputs.
+	derivedCleanTargets(suite *Suite) []*CleanTarget
+}
+
+type onceResult struct {
+	done chan struct{}
+	err  error
 }
 
 // NewBuildSuite creates a new Suite.
 func NewBuildSuite(supportedPlatforms PlatformSet) *Suite {
 	return &Suite{
 		SupportedPlatforms: supportedPlatforms,
+		CacheDir:           DefaultCacheDir,
+		AutoClean:          true,
 		registeredTargets:  make(map[string]Target),
+		derivedCleans:      make(map[string]*CleanTarget),
 	}
 }
 
@@ -29,23 +109,166 @@ func (s *Suite) CheckPlatform(p *Platform) error {
 	return nil
 }
 
+// CachePath returns the path of the file with the given name
+// inside the CacheDir.
+func (s *Suite) CachePath(name string) string {
+	return filepath.Join(s.CacheDir, name)
+}
+
 // Execute runs the given Target in the context of this build suite.
+// NamedTargets executed via ExecuteOnce during the execution, e. g.
+// shared dependencies, only run once. The next execution runs them
+// again.
 func (s *Suite) Execute(t Target) error {
+	if err := s.checkOutputLayout(); err != nil {
+		return err
+	}
+
+	s.onceMutex.Lock()
+	if s.executions == 0 {
+		s.onceResults = make(map[string]*onceResult)
+	}
+	s.executions++
+	s.onceMutex.Unlock()
+
+	defer func() {
+		s.onceMutex.Lock()
+		s.executions--
+		if s.executions == 0 {
+			s.onceResults = nil
+		}
+		s.onceMutex.Unlock()
+	}()
+
 	return t.Execute(s)
 }
 
+// checkOutputLayout returns an error if the OutputRoot or the
+// OutputLayout changed after registering Targets with them.
+func (s *Suite) checkOutputLayout() error {
+	if s.layoutUsed && (s.OutputRoot != s.registeredRoot || s.OutputLayout != s.registeredLayout) {
+		return fmt.Errorf("the output root or layout changed after targets were registered, set them before registering targets")
+	}
+	return nil
+}
+
+// OutputDir returns the directory in which outputs for the
+// given Platform and Version are written.
+func (s *Suite) OutputDir(p *Platform, v Version) (string, error) {
+	if s.OutputLayout == nil {
+		return s.OutputRoot, nil
+	}
+
+	buf := &bytes.Buffer{}
+	err := s.OutputLayout.Execute(buf, NewTemplateData(p, v, ""))
+	if err != nil {
+		return "", fmt.Errorf("invalid output layout: %v", err)
+	}
+	return filepath.Join(s.OutputRoot, buf.String()), nil
+}
+
 // RegisterTarget registers a NamedTarget that can later be
-// executed via ExecuteNamedTarget.
+// executed via ExecuteNamedTarget. It panics if the Target is
+// invalid, use RegisterTargetE to handle the error.
 func (s *Suite) RegisterTarget(target NamedTarget) {
+	if err := s.RegisterTargetE(target); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterTargetE registers a NamedTarget like RegisterTarget
+// but returns an error if the Target is invalid.
+func (s *Suite) RegisterTargetE(target NamedTarget) error {
+	if vt, ok := target.(ValidatingTarget); ok {
+		if err := vt.Validate(); err != nil {
+			return fmt.Errorf("invalid target: %v", err)
+		}
+	}
+	if ot, ok := target.(outputDirTarget); ok {
+		if err := s.checkOutputLayout(); err != nil {
+			return fmt.Errorf("could not register target %s: %v", target.Name(), err)
+		}
+		if err := ot.setDefaultOutputDir(s); err != nil {
+			return fmt.Errorf("could not register target %s: %v", target.Name(), err)
+		}
+		s.layoutUsed = true
+		s.registeredRoot = s.OutputRoot
+		s.registeredLayout = s.OutputLayout
+	}
+
 	s.registeredTargets[target.Name()] = target
+
+	if _, ok := target.(*CleanTarget); ok {
+		delete(s.derivedCleans, target.Name())
+		return nil
+	}
+	if !s.AutoClean {
+		return nil
+	}
+	if ot, ok := target.(NamedOutputTarget); ok {
+		s.deriveCleanTarget(CleanTargetsFromOutputTargets(ot)[0])
+	}
+	if ct, ok := target.(cleanDerivingTarget); ok {
+		for _, clean := range ct.derivedCleanTargets(s) {
+			s.deriveCleanTarget(clean)
+		}
+	}
+	return nil
+}
+
+// deriveCleanTarget registers the CleanTarget derived from an
+// output or adds its file to a previously derived one. The added
+// files are escaped, so they are matched literally. Directories
+// are added to the RecursivePatterns, so they are still removed
+// recursively.
+func (s *Suite) deriveCleanTarget(clean *CleanTarget) {
+	if derived, ok := s.derivedCleans[clean.Name()]; ok {
+		pattern := escapeGlob(clean.Filename)
+		if derived.Filename == clean.Filename {
+			return
+		}
+		for _, patterns := range [][]string{derived.Patterns, derived.RecursivePatterns} {
+			for _, existing := range patterns {
+				if existing == pattern {
+					return
+				}
+			}
+		}
+		if clean.Recursive && !derived.Recursive {
+			derived.RecursivePatterns = append(derived.RecursivePatterns, pattern)
+		} else {
+			derived.Patterns = append(derived.Patterns, pattern)
+		}
+		return
+	}
+	if _, ok := s.registeredTargets[clean.Name()]; ok {
+		// Do not replace manually registered CleanTargets.
+		return
+	}
+
+	s.registeredTargets[clean.Name()] = clean
+	s.derivedCleans[clean.Name()] = clean
 }
 
 // RegisterTargets registers NamedTargets that can later be
-// executed via ExecuteNamedTarget.
+// executed via ExecuteNamedTarget. It panics if a Target is
+// invalid, use RegisterTargetsE to handle the error.
 func (s *Suite) RegisterTargets(targets ...NamedTarget) {
+	if err := s.RegisterTargetsE(targets...); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterTargetsE registers NamedTargets like RegisterTargets
+// but returns an error if a Target is invalid. Registration
+// stops at the first error.
+func (s *Suite) RegisterTargetsE(targets ...NamedTarget) error {
 	for _, target := range targets {
-		s.RegisterTarget(target)
+		if err := s.RegisterTargetE(target); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // Lookup returns the previously registered target by name or
@@ -66,6 +289,17 @@ func (s *Suite) LookupPrefix(namePrefix string) []Target {
 	return ret
 }
 
+// TargetNames returns the names of all registered Targets
+// in alphabetical order.
+func (s *Suite) TargetNames() []string {
+	names := make([]string, 0, len(s.registeredTargets))
+	for name := range s.registeredTargets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // LookupBuildTargets returns all registerd build targets.
 func (s *Suite) LookupBuildTargets() []Target {
 	return s.LookupPrefix(BuildTargetNamePrefix)
@@ -76,6 +310,15 @@ func (s *Suite) LookupCleanTargets() []Target {
 	return s.LookupPrefix(CleanTargetNamePrefix)
 }
 
+// CacheCleanTarget returns a CleanTarget removing the CacheDir
+// including all caches and fingerprints created by Targets.
+func (s *Suite) CacheCleanTarget() *CleanTarget {
+	return &CleanTarget{
+		Filename:  s.CacheDir,
+		Recursive: true,
+	}
+}
+
 type targetNotFoundError struct {
 	name string
 }
@@ -99,5 +342,66 @@ func (s *Suite) ExecuteNamedTarget(targetName string) error {
 	if target == nil {
 		return &targetNotFoundError{name: targetName}
 	}
-	return target.Execute(s)
+	return s.Execute(target)
+}
+
+// ExecuteOnce executes the given NamedTarget unless a Target with
+// the same name has already been executed during the current
+// Execute call of this suite, in which case the error of the
+// first execution is returned. Concurrent calls wait for the
+// first execution to finish. Outside of Execute the Target is
+// always executed.
+func (s *Suite) ExecuteOnce(target NamedTarget) error {
+	s.onceMutex.Lock()
+	if s.onceResults == nil {
+		s.onceMutex.Unlock()
+		return target.Execute(s)
+	}
+	result, ok := s.onceResults[target.Name()]
+	if !ok {
+		result = &onceResult{done: make(chan struct{})}
+		s.onceResults[target.Name()] = result
+	}
+	s.onceMutex.Unlock()
+
+	if ok {
+		<-result.done
+		return result.err
+	}
+
+	result.err = target.Execute(s)
+	close(result.done)
+	return result.err
+}
+
+// executeDependencies executes the given Targets sequentially
+// and aborts on the first error. NamedTargets are executed via
+// ExecuteOnce, so shared dependencies only run once.
+func (s *Suite) executeDependencies(dependencies []Target) error {
+	for _, dep := range dependencies {
+		var err error
+		if named, ok := dep.(NamedTarget); ok {
+			err = s.ExecuteOnce(named)
+		} else {
+			err = dep.Execute(s)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type onceTarget struct {
+	NamedTarget
+}
+
+// Once returns a Target that executes the given NamedTarget
+// via ExecuteOnce.
+func Once(target NamedTarget) NamedTarget {
+	return &onceTarget{NamedTarget: target}
+}
+
+func (t *onceTarget) Execute(suite *Suite) error {
+	return suite.ExecuteOnce(t.NamedTarget)
 }
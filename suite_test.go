@@ -0,0 +1,320 @@
+package make
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// countingTarget is a NamedTarget counting its executions.
+type countingTarget struct {
+	name  string
+	mutex sync.Mutex
+	count int
+	err   error
+}
+
+func (t *countingTarget) Execute(suite *Suite) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.count++
+	return t.err
+}
+
+func (t *countingTarget) Name() string {
+	return t.name
+}
+
+func TestSuiteExecuteOnce(t *testing.T) {
+	tests := []struct {
+		name  string
+		run   func(suite *Suite, dep Target) error
+		count int
+	}{
+		{
+			name: "shared dependency",
+			run: func(suite *Suite, dep Target) error {
+				return suite.Execute(Concatenate(true,
+					NewFuncTarget("a", "", nil, dep),
+					NewFuncTarget("b", "", nil, dep),
+				))
+			},
+			count: 1,
+		},
+		{
+			name: "parallel dependents",
+			run: func(suite *Suite, dep Target) error {
+				targets := make([]Target, 10)
+				for i := range targets {
+					targets[i] = NewFuncTarget(fmt.Sprint(i), "", nil, dep)
+				}
+				return suite.Execute(Parallelize(targets...))
+			},
+			count: 1,
+		},
+		{
+			name: "consecutive executions",
+			run: func(suite *Suite, dep Target) error {
+				if err := suite.Execute(NewFuncTarget("a", "", nil, dep)); err != nil {
+					return err
+				}
+				return suite.Execute(NewFuncTarget("b", "", nil, dep))
+			},
+			count: 2,
+		},
+		{
+			name: "nested execution",
+			run: func(suite *Suite, dep Target) error {
+				return suite.Execute(NewFuncTarget("a", "", func(suite *Suite) error {
+					return suite.Execute(NewFuncTarget("b", "", nil, dep))
+				}, dep))
+			},
+			count: 1,
+		},
+		{
+			name: "outside of execute",
+			run: func(suite *Suite, dep Target) error {
+				if err := NewFuncTarget("a", "", nil, dep).Execute(suite); err != nil {
+					return err
+				}
+				return NewFuncTarget("b", "", nil, dep).Execute(suite)
+			},
+			count: 2,
+		},
+		{
+			name: "named target",
+			run: func(suite *Suite, dep Target) error {
+				suite.RegisterTarget(NewFuncTarget("all", "", nil, dep, dep))
+				if err := suite.ExecuteNamedTarget("all"); err != nil {
+					return err
+				}
+				return suite.ExecuteNamedTarget("all")
+			},
+			count: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dep := &countingTarget{name: "dep"}
+			if err := test.run(NewBuildSuite(nil), dep); err != nil {
+				t.Fatal(err)
+			}
+			if dep.count != test.count {
+				t.Errorf("expected %d executions, got %d", test.count, dep.count)
+			}
+		})
+	}
+}
+
+func TestSuiteExecuteOnceError(t *testing.T) {
+	dep := &countingTarget{name: "dep", err: fmt.Errorf("dep failed")}
+	suite := NewBuildSuite(nil)
+
+	err := suite.Execute(Concatenate(false,
+		NewFuncTarget("a", "", nil, dep),
+		NewFuncTarget("b", "", nil, dep),
+	))
+	multi, ok := err.(*MultiError)
+	if !ok || len(multi.Errors) != 2 {
+		t.Fatalf("expected the error for both dependents, got %v", err)
+	}
+	if dep.count != 1 {
+		t.Errorf("expected 1 execution, got %d", dep.count)
+	}
+
+	// A later execution does not return the stale error.
+	dep.err = nil
+	if err := suite.Execute(NewFuncTarget("c", "", nil, dep)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSuiteTargetNames(t *testing.T) {
+	suite := NewBuildSuite(nil)
+	suite.AutoClean = false
+	suite.RegisterTargets(
+		&countingTarget{name: "b"},
+		&countingTarget{name: "a"},
+		&countingTarget{name: "build_x"},
+	)
+
+	if names := suite.TargetNames(); !reflect.DeepEqual(names, []string{"a", "b", "build_x"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+	if targets := suite.LookupBuildTargets(); len(targets) != 1 {
+		t.Errorf("expected 1 build target, got %v", targets)
+	}
+	err := suite.ExecuteNamedTarget("missing")
+	if !IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+// directoryTarget is a NamedOutputTarget whose output
+// is a directory.
+type directoryTarget struct {
+	name     string
+	output   string
+	platform *Platform
+}
+
+func (t *directoryTarget) Execute(suite *Suite) error { return nil }
+func (t *directoryTarget) Name() string               { return t.name }
+func (t *directoryTarget) OutputName() string         { return t.output }
+func (t *directoryTarget) TargetPlatform() *Platform  { return t.platform }
+func (t *directoryTarget) outputIsDirectory() bool    { return true }
+
+func TestSuiteDerivedCleanTargets(t *testing.T) {
+	tests := []struct {
+		name      string
+		targets   []NamedTarget
+		remaining []string
+	}{
+		{
+			name: "file then directory",
+			targets: []NamedTarget{
+				&BuildTarget{ExecutableName: DefaultNameTemplate("app"), Platform: LinuxAmd64},
+				&directoryTarget{name: "image_linux", output: "image", platform: LinuxAmd64},
+			},
+			remaining: []string{"go.mod", "other"},
+		},
+		{
+			name: "directory then file",
+			targets: []NamedTarget{
+				&directoryTarget{name: "image_linux", output: "image", platform: LinuxAmd64},
+				&BuildTarget{ExecutableName: DefaultNameTemplate("app"), Platform: LinuxAmd64},
+			},
+			remaining: []string{"go.mod", "other"},
+		},
+		{
+			name: "other platform",
+			targets: []NamedTarget{
+				&BuildTarget{ExecutableName: DefaultNameTemplate("app"), Platform: LinuxAmd64},
+				&directoryTarget{name: "image_windows", output: "image", platform: WindowsAmd64},
+			},
+			remaining: []string{"go.mod", "image", "image/blobs", "image/blobs/layer", "other"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":            "module example.com/clean\n",
+				"app_linux-amd64":   "binary",
+				"image/blobs/layer": "layer",
+				"other":             "other",
+			})
+			chdir(t, dir)
+
+			suite := NewBuildSuite(PlatformSet{LinuxAmd64, WindowsAmd64})
+			for _, target := range test.targets {
+				if err := suite.RegisterTargetE(target); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := suite.ExecuteNamedTarget(CleanTargetNamePrefix + LinuxAmd64.String()); err != nil {
+				t.Fatal(err)
+			}
+			if files := listFiles(t, dir); !reflect.DeepEqual(files, test.remaining) {
+				t.Errorf("expected %v to remain, got %v", test.remaining, files)
+			}
+		})
+	}
+}
+
+func TestSuiteOutputDir(t *testing.T) {
+	tests := []struct {
+		root    string
+		layout  string
+		dir     string
+		wantErr string
+	}{
+		{root: "", layout: "", dir: ""},
+		{root: "dist", layout: "", dir: "dist"},
+		{root: "dist", layout: "{{.Version}}/{{.OS}}_{{.Arch}}", dir: filepath.Join("dist", "1.2.3", "linux_amd64")},
+		{root: "", layout: "v{{major .Version}}", dir: "v1"},
+		{root: "dist", layout: "{{.Missing}}", wantErr: "invalid output layout"},
+	}
+	for _, test := range tests {
+		suite := NewBuildSuite(nil)
+		suite.OutputRoot = test.root
+		if test.layout != "" {
+			var err error
+			suite.OutputLayout, err = NameTemplate(test.layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		dir, err := suite.OutputDir(LinuxAmd64, BasicVersion("1.2.3"))
+		checkError(t, err, test.wantErr)
+		if dir != test.dir {
+			t.Errorf("%s: expected %q, got %q", test.layout, test.dir, dir)
+		}
+	}
+}
+
+func TestSuiteOutputLayoutRegistration(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(suite *Suite)
+		wantErr string
+	}{
+		{name: "unchanged", change: func(suite *Suite) {}},
+		{name: "output root", change: func(suite *Suite) { suite.OutputRoot = "out" }, wantErr: "changed after targets were registered"},
+		{name: "output layout", change: func(suite *Suite) { suite.OutputLayout, _ = NameTemplate("{{.OS}}") }, wantErr: "changed after targets were registered"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := &BuildTarget{ExecutableName: DefaultNameTemplate("app"), Platform: LinuxAmd64}
+			suite := NewBuildSuite(nil)
+			suite.OutputRoot = "dist"
+			if err := suite.RegisterTargetE(target); err != nil {
+				t.Fatal(err)
+			}
+			if target.OutputDir != "" {
+				t.Errorf("the OutputDir of the target must not be changed, got %q", target.OutputDir)
+			}
+			if name := target.OutputName(); filepath.Dir(name) != "dist" {
+				t.Errorf("expected the output in the output root, got %s", name)
+			}
+
+			test.change(suite)
+			checkError(t, suite.Execute(&countingTarget{name: "other"}), test.wantErr)
+			checkError(t, suite.RegisterTargetE(target.Copy()), test.wantErr)
+
+			// The copy is registered with another layout.
+			other := NewBuildSuite(nil)
+			other.OutputRoot = "other"
+			copy := target.Copy()
+			if err := other.RegisterTargetE(copy); err != nil {
+				t.Fatal(err)
+			}
+			if name := copy.OutputName(); filepath.Dir(name) != "other" {
+				t.Errorf("expected the output in the new output root, got %s", name)
+			}
+		})
+	}
+}
+
+func TestSuiteRegisterTargetValidation(t *testing.T) {
+	invalid := &BuildTarget{ExecutableName: DefaultNameTemplate("app")}
+
+	suite := NewBuildSuite(nil)
+	checkError(t, suite.RegisterTargetE(invalid), "has no platform")
+	checkError(t, suite.RegisterTargetsE(&countingTarget{name: "ok"}, invalid), "has no platform")
+	if suite.Lookup("ok") == nil {
+		t.Errorf("targets before the invalid one must be registered")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected RegisterTarget to panic")
+		}
+	}()
+	suite.RegisterTarget(invalid)
+}
@@ -34,3 +34,28 @@ type NamedOutputTarget interface {
 	// Name returns the name of the Target.
 	Name() string
 }
+
+// DescribedTarget is a Target with a human readable description.
+type DescribedTarget interface {
+	Target
+
+	// Description returns a short description of the Target.
+	Description() string
+}
+
+// PlatformTarget is a Target built for a specific Platform.
+type PlatformTarget interface {
+	Target
+
+	// TargetPlatform returns the Platform of the Target.
+	TargetPlatform() *Platform
+}
+
+// ValidatingTarget is a Target that can check its configuration.
+// The Suite validates such Targets when they are registered.
+type ValidatingTarget interface {
+	Target
+
+	// Validate returns an error if the Target is misconfigured.
+	Validate() error
+}
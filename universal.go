@@ -0,0 +1,262 @@
+package make
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+const (
+	fatMagic = 0xcafebabe
+
+	// Alignments of the architectures inside a universal
+	// binary as powers of two, equal to the ones used by lipo.
+	fatAlignArm64   = 14
+	fatAlignDefault = 12
+)
+
+// UniversalBinaryTarget merges the Mach-O executables of multiple
+// darwin BuildTargets into one universal (fat) binary. The
+// BuildTargets are executed as dependencies.
+type UniversalBinaryTarget struct {
+	// ExecutableName defines the templated name of the resulting
+	// executable. It will receive TemplateData of the
+	// DarwinUniversal platform as ".".
+	ExecutableName *template.Template
+	// BaseName is the optional base name of the executable
+	// passed to the ExecutableName template.
+	BaseName string
+	// Binary distinguishes the UniversalBinaryTargets of multiple
+	// executables. If set it is part of the name.
+	Binary  string
+	Version Version
+	// OutputDir is the directory the executable is written to.
+	// If empty it defaults to the output directory of the Suite
+	// for the DarwinUniversal platform.
+	OutputDir string
+
+	// Inputs are the darwin BuildTargets whose outputs are merged.
+	Inputs []*BuildTarget
+
+	// defaultOutputDir is the output directory of the Suite
+	// the target is registered with.
+	defaultOutputDir string
+}
+
+// NewUniversalBinaryTarget creates a UniversalBinaryTarget from
+// all darwin BuildTargets in the given slice, e. g. as returned
+// by MultiPlatformBuild. The name template, base name, binary and
+// version are taken from the first darwin BuildTarget.
+func NewUniversalBinaryTarget(buildTargets []*BuildTarget) *UniversalBinaryTarget {
+	t := &UniversalBinaryTarget{}
+	for _, bt := range buildTargets {
+		if bt.Platform == nil || bt.Platform.OS != Darwin {
+			continue
+		}
+		if len(t.Inputs) == 0 {
+			t.ExecutableName = bt.ExecutableName
+			t.BaseName = bt.BaseName
+			t.Binary = bt.Binary
+			t.Version = bt.Version
+		}
+		t.Inputs = append(t.Inputs, bt)
+	}
+	return t
+}
+
+// Execute builds the inputs and merges them.
+func (t *UniversalBinaryTarget) Execute(suite *Suite) error {
+	deps := make([]Target, len(t.Inputs))
+	for i, input := range t.Inputs {
+		deps[i] = input
+	}
+	if err := suite.executeDependencies(deps); err != nil {
+		return err
+	}
+
+	executableName, err := t.OutputPath()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Merging universal binary:", executableName)
+	err = writeFileAtomically(executableName, 0755, func(w io.Writer) error {
+		return t.merge(w)
+	})
+	if err != nil {
+		return fmt.Errorf("could not create universal binary: %v", err)
+	}
+
+	suite.AddArtifact(&Artifact{
+		Path:     executableName,
+		Kind:     ArtifactExecutable,
+		Platform: DarwinUniversal,
+		Target:   t.Name(),
+	})
+	return nil
+}
+
+type fatArch struct {
+	cpu    macho.Cpu
+	subCpu uint32
+	offset uint32
+	size   uint32
+	align  uint32
+	file   string
+}
+
+func (t *UniversalBinaryTarget) merge(w io.Writer) error {
+	archs := make([]*fatArch, len(t.Inputs))
+	for i, input := range t.Inputs {
+		filename, err := input.OutputPath()
+		if err != nil {
+			return err
+		}
+		arch, err := readFatArch(filename)
+		if err != nil {
+			return err
+		}
+		archs[i] = arch
+	}
+
+	const headerSize, archSize = 8, 20
+	offset := uint32(headerSize + archSize*len(archs))
+	for _, arch := range archs {
+		alignment := uint32(1) << arch.align
+		offset = (offset + alignment - 1) &^ (alignment - 1)
+		arch.offset = offset
+		offset += arch.size
+	}
+
+	header := &bytes.Buffer{}
+	binary.Write(header, binary.BigEndian, []uint32{fatMagic, uint32(len(archs))})
+	for _, arch := range archs {
+		binary.Write(header, binary.BigEndian, []uint32{uint32(arch.cpu), arch.subCpu, arch.offset, arch.size, arch.align})
+	}
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	written := uint32(header.Len())
+	for _, arch := range archs {
+		if _, err := w.Write(make([]byte, arch.offset-written)); err != nil {
+			return err
+		}
+		if err := copyFile(w, arch.file); err != nil {
+			return err
+		}
+		written = arch.offset + arch.size
+	}
+	return nil
+}
+
+func readFatArch(filename string) (*fatArch, error) {
+	f, err := macho.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a Mach-O file: %v", filename, err)
+	}
+	f.Close()
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	arch := &fatArch{
+		cpu:    f.Cpu,
+		subCpu: f.SubCpu,
+		size:   uint32(info.Size()),
+		align:  fatAlignDefault,
+		file:   filename,
+	}
+	if f.Cpu == macho.CpuArm64 {
+		arch.align = fatAlignArm64
+	}
+	return arch, nil
+}
+
+func copyFile(w io.Writer, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// OutputName returns the name of the output file including
+// the OutputDir. If the ExecutableName template is broken an
+// empty string is returned, use OutputPath to get the error.
+func (t *UniversalBinaryTarget) OutputName() string {
+	name, _ := t.OutputPath()
+	return name
+}
+
+// OutputPath returns the name of the output file including
+// the OutputDir or an error if the ExecutableName template
+// could not be executed.
+func (t *UniversalBinaryTarget) OutputPath() (string, error) {
+	if t.ExecutableName == nil {
+		return "", fmt.Errorf("no executable name template")
+	}
+
+	buf := &bytes.Buffer{}
+	err := t.ExecutableName.Execute(buf, NewTemplateData(DarwinUniversal, t.Version, t.BaseName))
+	if err != nil {
+		return "", fmt.Errorf("invalid executable name: %v", err)
+	}
+	return filepath.Join(t.outputDir(), buf.String()), nil
+}
+
+// Validate returns an error if there are less than two darwin
+// inputs or the ExecutableName template is broken.
+func (t *UniversalBinaryTarget) Validate() error {
+	if len(t.Inputs) < 2 {
+		return fmt.Errorf("universal binary needs at least two inputs")
+	}
+	for _, input := range t.Inputs {
+		if input.Platform == nil {
+			return fmt.Errorf("universal binary input has no platform")
+		}
+		if input.Platform.OS != Darwin {
+			return fmt.Errorf("universal binary input %s is not a darwin target", input.Name())
+		}
+	}
+	_, err := t.OutputPath()
+	return err
+}
+
+func (t *UniversalBinaryTarget) setDefaultOutputDir(suite *Suite) error {
+	var err error
+	t.defaultOutputDir, err = suite.OutputDir(DarwinUniversal, t.Version)
+	return err
+}
+
+// outputDir returns the OutputDir or, if it is empty, the
+// output directory of the Suite.
+func (t *UniversalBinaryTarget) outputDir() string {
+	if t.OutputDir != "" {
+		return t.OutputDir
+	}
+	return t.defaultOutputDir
+}
+
+// TargetPlatform returns DarwinUniversal.
+func (t *UniversalBinaryTarget) TargetPlatform() *Platform {
+	return DarwinUniversal
+}
+
+// Name returns the name of this Target.
+func (t *UniversalBinaryTarget) Name() string {
+	if t.Binary != "" {
+		return BuildTargetNamePrefix + t.Binary + "_" + DarwinUniversal.String()
+	}
+	return BuildTargetNamePrefix + DarwinUniversal.String()
+}
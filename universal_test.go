@@ -0,0 +1,114 @@
+package make
+
+import (
+	"debug/macho"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUniversalBinaryTarget(t *testing.T) {
+	dir := t.TempDir()
+	writeMainModule(t, dir)
+	chdir(t, dir)
+
+	suite := NewBuildSuite(SupportedPlatforms)
+	suite.OutputRoot = "dist"
+	builds := MultiPlatformBuild(&BuildTarget{ExecutableName: DefaultNameTemplate("app"), Version: BasicVersion("1.2.3")}, PlatformSet{LinuxAmd64, DarwinAmd64, DarwinArm64})
+	target := NewUniversalBinaryTarget(builds)
+	if len(target.Inputs) != 2 {
+		t.Fatalf("expected the darwin targets as inputs, got %d", len(target.Inputs))
+	}
+	if err := suite.RegisterTargetE(target); err != nil {
+		t.Fatal(err)
+	}
+	if err := suite.ExecuteNamedTarget(BuildTargetNamePrefix + "darwin_universal"); err != nil {
+		t.Fatal(err)
+	}
+
+	executable := filepath.Join("dist", "app_darwin-universal")
+	fat, err := macho.OpenFat(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fat.Close()
+
+	cpus := make([]macho.Cpu, len(fat.Arches))
+	for i, arch := range fat.Arches {
+		cpus[i] = arch.Cpu
+		if arch.Offset%(1<<arch.Align) != 0 {
+			t.Errorf("%s is not aligned to 2^%d", arch.Cpu, arch.Align)
+		}
+		input, err := ioutil.ReadFile(target.Inputs[i].OutputName())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(arch.Size) != len(input) {
+			t.Errorf("%s: expected size %d, got %d", arch.Cpu, len(input), arch.Size)
+		}
+	}
+	if !reflect.DeepEqual(cpus, []macho.Cpu{macho.CpuAmd64, macho.CpuArm64}) {
+		t.Errorf("unexpected architectures %v", cpus)
+	}
+	if fat.Arches[1].Align != fatAlignArm64 {
+		t.Errorf("expected arm64 to be aligned to 2^%d, got 2^%d", fatAlignArm64, fat.Arches[1].Align)
+	}
+
+	artifacts := suite.Artifacts()
+	if last := artifacts[len(artifacts)-1]; last.Path != executable || last.Platform != DarwinUniversal {
+		t.Errorf("unexpected artifact %v", last)
+	}
+}
+
+func TestUniversalBinaryTargetNotMachO(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"app_darwin-amd64": "not a binary",
+		"app_darwin-arm64": "not a binary",
+	})
+
+	target := NewUniversalBinaryTarget(MultiPlatformBuild(&BuildTarget{ExecutableName: DefaultNameTemplate("app")}, PlatformSet{DarwinAmd64, DarwinArm64}))
+	for _, input := range target.Inputs {
+		input.OutputDir = dir
+	}
+	err := target.merge(ioutil.Discard)
+	checkError(t, err, "is not a Mach-O file")
+}
+
+func TestUniversalBinaryTargetValidate(t *testing.T) {
+	darwin := MultiPlatformBuild(&BuildTarget{ExecutableName: DefaultNameTemplate("app")}, PlatformSet{DarwinAmd64, DarwinArm64})
+	tests := []struct {
+		name    string
+		target  *UniversalBinaryTarget
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			target: NewUniversalBinaryTarget(darwin),
+		},
+		{
+			name:    "single input",
+			target:  NewUniversalBinaryTarget(darwin[:1]),
+			wantErr: "needs at least two inputs",
+		},
+		{
+			name:    "linux input",
+			target:  &UniversalBinaryTarget{ExecutableName: DefaultNameTemplate("app"), Inputs: append(darwin[:1:1], &BuildTarget{Platform: LinuxAmd64})},
+			wantErr: "is not a darwin target",
+		},
+		{
+			name:    "no platform",
+			target:  &UniversalBinaryTarget{ExecutableName: DefaultNameTemplate("app"), Inputs: append(darwin[:1:1], &BuildTarget{})},
+			wantErr: "has no platform",
+		},
+		{
+			name:    "no name",
+			target:  &UniversalBinaryTarget{Inputs: darwin},
+			wantErr: "no executable name template",
+		},
+	}
+	for _, test := range tests {
+		checkError(t, test.target.Validate(), test.wantErr)
+	}
+}
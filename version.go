@@ -1,13 +1,11 @@
 package make
 
 import (
+	"bytes"
 	"fmt"
 	"log"
-	"os"
 	"os/exec"
 	"strings"
-
-	"github.com/hashicorp/go-version"
 )
 
 // Version holds information about the version.
@@ -24,20 +22,31 @@ func (v BasicVersion) String() string {
 
 // VersionFromGit returns a version from the git repository
 // in the given path. This is either the tag name if present
-// or short commit hash if not.
+// or short commit hash if not. The program exits if the
+// version can not be determined, use GitVersion to get
+// the error instead.
 func VersionFromGit(path string) Version {
-	cmd := exec.Command("git", "describe", "--tags", "--always")
-	cmd.Dir = path
-	cmd.Stderr = os.Stderr
-	out, err := cmd.Output()
+	v, err := GitVersion(path)
 	if err != nil {
 		log.Fatalf("Error running git describe: %v", err)
 	}
-	versionString := strings.TrimSpace(string(out))
+	return v
+}
 
-	version, err := version.NewVersion(versionString)
+// GitVersion returns a version from the git repository in the
+// given path like VersionFromGit or an error if there is no
+// repository or commit.
+func GitVersion(path string) (Version, error) {
+	cmd := exec.Command("git", "describe", "--tags", "--always")
+	cmd.Dir = path
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
 	if err != nil {
-		return version
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%v: %s", err, message)
+		}
+		return nil, err
 	}
-	return BasicVersion(versionString)
+	return BasicVersion(strings.TrimSpace(string(out))), nil
 }
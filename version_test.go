@@ -0,0 +1,110 @@
+package make
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// git runs git in dir with a fixed identity and fails the
+// test on errors.
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com", "-c", "commit.gpgsign=false", "-c", "tag.gpgsign=false"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+// initGitRepo creates a git repository in dir with one commit
+// containing the files.
+func initGitRepo(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	writeFiles(t, dir, files)
+	git(t, dir, "init", "-q")
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "-m", "initial commit")
+}
+
+func TestVersionFromGit(t *testing.T) {
+	tests := []struct {
+		name    string
+		tag     string
+		version string
+	}{
+		{name: "semantic tag", tag: "v1.2.3", version: "v1.2.3"},
+		{name: "pre-release tag", tag: "v2.0.0-rc.1", version: "v2.0.0-rc.1"},
+		{name: "tag without prefix", tag: "1.2.3", version: "1.2.3"},
+		{name: "other tag", tag: "release-a", version: "release-a"},
+		{name: "no tag"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			initGitRepo(t, dir, map[string]string{"README": "readme"})
+			expected := test.version
+			if test.tag != "" {
+				git(t, dir, "tag", test.tag)
+			} else {
+				expected = strings.TrimSpace(git(t, dir, "rev-parse", "--short", "HEAD"))
+			}
+
+			v := VersionFromGit(dir)
+			if v == nil || v.String() != expected {
+				t.Errorf("expected version %s, got %v", expected, v)
+			}
+		})
+	}
+}
+
+func TestGitVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, dir string)
+		version string
+		wantErr string
+	}{
+		{
+			name: "tag",
+			setup: func(t *testing.T, dir string) {
+				initGitRepo(t, dir, map[string]string{"README": "readme"})
+				git(t, dir, "tag", "v1.2.3")
+			},
+			version: "v1.2.3",
+		},
+		{
+			name: "no repository",
+			setup: func(t *testing.T, dir string) {
+				t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(dir))
+			},
+			wantErr: "not a git repository",
+		},
+		{
+			name: "no commit",
+			setup: func(t *testing.T, dir string) {
+				git(t, dir, "init", "-q")
+			},
+			wantErr: "exit status",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			test.setup(t, dir)
+			v, err := GitVersion(dir)
+			checkError(t, err, test.wantErr)
+			if err == nil && v.String() != test.version {
+				t.Errorf("expected version %s, got %s", test.version, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+package make
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VetTargetName is the name of the VetTarget.
+const VetTargetName = "vet"
+
+// Diagnostic is a single finding of a static analysis tool.
+type Diagnostic struct {
+	File     string
+	Line     int
+	Column   int
+	Analyzer string
+	Message  string
+	// Platforms contains all Platforms for which the finding
+	// was reported.
+	Platforms PlatformSet
+}
+
+func (d *Diagnostic) key() string {
+	return fmt.Sprintf("%s:%d:%d:%s:%s", d.File, d.Line, d.Column, d.Analyzer, d.Message)
+}
+
+func (d *Diagnostic) String() string {
+	platforms := make([]string, len(d.Platforms))
+	for i, p := range d.Platforms {
+		platforms[i] = p.String()
+	}
+	return fmt.Sprintf("%s:%d:%d: %s: %s [%s]", d.File, d.Line, d.Column, d.Analyzer, d.Message, strings.Join(platforms, ", "))
+}
+
+// DiagnosticsError is returned by the VetTarget if any
+// Diagnostics were found.
+type DiagnosticsError struct {
+	Diagnostics []*Diagnostic
+}
+
+func (e *DiagnosticsError) Error() string {
+	text := fmt.Sprintf("%d problems found:", len(e.Diagnostics))
+	for _, d := range e.Diagnostics {
+		text += "\n" + d.String()
+	}
+	return text
+}
+
+// VetTarget runs "go vet" and optionally "staticcheck" and
+// "golangci-lint" for each supported Platform of the Suite.
+// Findings are de-duplicated across all platforms and returned
+// as a *DiagnosticsError.
+type VetTarget struct {
+	// Packages to analyse. Defaults to "./...".
+	Packages []string
+	// Platforms to analyse. Defaults to the SupportedPlatforms
+	// of the Suite.
+	Platforms PlatformSet
+
+	// If Staticcheck is true "staticcheck" is run as well if
+	// it is installed.
+	Staticcheck bool
+	// If GolangciLint is true "golangci-lint" is run as well if
+	// it is installed.
+	GolangciLint bool
+
+	// Where to redirect output of the tools that could not be
+	// parsed and their warnings. If nil it will be redirected to
+	// this precesses stderr.
+	Stderr io.Writer
+}
+
+type analysisTool struct {
+	args []string
+	// If stderrResults is true the results are parsed from
+	// stderr if stdout is empty, as older versions of "go vet"
+	// write them there.
+	stderrResults bool
+	parse         func(output []byte) ([]*Diagnostic, error)
+}
+
+// Execute runs the analysis tools.
+func (t *VetTarget) Execute(suite *Suite) error {
+	platforms := t.Platforms
+	if len(platforms) == 0 {
+		platforms = suite.SupportedPlatforms
+	}
+	platforms = vetPlatforms(platforms)
+
+	tools := t.tools()
+	diagnostics := make(map[string]*Diagnostic)
+	for _, platform := range platforms {
+		fmt.Println("Vetting platform:", platform)
+		for _, tool := range tools {
+			diags, err := t.run(tool, platform)
+			if err != nil {
+				return err
+			}
+			for _, d := range diags {
+				if existing, ok := diagnostics[d.key()]; ok {
+					existing.Platforms = append(existing.Platforms, platform)
+					continue
+				}
+				d.Platforms = PlatformSet{platform}
+				diagnostics[d.key()] = d
+			}
+		}
+	}
+
+	if len(diagnostics) == 0 {
+		return nil
+	}
+
+	err := &DiagnosticsError{Diagnostics: make([]*Diagnostic, 0, len(diagnostics))}
+	for _, d := range diagnostics {
+		err.Diagnostics = append(err.Diagnostics, d)
+	}
+	sort.Slice(err.Diagnostics, func(i, j int) bool {
+		a, b := err.Diagnostics[i], err.Diagnostics[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return err
+}
+
+// vetPlatforms returns the platforms with universal binaries
+// replaced by the darwin architectures they contain, as there
+// is no GOARCH for them.
+func vetPlatforms(platforms PlatformSet) PlatformSet {
+	ret := make(PlatformSet, 0, len(platforms))
+	for _, p := range platforms {
+		expanded := PlatformSet{p}
+		if p.Arch == Universal {
+			expanded = universalInputPlatforms
+		}
+		for _, e := range expanded {
+			if ok, _ := ret.Contains(e); !ok {
+				ret = append(ret, e)
+			}
+		}
+	}
+	return ret
+}
+
+func (t *VetTarget) tools() []*analysisTool {
+	tools := []*analysisTool{
+		{args: []string{"go", "vet", "-json"}, stderrResults: true, parse: parseVetOutput},
+	}
+	if t.Staticcheck {
+		if _, err := exec.LookPath("staticcheck"); err == nil {
+			tools = append(tools, &analysisTool{args: []string{"staticcheck", "-f", "json"}, parse: parseStaticcheckOutput})
+		}
+	}
+	if t.GolangciLint {
+		if _, err := exec.LookPath("golangci-lint"); err == nil {
+			tools = append(tools, &analysisTool{args: golangciLintArgs(), parse: parseGolangciLintOutput})
+		}
+	}
+	return tools
+}
+
+// golangciLintArgs returns the command printing the issues as JSON
+// to stdout. The flag changed with golangci-lint v2.
+func golangciLintArgs() []string {
+	out, _ := exec.Command("golangci-lint", "--version").Output()
+	if golangciLintMajorVersion(string(out)) >= 2 {
+		return []string{"golangci-lint", "run", "--output.json.path", "stdout", "--show-stats=false"}
+	}
+	return []string{"golangci-lint", "run", "--out-format", "json"}
+}
+
+// golangciLintMajorVersion returns the major version from the output
+// of "golangci-lint --version", e. g. "golangci-lint has version
+// v1.55.2 built with ...", or 0 if it is unknown.
+func golangciLintMajorVersion(versionOutput string) int {
+	fields := strings.Fields(versionOutput)
+	for i, field := range fields {
+		if field != "version" || i+1 == len(fields) {
+			continue
+		}
+		major := strings.SplitN(strings.TrimPrefix(fields[i+1], "v"), ".", 2)[0]
+		if n, err := strconv.Atoi(major); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+func (t *VetTarget) run(tool *analysisTool, platform *Platform) ([]*Diagnostic, error) {
+	packages := t.Packages
+	if len(packages) == 0 {
+		packages = []string{"./..."}
+	}
+
+	args := append(append([]string{}, tool.args[1:]...), packages...)
+	cmd := exec.Command(tool.args[0], args...)
+	output := &bytes.Buffer{}
+	other := &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = output, other
+	cmd.Env = os.Environ()
+	cmd.Env = setEnv(cmd.Env, "GOOS", platform.OS.String())
+	cmd.Env = setEnv(cmd.Env, "GOARCH", platform.Arch.String())
+
+	runErr := cmd.Run()
+	if tool.stderrResults && len(bytes.TrimSpace(output.Bytes())) == 0 {
+		output, other = other, output
+	}
+	stderr := t.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	stderr.Write(other.Bytes())
+	diags, err := tool.parse(output.Bytes())
+	if err != nil || (runErr != nil && len(diags) == 0) {
+		stderr.Write(output.Bytes())
+		return nil, fmt.Errorf("error running %s for platform %s: %v", tool.args[0], platform, runErr)
+	}
+
+	for _, d := range diags {
+		d.File = relativePath(d.File)
+	}
+	return diags, nil
+}
+
+// parseVetOutput parses the output of "go vet -json", which
+// consists of JSON objects optionally preceded by "# package" lines.
+func parseVetOutput(output []byte) ([]*Diagnostic, error) {
+	filtered := &bytes.Buffer{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if !strings.HasPrefix(scanner.Text(), "#") {
+			filtered.Write(scanner.Bytes())
+			filtered.WriteByte('\n')
+		}
+	}
+
+	diags := make([]*Diagnostic, 0)
+	decoder := json.NewDecoder(filtered)
+	for decoder.More() {
+		var result map[string]map[string]json.RawMessage
+		if err := decoder.Decode(&result); err != nil {
+			return nil, err
+		}
+		for _, analyzers := range result {
+			for analyzer, raw := range analyzers {
+				var findings []struct {
+					Posn    string `json:"posn"`
+					Message string `json:"message"`
+				}
+				if err := json.Unmarshal(raw, &findings); err != nil {
+					var failure struct {
+						Error string `json:"error"`
+					}
+					json.Unmarshal(raw, &failure)
+					return nil, fmt.Errorf("%s: %s", analyzer, failure.Error)
+				}
+				for _, finding := range findings {
+					d := parsePosition(finding.Posn)
+					d.Analyzer = analyzer
+					d.Message = finding.Message
+					diags = append(diags, d)
+				}
+			}
+		}
+	}
+	return diags, nil
+}
+
+// parseStaticcheckOutput parses the output of "staticcheck -f json",
+// which consists of one JSON object per line.
+func parseStaticcheckOutput(output []byte) ([]*Diagnostic, error) {
+	diags := make([]*Diagnostic, 0)
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for decoder.More() {
+		var finding struct {
+			Code     string `json:"code"`
+			Message  string `json:"message"`
+			Location struct {
+				File   string `json:"file"`
+				Line   int    `json:"line"`
+				Column int    `json:"column"`
+			} `json:"location"`
+		}
+		if err := decoder.Decode(&finding); err != nil {
+			return nil, err
+		}
+		diags = append(diags, &Diagnostic{
+			File:     finding.Location.File,
+			Line:     finding.Location.Line,
+			Column:   finding.Location.Column,
+			Analyzer: "staticcheck:" + finding.Code,
+			Message:  finding.Message,
+		})
+	}
+	return diags, nil
+}
+
+// parseGolangciLintOutput parses the output of "golangci-lint run"
+// with JSON output. Lines preceding the JSON object, e. g. warnings,
+// and anything following it are ignored.
+func parseGolangciLintOutput(output []byte) ([]*Diagnostic, error) {
+	if !bytes.HasPrefix(output, []byte("{")) {
+		if start := bytes.Index(output, []byte("\n{")); start >= 0 {
+			output = output[start+1:]
+		}
+	}
+
+	var result struct {
+		Issues []struct {
+			FromLinter string
+			Text       string
+			Pos        struct {
+				Filename string
+				Line     int
+				Column   int
+			}
+		}
+	}
+	if err := json.NewDecoder(bytes.NewReader(output)).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	diags := make([]*Diagnostic, len(result.Issues))
+	for i, issue := range result.Issues {
+		diags[i] = &Diagnostic{
+			File:     issue.Pos.Filename,
+			Line:     issue.Pos.Line,
+			Column:   issue.Pos.Column,
+			Analyzer: "golangci-lint:" + issue.FromLinter,
+			Message:  issue.Text,
+		}
+	}
+	return diags, nil
+}
+
+// parsePosition parses a position of the form "file:line:column".
+func parsePosition(posn string) *Diagnostic {
+	d := &Diagnostic{File: posn}
+	parts := strings.Split(posn, ":")
+	if len(parts) < 3 {
+		return d
+	}
+	line, err1 := strconv.Atoi(parts[len(parts)-2])
+	column, err2 := strconv.Atoi(parts[len(parts)-1])
+	if err1 != nil || err2 != nil {
+		return d
+	}
+	d.File = strings.Join(parts[:len(parts)-2], ":")
+	d.Line = line
+	d.Column = column
+	return d
+}
+
+// relativePath returns the path relative to the current working
+// directory if possible.
+func relativePath(path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
+}
+
+// Name returns the name of this Target.
+func (t *VetTarget) Name() string {
+	return VetTargetName
+}
+
+// Description returns the description of this Target.
+func (t *VetTarget) Description() string {
+	return "Runs go vet and other static analysis tools for all platforms."
+}
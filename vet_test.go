@@ -0,0 +1,195 @@
+package make
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGolangciLintMajorVersion(t *testing.T) {
+	tests := []struct {
+		output string
+		major  int
+	}{
+		{"golangci-lint has version v1.55.2 built with go1.21.3 from e3c2265 on 2023-11-03T12:59:25Z\n", 1},
+		{"golangci-lint has version 2.1.6 built with go1.24.2 from eabc2638 on 2025-05-04T15:41:19Z\n", 2},
+		{"golangci-lint has version v2.0.0-beta.1\n", 2},
+		{"", 0},
+		{"unexpected output", 0},
+	}
+	for _, test := range tests {
+		if major := golangciLintMajorVersion(test.output); major != test.major {
+			t.Errorf("%q: expected major version %d, got %d", test.output, test.major, major)
+		}
+	}
+}
+
+func TestParseAnalysisOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		parse   func([]byte) ([]*Diagnostic, error)
+		output  string
+		diags   []*Diagnostic
+		wantErr string
+	}{
+		{
+			name:  "go vet",
+			parse: parseVetOutput,
+			output: `# example.com/p
+{
+	"example.com/p": {
+		"printf": [
+			{
+				"posn": "/src/p/p.go:5:2",
+				"message": "fmt.Printf format %d has arg \"a\" of wrong type string"
+			}
+		]
+	}
+}
+# example.com/q
+{}
+`,
+			diags: []*Diagnostic{{File: "/src/p/p.go", Line: 5, Column: 2, Analyzer: "printf", Message: "fmt.Printf format %d has arg \"a\" of wrong type string"}},
+		},
+		{
+			name:    "go vet error",
+			parse:   parseVetOutput,
+			output:  `{"example.com/p": {"printf": {"error": "analysis failed"}}}`,
+			wantErr: "printf: analysis failed",
+		},
+		{
+			name:  "staticcheck",
+			parse: parseStaticcheckOutput,
+			output: `{"code":"S1000","severity":"error","location":{"file":"/src/p/p.go","line":3,"column":1},"message":"should use for range"}
+{"code":"U1000","severity":"error","location":{"file":"/src/p/q.go","line":7,"column":6},"message":"func f is unused"}
+`,
+			diags: []*Diagnostic{
+				{File: "/src/p/p.go", Line: 3, Column: 1, Analyzer: "staticcheck:S1000", Message: "should use for range"},
+				{File: "/src/p/q.go", Line: 7, Column: 6, Analyzer: "staticcheck:U1000", Message: "func f is unused"},
+			},
+		},
+		{
+			name:   "golangci-lint v1",
+			parse:  parseGolangciLintOutput,
+			output: `{"Issues":[{"FromLinter":"errcheck","Text":"Error return value is not checked","Pos":{"Filename":"p.go","Line":9,"Column":12}}],"Report":{}}`,
+			diags:  []*Diagnostic{{File: "p.go", Line: 9, Column: 12, Analyzer: "golangci-lint:errcheck", Message: "Error return value is not checked"}},
+		},
+		{
+			name:  "golangci-lint v2 with warnings",
+			parse: parseGolangciLintOutput,
+			output: `level=warning msg="[config_reader] The configuration option is deprecated"
+{"Issues":[{"FromLinter":"unused","Text":"func f is unused","Pos":{"Filename":"q.go","Line":7,"Column":6}}],"Report":{}}
+1 issues:
+* unused: 1
+`,
+			diags: []*Diagnostic{{File: "q.go", Line: 7, Column: 6, Analyzer: "golangci-lint:unused", Message: "func f is unused"}},
+		},
+		{
+			name:    "golangci-lint failure",
+			parse:   parseGolangciLintOutput,
+			output:  "Error: unknown flag: --out-format\n",
+			wantErr: "invalid character",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diags, err := test.parse([]byte(test.output))
+			checkError(t, err, test.wantErr)
+			if err == nil && !reflect.DeepEqual(diags, test.diags) {
+				t.Errorf("expected %v, got %v", test.diags, diags)
+			}
+		})
+	}
+}
+
+func TestParsePosition(t *testing.T) {
+	tests := []struct {
+		posn string
+		diag *Diagnostic
+	}{
+		{"p.go:1:2", &Diagnostic{File: "p.go", Line: 1, Column: 2}},
+		{`C:\src\p.go:10:4`, &Diagnostic{File: `C:\src\p.go`, Line: 10, Column: 4}},
+		{"p.go", &Diagnostic{File: "p.go"}},
+		{"p.go:a:b", &Diagnostic{File: "p.go:a:b"}},
+	}
+	for _, test := range tests {
+		if diag := parsePosition(test.posn); !reflect.DeepEqual(diag, test.diag) {
+			t.Errorf("%s: expected %v, got %v", test.posn, test.diag, diag)
+		}
+	}
+}
+
+func TestVetTargetDeduplicatesPlatforms(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": "module example.com/vet\n\ngo 1.16\n",
+		"p.go":   "package p\n\nimport \"fmt\"\n\nfunc F() {\n\tfmt.Printf(\"%d\", \"a\")\n}\n",
+	})
+	chdir(t, dir)
+
+	target := &VetTarget{Platforms: PlatformSet{LinuxAmd64, WindowsAmd64}, Stderr: ioutil.Discard}
+	err := target.Execute(NewBuildSuite(nil))
+	diagErr, ok := err.(*DiagnosticsError)
+	if !ok {
+		t.Fatalf("expected a DiagnosticsError, got %v", err)
+	}
+	if len(diagErr.Diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", diagErr)
+	}
+	d := diagErr.Diagnostics[0]
+	if d.File != "p.go" || d.Line != 6 || d.Analyzer != "printf" {
+		t.Errorf("unexpected diagnostic %s", d)
+	}
+	if !reflect.DeepEqual(d.Platforms, PlatformSet{LinuxAmd64, WindowsAmd64}) {
+		t.Errorf("expected both platforms, got %v", d.Platforms)
+	}
+}
+
+func TestVetPlatforms(t *testing.T) {
+	tests := []struct {
+		platforms PlatformSet
+		expected  PlatformSet
+	}{
+		{platforms: PlatformSet{LinuxAmd64}, expected: PlatformSet{LinuxAmd64}},
+		{platforms: PlatformSet{LinuxAmd64, DarwinUniversal}, expected: PlatformSet{LinuxAmd64, DarwinAmd64, DarwinArm64}},
+		{platforms: PlatformSet{DarwinArm64, DarwinUniversal}, expected: PlatformSet{DarwinArm64, DarwinAmd64}},
+	}
+	for _, test := range tests {
+		if platforms := vetPlatforms(test.platforms); !reflect.DeepEqual(platforms, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.platforms, test.expected, platforms)
+		}
+	}
+}
+
+func TestVetTargetStaticcheckWarnings(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": "module example.com/vet\n\ngo 1.16\n",
+		"p.go":   "package p\n\nfunc F() {}\n",
+	})
+	chdir(t, dir)
+	fakeCommand(t, "staticcheck", `echo "warning: something is odd" >&2
+echo '{"code":"U1000","message":"func F is unused","location":{"file":"p.go","line":3,"column":6}}'
+exit 1
+`)
+
+	stderr := &bytes.Buffer{}
+	target := &VetTarget{Platforms: PlatformSet{DarwinUniversal}, Staticcheck: true, Stderr: stderr}
+	err := target.Execute(NewBuildSuite(nil))
+	diagErr, ok := err.(*DiagnosticsError)
+	if !ok {
+		t.Fatalf("expected a DiagnosticsError, got %v", err)
+	}
+	if len(diagErr.Diagnostics) != 1 || diagErr.Diagnostics[0].Analyzer != "staticcheck:U1000" {
+		t.Fatalf("unexpected diagnostics %v", diagErr)
+	}
+	if platforms := diagErr.Diagnostics[0].Platforms; !reflect.DeepEqual(platforms, PlatformSet{DarwinAmd64, DarwinArm64}) {
+		t.Errorf("expected the darwin architectures, got %v", platforms)
+	}
+	if !strings.Contains(stderr.String(), "warning: something is odd") {
+		t.Errorf("expected the warning on stderr, got %q", stderr)
+	}
+}
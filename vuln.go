@@ -0,0 +1,429 @@
+package make
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// VulnTargetName is the name of the VulnTarget.
+const VulnTargetName = "vuln"
+
+// Severity represents the severity of a vulnerability.
+type Severity int
+
+const (
+	// SeverityUnknown represents a vulnerability without
+	// severity information.
+	SeverityUnknown Severity = iota
+	// SeverityLow represents a vulnerability of low severity.
+	SeverityLow
+	// SeverityMedium represents a vulnerability of medium severity.
+	SeverityMedium
+	// SeverityHigh represents a vulnerability of high severity.
+	SeverityHigh
+	// SeverityCritical represents a vulnerability of critical severity.
+	SeverityCritical
+)
+
+// ParseSeverity checks the text and returns an equivalent Severity if possible.
+func ParseSeverity(text string) (Severity, error) {
+	switch strings.ToLower(text) {
+	case "unknown", "":
+		return SeverityUnknown, nil
+	case "low":
+		return SeverityLow, nil
+	case "medium", "moderate":
+		return SeverityMedium, nil
+	case "high":
+		return SeverityHigh, nil
+	case "critical":
+		return SeverityCritical, nil
+	}
+	return SeverityUnknown, fmt.Errorf("invalid severity \"%s\"", text)
+}
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityLow:
+		return "low"
+	case SeverityMedium:
+		return "medium"
+	case SeverityHigh:
+		return "high"
+	case SeverityCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+// Vulnerability is a called vulnerability found by govulncheck.
+type Vulnerability struct {
+	ID       string
+	Aliases  []string
+	Summary  string
+	Severity Severity
+	// Module is the module containing the vulnerable code.
+	Module       string
+	FixedVersion string
+	// Trace is a human readable call stack leading to the
+	// vulnerable function.
+	Trace []string
+	// Source is the scanned package pattern or binary.
+	Source string
+}
+
+func (v *Vulnerability) String() string {
+	text := fmt.Sprintf("%s (%s) in %s: %s", v.ID, v.Severity, v.Module, v.Summary)
+	if len(v.Aliases) > 0 {
+		text += " [" + strings.Join(v.Aliases, ", ") + "]"
+	}
+	if v.FixedVersion != "" {
+		text += ", fixed in " + v.FixedVersion
+	}
+	for _, frame := range v.Trace {
+		text += "\n    " + frame
+	}
+	return text
+}
+
+// VulnerabilityError is returned by the VulnTarget if
+// vulnerabilities were found.
+type VulnerabilityError struct {
+	Vulnerabilities []*Vulnerability
+}
+
+func (e *VulnerabilityError) Error() string {
+	text := fmt.Sprintf("%d vulnerabilities found:", len(e.Vulnerabilities))
+	for _, v := range e.Vulnerabilities {
+		text += "\n" + v.String()
+	}
+	return text
+}
+
+// VulnTarget runs govulncheck against a locally mirrored
+// vulnerability database and fails if called vulnerabilities
+// are found.
+type VulnTarget struct {
+	// DB is the directory containing the mirrored vulnerability
+	// database.
+	DB string
+
+	// Packages to scan. Defaults to "./...". Ignored if Binaries
+	// is not empty.
+	Packages []string
+	// Binaries to scan instead of the source code. Each output
+	// file must already exist.
+	Binaries []OutputTarget
+
+	// MinSeverity is the minimum severity of vulnerabilities that
+	// make the target fail. Vulnerabilities of unknown severity
+	// always make the target fail.
+	MinSeverity Severity
+	// AllowlistFile is the optional path to a file containing
+	// the IDs or aliases of accepted vulnerabilities, one per line.
+	// Everything following a "#" is a comment.
+	AllowlistFile string
+
+	// Where to redirect govulncheck's stderr. If nil stderr
+	// will be redirected to this precesses stderr.
+	Stderr io.Writer
+}
+
+// Execute runs govulncheck.
+func (t *VulnTarget) Execute(suite *Suite) error {
+	db, err := filepath.Abs(t.DB)
+	if err != nil {
+		return err
+	}
+	dbURL := "file://" + filepath.ToSlash(db)
+	if !strings.HasPrefix(dbURL, "file:///") {
+		dbURL = "file:///" + strings.TrimPrefix(dbURL, "file://")
+	}
+
+	allowlist, err := t.readAllowlist()
+	if err != nil {
+		return err
+	}
+
+	scans := make([][]string, 0)
+	if len(t.Binaries) > 0 {
+		for _, binary := range t.Binaries {
+			scans = append(scans, []string{"-mode", "binary", binary.OutputName()})
+		}
+	} else {
+		packages := t.Packages
+		if len(packages) == 0 {
+			packages = []string{"./..."}
+		}
+		scans = append(scans, packages)
+	}
+
+	vulns := make([]*Vulnerability, 0)
+	for _, scan := range scans {
+		found, err := t.scan(dbURL, scan)
+		if err != nil {
+			return err
+		}
+		for _, v := range found {
+			if v.Severity != SeverityUnknown && v.Severity < t.MinSeverity {
+				continue
+			}
+			if isAllowed(v, allowlist) {
+				fmt.Println("Ignoring allowed vulnerability:", v.ID)
+				continue
+			}
+			vulns = append(vulns, v)
+		}
+	}
+
+	if len(vulns) > 0 {
+		return &VulnerabilityError{Vulnerabilities: vulns}
+	}
+	return nil
+}
+
+type govulncheckMessage struct {
+	OSV *struct {
+		ID       string   `json:"id"`
+		Aliases  []string `json:"aliases"`
+		Summary  string   `json:"summary"`
+		Severity []struct {
+			Type  string `json:"type"`
+			Score string `json:"score"`
+		} `json:"severity"`
+		DatabaseSpecific struct {
+			Severity string `json:"severity"`
+		} `json:"database_specific"`
+	} `json:"osv"`
+	Finding *struct {
+		OSV          string `json:"osv"`
+		FixedVersion string `json:"fixed_version"`
+		Trace        []struct {
+			Module   string `json:"module"`
+			Package  string `json:"package"`
+			Function string `json:"function"`
+			Receiver string `json:"receiver"`
+			Position *struct {
+				Filename string `json:"filename"`
+				Line     int    `json:"line"`
+			} `json:"position"`
+		} `json:"trace"`
+	} `json:"finding"`
+}
+
+func (t *VulnTarget) scan(dbURL string, args []string) ([]*Vulnerability, error) {
+	source := strings.Join(args, " ")
+	fmt.Println("Scanning for vulnerabilities:", source)
+
+	args = append([]string{"-json", "-db", dbURL}, args...)
+	cmd := exec.Command("govulncheck", args...)
+	if t.Stderr != nil {
+		cmd.Stderr = t.Stderr
+	} else {
+		cmd.Stderr = os.Stderr
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running govulncheck: %v", err)
+	}
+
+	entries := make(map[string]*Vulnerability)
+	vulns := make([]*Vulnerability, 0)
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	for decoder.More() {
+		var msg govulncheckMessage
+		if err := decoder.Decode(&msg); err != nil {
+			return nil, fmt.Errorf("could not parse govulncheck output: %v", err)
+		}
+
+		if msg.OSV != nil {
+			v := &Vulnerability{
+				ID:       msg.OSV.ID,
+				Aliases:  msg.OSV.Aliases,
+				Summary:  msg.OSV.Summary,
+				Severity: SeverityUnknown,
+				Source:   source,
+			}
+			if s, err := ParseSeverity(msg.OSV.DatabaseSpecific.Severity); err == nil {
+				v.Severity = s
+			}
+			for _, s := range msg.OSV.Severity {
+				if strings.HasPrefix(s.Type, "CVSS_V3") {
+					if score, err := cvss3BaseScore(s.Score); err == nil && severityFromScore(score) > v.Severity {
+						v.Severity = severityFromScore(score)
+					}
+				}
+			}
+			entries[v.ID] = v
+		}
+
+		// Only findings with a function in the innermost frame
+		// are actually called.
+		finding := msg.Finding
+		if finding == nil || len(finding.Trace) == 0 || finding.Trace[0].Function == "" {
+			continue
+		}
+		entry, ok := entries[finding.OSV]
+		if !ok {
+			entry = &Vulnerability{ID: finding.OSV, Source: source}
+			entries[finding.OSV] = entry
+		}
+		if entry.Module != "" {
+			// Already reported with a different call stack.
+			continue
+		}
+		entry.Module = finding.Trace[0].Module
+		entry.FixedVersion = finding.FixedVersion
+		for _, frame := range finding.Trace {
+			function := frame.Function
+			if frame.Receiver != "" {
+				function = frame.Receiver + "." + function
+			}
+			text := frame.Package + "." + function
+			if frame.Position != nil {
+				text += fmt.Sprintf(" (%s:%d)", frame.Position.Filename, frame.Position.Line)
+			}
+			entry.Trace = append(entry.Trace, text)
+		}
+		vulns = append(vulns, entry)
+	}
+
+	sort.Slice(vulns, func(i, j int) bool {
+		return vulns[i].ID < vulns[j].ID
+	})
+	return vulns, nil
+}
+
+func (t *VulnTarget) readAllowlist() (map[string]bool, error) {
+	allowlist := make(map[string]bool)
+	if t.AllowlistFile == "" {
+		return allowlist, nil
+	}
+
+	f, err := os.Open(t.AllowlistFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line != "" {
+			allowlist[line] = true
+		}
+	}
+	return allowlist, scanner.Err()
+}
+
+func isAllowed(v *Vulnerability, allowlist map[string]bool) bool {
+	if allowlist[v.ID] {
+		return true
+	}
+	for _, alias := range v.Aliases {
+		if allowlist[alias] {
+			return true
+		}
+	}
+	return false
+}
+
+func severityFromScore(score float64) Severity {
+	switch {
+	case score >= 9:
+		return SeverityCritical
+	case score >= 7:
+		return SeverityHigh
+	case score >= 4:
+		return SeverityMedium
+	case score > 0:
+		return SeverityLow
+	}
+	return SeverityUnknown
+}
+
+// cvss3BaseScore computes the base score of a CVSS v3 vector
+// like "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H".
+func cvss3BaseScore(vector string) (float64, error) {
+	weights := map[string]map[string]float64{
+		"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+		"AC": {"L": 0.77, "H": 0.44},
+		"PR": {"N": 0.85, "L": 0.62, "H": 0.27},
+		"UI": {"N": 0.85, "R": 0.62},
+		"C":  {"H": 0.56, "L": 0.22, "N": 0},
+		"I":  {"H": 0.56, "L": 0.22, "N": 0},
+		"A":  {"H": 0.56, "L": 0.22, "N": 0},
+	}
+
+	metrics := make(map[string]string)
+	for _, part := range strings.Split(vector, "/") {
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) == 2 {
+			metrics[kv[0]] = kv[1]
+		}
+	}
+
+	values := make(map[string]float64)
+	for metric, options := range weights {
+		value, ok := options[metrics[metric]]
+		if !ok {
+			return 0, fmt.Errorf("invalid CVSS vector \"%s\"", vector)
+		}
+		values[metric] = value
+	}
+
+	changed := metrics["S"] == "C"
+	if changed && metrics["PR"] == "L" {
+		values["PR"] = 0.68
+	} else if changed && metrics["PR"] == "H" {
+		values["PR"] = 0.5
+	}
+
+	iss := 1 - (1-values["C"])*(1-values["I"])*(1-values["A"])
+	var impact float64
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	} else {
+		impact = 6.42 * iss
+	}
+	if impact <= 0 {
+		return 0, nil
+	}
+
+	exploitability := 8.22 * values["AV"] * values["AC"] * values["PR"] * values["UI"]
+	if changed {
+		return cvssRoundUp(math.Min(1.08*(impact+exploitability), 10)), nil
+	}
+	return cvssRoundUp(math.Min(impact+exploitability, 10)), nil
+}
+
+func cvssRoundUp(value float64) float64 {
+	i := int(math.Round(value * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return float64(i/10000+1) / 10
+}
+
+// Name returns the name of this Target.
+func (t *VulnTarget) Name() string {
+	return VulnTargetName
+}
+
+// Description returns the description of this Target.
+func (t *VulnTarget) Description() string {
+	return "Scans for known vulnerabilities using govulncheck."
+}
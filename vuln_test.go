@@ -0,0 +1,188 @@
+package make
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCVSS3BaseScore(t *testing.T) {
+	tests := []struct {
+		vector  string
+		score   float64
+		wantErr string
+	}{
+		{vector: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", score: 9.8},
+		{vector: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H", score: 10},
+		{vector: "CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:H", score: 7.8},
+		{vector: "CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N", score: 6.1},
+		{vector: "CVSS:3.0/AV:N/AC:L/PR:L/UI:N/S:C/C:H/I:N/A:N", score: 7.7},
+		{vector: "CVSS:3.1/AV:N/AC:L/PR:H/UI:N/S:C/C:L/I:L/A:N", score: 5.5},
+		{vector: "CVSS:3.1/AV:P/AC:H/PR:H/UI:R/S:U/C:L/I:N/A:N", score: 1.6},
+		{vector: "CVSS:3.1/AV:N/AC:H/PR:N/UI:N/S:U/C:N/I:N/A:N", score: 0},
+		{vector: "CVSS:3.1/AV:X/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", wantErr: "invalid CVSS vector"},
+		{vector: "CVSS:3.1/AV:N", wantErr: "invalid CVSS vector"},
+	}
+	for _, test := range tests {
+		score, err := cvss3BaseScore(test.vector)
+		checkError(t, err, test.wantErr)
+		if score != test.score {
+			t.Errorf("%s: expected score %.1f, got %.1f", test.vector, test.score, score)
+		}
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	tests := []struct {
+		score    float64
+		severity Severity
+	}{
+		{0, SeverityUnknown},
+		{0.1, SeverityLow},
+		{3.9, SeverityLow},
+		{4, SeverityMedium},
+		{7, SeverityHigh},
+		{9, SeverityCritical},
+		{10, SeverityCritical},
+	}
+	for _, test := range tests {
+		if severity := severityFromScore(test.score); severity != test.severity {
+			t.Errorf("%.1f: expected %s, got %s", test.score, test.severity, severity)
+		}
+	}
+
+	for _, text := range []string{"low", "MODERATE", "medium", "High", "critical", "unknown"} {
+		severity, err := ParseSeverity(text)
+		if err != nil {
+			t.Errorf("%s: %v", text, err)
+		}
+		if parsed, _ := ParseSeverity(severity.String()); parsed != severity {
+			t.Errorf("%s does not survive a round trip", severity)
+		}
+	}
+	if _, err := ParseSeverity("severe"); err == nil {
+		t.Errorf("expected an error for an invalid severity")
+	}
+}
+
+// govulncheckOutput contains a called critical vulnerability,
+// an imported but not called one and a called low one.
+const govulncheckOutput = `{"config":{"scanner_name":"govulncheck"}}
+{"osv":{"id":"GO-2024-0001","aliases":["CVE-2024-0001"],"summary":"Remote code execution","severity":[{"type":"CVSS_V3","score":"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"}]}}
+{"osv":{"id":"GO-2024-0002","summary":"Not called","database_specific":{"severity":"HIGH"}}}
+{"osv":{"id":"GO-2024-0003","summary":"Minor leak","database_specific":{"severity":"LOW"}}}
+{"finding":{"osv":"GO-2024-0001","fixed_version":"v1.2.3","trace":[{"module":"example.com/dep","package":"example.com/dep","function":"Parse","receiver":"*Parser","position":{"filename":"parser.go","line":10}},{"module":"example.com/app","package":"example.com/app","function":"main"}]}}
+{"finding":{"osv":"GO-2024-0001","trace":[{"module":"example.com/dep","package":"example.com/dep","function":"Parse"},{"module":"example.com/app","package":"example.com/app","function":"other"}]}}
+{"finding":{"osv":"GO-2024-0002","trace":[{"module":"example.com/dep","package":"example.com/dep"}]}}
+{"finding":{"osv":"GO-2024-0003","trace":[{"module":"example.com/other","package":"example.com/other","function":"Leak"}]}}
+`
+
+// fakeCommand puts an executable shell script with the given
+// name and body first in the PATH for the rest of the test.
+func fakeCommand(t *testing.T, name, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestVulnTarget(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "output.json")
+	if err := ioutil.WriteFile(output, []byte(govulncheckOutput), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fakeCommand(t, "govulncheck", "cat "+output+"\n")
+
+	tests := []struct {
+		name      string
+		target    *VulnTarget
+		allowlist string
+		ids       []string
+	}{
+		{
+			name:   "called vulnerabilities",
+			target: &VulnTarget{},
+			ids:    []string{"GO-2024-0001", "GO-2024-0003"},
+		},
+		{
+			name:   "minimum severity",
+			target: &VulnTarget{MinSeverity: SeverityMedium},
+			ids:    []string{"GO-2024-0001"},
+		},
+		{
+			name:      "allowlisted alias",
+			target:    &VulnTarget{},
+			allowlist: "# accepted risks\nCVE-2024-0001 # no untrusted input\n",
+			ids:       []string{"GO-2024-0003"},
+		},
+		{
+			name:      "nothing left",
+			target:    &VulnTarget{MinSeverity: SeverityHigh},
+			allowlist: "GO-2024-0001\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.target.DB = t.TempDir()
+			if test.allowlist != "" {
+				test.target.AllowlistFile = filepath.Join(t.TempDir(), "allowlist")
+				if err := ioutil.WriteFile(test.target.AllowlistFile, []byte(test.allowlist), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := test.target.Execute(NewBuildSuite(nil))
+			if test.ids == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			vulnErr, ok := err.(*VulnerabilityError)
+			if !ok {
+				t.Fatalf("expected a VulnerabilityError, got %v", err)
+			}
+			ids := make([]string, len(vulnErr.Vulnerabilities))
+			for i, v := range vulnErr.Vulnerabilities {
+				ids[i] = v.ID
+			}
+			if !reflect.DeepEqual(ids, test.ids) {
+				t.Errorf("expected %v, got %v", test.ids, ids)
+			}
+		})
+	}
+}
+
+func TestVulnTargetFinding(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "output.json")
+	if err := ioutil.WriteFile(output, []byte(govulncheckOutput), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fakeCommand(t, "govulncheck", "cat "+output+"\n")
+
+	vulns, err := (&VulnTarget{}).scan("file:///db", []string{"./..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Vulnerability{
+		ID:           "GO-2024-0001",
+		Aliases:      []string{"CVE-2024-0001"},
+		Summary:      "Remote code execution",
+		Severity:     SeverityCritical,
+		Module:       "example.com/dep",
+		FixedVersion: "v1.2.3",
+		Trace: []string{
+			"example.com/dep.*Parser.Parse (parser.go:10)",
+			"example.com/app.main",
+		},
+		Source: "./...",
+	}
+	if len(vulns) == 0 || !reflect.DeepEqual(vulns[0], expected) {
+		t.Errorf("expected %v, got %v", expected, vulns)
+	}
+}
@@ -0,0 +1,442 @@
+package make
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"unicode/utf16"
+
+	"github.com/hashicorp/go-version"
+)
+
+// Execution levels of a Windows application manifest.
+const (
+	ExecutionLevelAsInvoker            = "asInvoker"
+	ExecutionLevelHighestAvailable     = "highestAvailable"
+	ExecutionLevelRequireAdministrator = "requireAdministrator"
+)
+
+// WindowsResources describes the resources embedded into
+// Windows executables. The VERSIONINFO is filled from the
+// Version of the BuildTarget.
+type WindowsResources struct {
+	// IconFile is the optional path of an .ico file.
+	IconFile string
+
+	// ExecutionLevel is the requested execution level of the
+	// application manifest. Defaults to ExecutionLevelAsInvoker.
+	ExecutionLevel string
+	// ManifestFile is the optional path of an application
+	// manifest replacing the generated one.
+	ManifestFile string
+
+	CompanyName      string
+	FileDescription  string
+	ProductName      string
+	LegalCopyright   string
+	InternalName     string
+	OriginalFilename string
+}
+
+// Resource types and the language used for all resources.
+const (
+	rtIcon      = 3
+	rtGroupIcon = 14
+	rtVersion   = 16
+	rtManifest  = 24
+
+	langEnUS         = 0x0409
+	codePageUnicode  = 0x04b0
+	versionSignature = 0xfeef04bd
+)
+
+type coffMachine struct {
+	machine        uint16
+	characteristic uint16
+	relocType      uint16
+}
+
+var coffMachines = map[Arch]coffMachine{
+	X386:  {machine: 0x14c, characteristic: 0x0104, relocType: 0x07},
+	Amd64: {machine: 0x8664, characteristic: 0x0004, relocType: 0x03},
+	Arm:   {machine: 0x1c4, characteristic: 0x0104, relocType: 0x02},
+	Arm64: {machine: 0xaa64, characteristic: 0x0004, relocType: 0x02},
+}
+
+// sysoName returns the name of the resource file for the given
+// Platform and binary. The suffix makes sure that go build only
+// picks it up for that Platform.
+func sysoName(p *Platform, binary string) string {
+	if binary != "" {
+		return fmt.Sprintf("zz_gomake_rsrc_%s_%s_%s.syso", binary, p.OS, p.Arch)
+	}
+	return fmt.Sprintf("zz_gomake_rsrc_%s_%s.syso", p.OS, p.Arch)
+}
+
+// writeSyso writes the resource object file for the given Platform
+// and binary to dir and returns its path.
+func (r *WindowsResources) writeSyso(dir string, p *Platform, binary string, v Version, filename string) (string, error) {
+	machine, ok := coffMachines[p.Arch]
+	if !ok {
+		return "", fmt.Errorf("windows resources are not supported for %s", p)
+	}
+
+	resources, err := r.resources(v, filename)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, sysoName(p, binary))
+	err = ioutil.WriteFile(path, encodeCOFF(machine, resources), 0644)
+	return path, err
+}
+
+type resource struct {
+	typeID uint16
+	id     uint16
+	data   []byte
+}
+
+func (r *WindowsResources) resources(v Version, filename string) ([]*resource, error) {
+	resources := make([]*resource, 0)
+
+	if r.IconFile != "" {
+		icons, err := iconResources(r.IconFile)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, icons...)
+	}
+
+	manifest, err := r.manifest()
+	if err != nil {
+		return nil, err
+	}
+	resources = append(resources, &resource{typeID: rtManifest, id: 1, data: manifest})
+
+	resources = append(resources, &resource{typeID: rtVersion, id: 1, data: r.versionInfo(v, filename)})
+	return resources, nil
+}
+
+func (r *WindowsResources) manifest() ([]byte, error) {
+	if r.ManifestFile != "" {
+		return ioutil.ReadFile(r.ManifestFile)
+	}
+
+	level := r.ExecutionLevel
+	if level == "" {
+		level = ExecutionLevelAsInvoker
+	}
+	switch level {
+	case ExecutionLevelAsInvoker, ExecutionLevelHighestAvailable, ExecutionLevelRequireAdministrator:
+	default:
+		return nil, fmt.Errorf("invalid execution level \"%s\"", level)
+	}
+
+	return []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<assembly xmlns="urn:schemas-microsoft-com:asm.v1" manifestVersion="1.0">
+  <trustInfo xmlns="urn:schemas-microsoft-com:asm.v3">
+    <security>
+      <requestedPrivileges>
+        <requestedExecutionLevel level="` + level + `" uiAccess="false"/>
+      </requestedPrivileges>
+    </security>
+  </trustInfo>
+  <compatibility xmlns="urn:schemas-microsoft-com:compatibility.v1">
+    <application>
+      <supportedOS Id="{35138b9a-5d96-4fbd-8e2d-a2440225f93a}"/>
+      <supportedOS Id="{4a2f28e3-53b9-4441-ba9c-d69d4a4a6e38}"/>
+      <supportedOS Id="{1f676c76-80e1-4239-95bb-83d0f6d0da78}"/>
+      <supportedOS Id="{8e0f7a12-bfb3-4fe8-b9a5-48fd50a15a9a}"/>
+    </application>
+  </compatibility>
+</assembly>
+`), nil
+}
+
+// iconResources converts an .ico file into one RT_ICON resource
+// per image and a RT_GROUP_ICON resource referencing them.
+func iconResources(filename string) ([]*resource, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 6 || binary.LittleEndian.Uint16(data[2:]) != 1 {
+		return nil, fmt.Errorf("%s is not an icon file", filename)
+	}
+
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	if len(data) < 6+16*count {
+		return nil, fmt.Errorf("%s is truncated", filename)
+	}
+
+	group := &bytes.Buffer{}
+	group.Write(data[:6])
+
+	resources := make([]*resource, 0, count+1)
+	for i := 0; i < count; i++ {
+		entry := data[6+16*i : 6+16*(i+1)]
+		size := binary.LittleEndian.Uint32(entry[8:])
+		offset := binary.LittleEndian.Uint32(entry[12:])
+		if uint64(offset)+uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("%s is truncated", filename)
+		}
+
+		id := uint16(i + 1)
+		resources = append(resources, &resource{typeID: rtIcon, id: id, data: data[offset : offset+size]})
+
+		// GRPICONDIRENTRY equals ICONDIRENTRY but the offset
+		// is replaced by the 16 bit resource id.
+		group.Write(entry[:12])
+		binary.Write(group, binary.LittleEndian, id)
+	}
+
+	return append(resources, &resource{typeID: rtGroupIcon, id: 1, data: group.Bytes()}), nil
+}
+
+// versionInfo encodes a VS_VERSIONINFO structure.
+func (r *WindowsResources) versionInfo(v Version, filename string) []byte {
+	segments := []int{0, 0, 0, 0}
+	versionString := ""
+	if v != nil {
+		versionString = v.String()
+		if parsed, err := version.NewVersion(versionString); err == nil {
+			copy(segments, parsed.Segments())
+		}
+	}
+	ms := uint32(segments[0])<<16 | uint32(segments[1])&0xffff
+	ls := uint32(segments[2])<<16 | uint32(segments[3])&0xffff
+
+	fixed := &bytes.Buffer{}
+	for _, value := range []uint32{
+		versionSignature, 0x00010000,
+		ms, ls, // file version
+		ms, ls, // product version
+		0x3f, 0, // flags mask and flags
+		0x00040004, // VOS_NT_WINDOWS32
+		0x1,        // VFT_APP
+		0, 0, 0,    // subtype and date
+	} {
+		binary.Write(fixed, binary.LittleEndian, value)
+	}
+
+	originalFilename := r.OriginalFilename
+	if originalFilename == "" {
+		originalFilename = filename
+	}
+	strs := map[string]string{
+		"CompanyName":      r.CompanyName,
+		"FileDescription":  r.FileDescription,
+		"FileVersion":      versionString,
+		"InternalName":     r.InternalName,
+		"LegalCopyright":   r.LegalCopyright,
+		"OriginalFilename": originalFilename,
+		"ProductName":      r.ProductName,
+		"ProductVersion":   versionString,
+	}
+	keys := make([]string, 0, len(strs))
+	for key, value := range strs {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	stringNodes := make([][]byte, len(keys))
+	for i, key := range keys {
+		value := utf16String(strs[key])
+		stringNodes[i] = versionNode(key, 1, value, uint16(len(value)/2), nil)
+	}
+	stringTable := versionNode(fmt.Sprintf("%04x%04x", langEnUS, codePageUnicode), 1, nil, 0, stringNodes)
+	stringFileInfo := versionNode("StringFileInfo", 1, nil, 0, [][]byte{stringTable})
+
+	translation := &bytes.Buffer{}
+	binary.Write(translation, binary.LittleEndian, []uint16{langEnUS, codePageUnicode})
+	varNode := versionNode("Translation", 0, translation.Bytes(), uint16(translation.Len()), nil)
+	varFileInfo := versionNode("VarFileInfo", 1, nil, 0, [][]byte{varNode})
+
+	return versionNode("VS_VERSION_INFO", 0, fixed.Bytes(), uint16(fixed.Len()), [][]byte{stringFileInfo, varFileInfo})
+}
+
+// versionNode encodes a node of a VS_VERSIONINFO structure.
+// Children are expected to be 32 bit aligned.
+func versionNode(key string, valueType uint16, value []byte, valueLength uint16, children [][]byte) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, []uint16{0, valueLength, valueType})
+	buf.Write(utf16String(key))
+	pad(buf, 4)
+	buf.Write(value)
+	for _, child := range children {
+		pad(buf, 4)
+		buf.Write(child)
+	}
+
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint16(data, uint16(len(data)))
+	return data
+}
+
+// utf16String encodes a null terminated UTF-16LE string.
+func utf16String(text string) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, append(utf16.Encode([]rune(text)), 0))
+	return buf.Bytes()
+}
+
+func pad(buf *bytes.Buffer, alignment int) {
+	for buf.Len()%alignment != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+// encodeCOFF encodes the resources as a COFF object file with
+// a single .rsrc section.
+func encodeCOFF(machine coffMachine, resources []*resource) []byte {
+	section, relocations := encodeResourceSection(resources)
+
+	const fileHeaderSize, sectionHeaderSize = 20, 40
+	const relocationSize = 10
+	rawDataOffset := uint32(fileHeaderSize + sectionHeaderSize)
+	relocationsOffset := rawDataOffset + uint32(len(section))
+	symbolsOffset := relocationsOffset + uint32(relocationSize*len(relocations))
+
+	buf := &bytes.Buffer{}
+	le := binary.LittleEndian
+
+	// File header.
+	binary.Write(buf, le, machine.machine)
+	binary.Write(buf, le, uint16(1)) // number of sections
+	binary.Write(buf, le, uint32(0)) // time date stamp
+	binary.Write(buf, le, symbolsOffset)
+	binary.Write(buf, le, uint32(1)) // number of symbols
+	binary.Write(buf, le, uint16(0)) // size of optional header
+	binary.Write(buf, le, machine.characteristic)
+
+	// Section header.
+	buf.WriteString(".rsrc\x00\x00\x00")
+	binary.Write(buf, le, uint32(0)) // virtual size
+	binary.Write(buf, le, uint32(0)) // virtual address
+	binary.Write(buf, le, uint32(len(section)))
+	binary.Write(buf, le, rawDataOffset)
+	binary.Write(buf, le, relocationsOffset)
+	binary.Write(buf, le, uint32(0)) // pointer to line numbers
+	binary.Write(buf, le, uint16(len(relocations)))
+	binary.Write(buf, le, uint16(0))          // number of line numbers
+	binary.Write(buf, le, uint32(0x40000040)) // initialized data, readable
+
+	buf.Write(section)
+
+	for _, offset := range relocations {
+		binary.Write(buf, le, offset)
+		binary.Write(buf, le, uint32(0)) // symbol table index
+		binary.Write(buf, le, machine.relocType)
+	}
+
+	// Section symbol of .rsrc.
+	buf.WriteString(".rsrc\x00\x00\x00")
+	binary.Write(buf, le, uint32(0)) // value
+	binary.Write(buf, le, int16(1))  // section number
+	binary.Write(buf, le, uint16(0)) // type
+	buf.WriteByte(3)                 // storage class static
+	buf.WriteByte(0)                 // number of aux symbols
+
+	// Empty string table.
+	binary.Write(buf, le, uint32(4))
+
+	return buf.Bytes()
+}
+
+// encodeResourceSection encodes the resource directory tree followed
+// by the data entries and the data. It returns the section and the
+// offsets of all data addresses that need to be relocated.
+func encodeResourceSection(resources []*resource) ([]byte, []uint32) {
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].typeID != resources[j].typeID {
+			return resources[i].typeID < resources[j].typeID
+		}
+		return resources[i].id < resources[j].id
+	})
+
+	types := make([]uint16, 0)
+	byType := make(map[uint16][]*resource)
+	for _, r := range resources {
+		if _, ok := byType[r.typeID]; !ok {
+			types = append(types, r.typeID)
+		}
+		byType[r.typeID] = append(byType[r.typeID], r)
+	}
+
+	const directorySize, entrySize, dataEntrySize = 16, 8, 16
+	const subdirectory = 0x80000000
+
+	// Compute the offsets of all directories, data entries and data.
+	typeDirSize := uint32(directorySize + entrySize*len(types))
+	nameDirOffsets := make(map[uint16]uint32)
+	offset := typeDirSize
+	for _, typeID := range types {
+		nameDirOffsets[typeID] = offset
+		offset += uint32(directorySize + entrySize*len(byType[typeID]))
+	}
+	langDirOffsets := make([]uint32, len(resources))
+	for i := range resources {
+		langDirOffsets[i] = offset
+		offset += directorySize + entrySize
+	}
+	dataEntryOffsets := make([]uint32, len(resources))
+	for i := range resources {
+		dataEntryOffsets[i] = offset
+		offset += dataEntrySize
+	}
+	dataOffsets := make([]uint32, len(resources))
+	for i, r := range resources {
+		offset = (offset + 7) &^ 7
+		dataOffsets[i] = offset
+		offset += uint32(len(r.data))
+	}
+
+	buf := &bytes.Buffer{}
+	le := binary.LittleEndian
+	writeDirectory := func(idEntries int) {
+		binary.Write(buf, le, []uint32{0, 0})                 // characteristics, time date stamp
+		binary.Write(buf, le, []uint16{0, 0})                 // version
+		binary.Write(buf, le, []uint16{0, uint16(idEntries)}) // named and id entries
+	}
+
+	writeDirectory(len(types))
+	for _, typeID := range types {
+		binary.Write(buf, le, []uint32{uint32(typeID), subdirectory | nameDirOffsets[typeID]})
+	}
+
+	i := 0
+	for _, typeID := range types {
+		writeDirectory(len(byType[typeID]))
+		for _, r := range byType[typeID] {
+			binary.Write(buf, le, []uint32{uint32(r.id), subdirectory | langDirOffsets[i]})
+			i++
+		}
+	}
+
+	for i := range resources {
+		writeDirectory(1)
+		binary.Write(buf, le, []uint32{langEnUS, dataEntryOffsets[i]})
+	}
+
+	relocations := make([]uint32, len(resources))
+	for i, r := range resources {
+		relocations[i] = uint32(buf.Len())
+		binary.Write(buf, le, []uint32{dataOffsets[i], uint32(len(r.data)), 0, 0})
+	}
+
+	for i, r := range resources {
+		for uint32(buf.Len()) < dataOffsets[i] {
+			buf.WriteByte(0)
+		}
+		buf.Write(r.data)
+	}
+	pad(buf, 8)
+
+	return buf.Bytes(), relocations
+}
@@ -0,0 +1,213 @@
+package make
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lookupResource walks the resource directory of an unlinked .rsrc
+// section and returns the data of the given resource.
+func lookupResource(t *testing.T, section []byte, typeID, id uint16) []byte {
+	t.Helper()
+	le := binary.LittleEndian
+	lookup := func(dir uint32, key uint32) uint32 {
+		count := uint32(le.Uint16(section[dir+14:]))
+		for i := uint32(0); i < count; i++ {
+			entry := dir + 16 + 8*i
+			if le.Uint32(section[entry:]) == key {
+				return le.Uint32(section[entry+4:]) &^ 0x80000000
+			}
+		}
+		t.Fatalf("resource %d/%d not found", typeID, id)
+		return 0
+	}
+
+	dataEntry := lookup(lookup(lookup(0, uint32(typeID)), uint32(id)), langEnUS)
+	offset := le.Uint32(section[dataEntry:])
+	size := le.Uint32(section[dataEntry+4:])
+	return section[offset : offset+size]
+}
+
+func TestWindowsResourcesWriteSyso(t *testing.T) {
+	tests := []struct {
+		platform *Platform
+		machine  uint16
+		wantErr  string
+	}{
+		{platform: WindowsX386, machine: pe.IMAGE_FILE_MACHINE_I386},
+		{platform: WindowsAmd64, machine: pe.IMAGE_FILE_MACHINE_AMD64},
+		{platform: &Platform{OS: Windows, Arch: Arm, Extension: ".exe"}, machine: pe.IMAGE_FILE_MACHINE_ARMNT},
+		{platform: &Platform{OS: Windows, Arch: Arm64, Extension: ".exe"}, machine: pe.IMAGE_FILE_MACHINE_ARM64},
+		{platform: &Platform{OS: Windows, Arch: Mips}, wantErr: "windows resources are not supported"},
+	}
+
+	resources := &WindowsResources{ProductName: "App", ExecutionLevel: ExecutionLevelRequireAdministrator}
+	for _, test := range tests {
+		t.Run(test.platform.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			path, err := resources.writeSyso(dir, test.platform, "", BasicVersion("1.2.3"), "app.exe")
+			checkError(t, err, test.wantErr)
+			if err != nil {
+				return
+			}
+			if filepath.Base(path) != sysoName(test.platform, "") {
+				t.Errorf("unexpected syso name %s", path)
+			}
+
+			file, err := pe.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			if file.Machine != test.machine {
+				t.Errorf("expected machine %#x, got %#x", test.machine, file.Machine)
+			}
+			section := file.Section(".rsrc")
+			if section == nil || len(file.Sections) != 1 {
+				t.Fatalf("expected a single .rsrc section, got %v", file.Sections)
+			}
+			if len(section.Relocs) != 2 {
+				t.Errorf("expected a relocation per resource, got %d", len(section.Relocs))
+			}
+			data, err := section.Data()
+			if err != nil {
+				t.Fatal(err)
+			}
+			manifest := lookupResource(t, data, rtManifest, 1)
+			if !bytes.Contains(manifest, []byte(`level="requireAdministrator"`)) {
+				t.Errorf("unexpected manifest\n%s", manifest)
+			}
+			versionInfo := lookupResource(t, data, rtVersion, 1)
+			if !bytes.Contains(versionInfo, utf16String("App")) {
+				t.Errorf("expected the product name in the version info")
+			}
+		})
+	}
+}
+
+func TestWindowsResourcesVersionInfo(t *testing.T) {
+	tests := []struct {
+		version Version
+		ms, ls  uint32
+		strs    []string
+	}{
+		{version: BasicVersion("1.2.3"), ms: 0x00010002, ls: 0x00030000, strs: []string{"1.2.3", "app.exe"}},
+		{version: BasicVersion("1.2.3.4"), ms: 0x00010002, ls: 0x00030004, strs: []string{"1.2.3.4"}},
+		{version: BasicVersion("dev"), strs: []string{"dev"}},
+		{version: nil, strs: []string{"app.exe"}},
+	}
+	for _, test := range tests {
+		info := (&WindowsResources{}).versionInfo(test.version, "app.exe")
+		if int(binary.LittleEndian.Uint16(info)) != len(info) {
+			t.Errorf("%v: length %d does not match the data", test.version, binary.LittleEndian.Uint16(info))
+		}
+
+		// The fixed file info follows the header and the padded key.
+		fixed := info[6+len(utf16String("VS_VERSION_INFO"))+2:]
+		if binary.LittleEndian.Uint32(fixed) != versionSignature {
+			t.Fatalf("%v: missing fixed file info signature", test.version)
+		}
+		if ms, ls := binary.LittleEndian.Uint32(fixed[8:]), binary.LittleEndian.Uint32(fixed[12:]); ms != test.ms || ls != test.ls {
+			t.Errorf("%v: expected file version %08x %08x, got %08x %08x", test.version, test.ms, test.ls, ms, ls)
+		}
+		for _, str := range test.strs {
+			if !bytes.Contains(info, utf16String(str)) {
+				t.Errorf("%v: expected %q in the version info", test.version, str)
+			}
+		}
+	}
+}
+
+func TestWindowsResourcesManifest(t *testing.T) {
+	manifestFile := filepath.Join(t.TempDir(), "app.manifest")
+	if err := ioutil.WriteFile(manifestFile, []byte("<assembly/>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		resources *WindowsResources
+		contains  string
+		wantErr   string
+	}{
+		{resources: &WindowsResources{}, contains: `level="asInvoker"`},
+		{resources: &WindowsResources{ExecutionLevel: ExecutionLevelHighestAvailable}, contains: `level="highestAvailable"`},
+		{resources: &WindowsResources{ExecutionLevel: "root"}, wantErr: "invalid execution level"},
+		{resources: &WindowsResources{ManifestFile: manifestFile, ExecutionLevel: "ignored"}, contains: "<assembly/>"},
+	}
+	for _, test := range tests {
+		manifest, err := test.resources.manifest()
+		checkError(t, err, test.wantErr)
+		if !strings.Contains(string(manifest), test.contains) {
+			t.Errorf("expected %q in the manifest, got\n%s", test.contains, manifest)
+		}
+	}
+}
+
+// icon returns an .ico file containing one image per data slice.
+func icon(images ...string) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, []uint16{0, 1, uint16(len(images))})
+	offset := 6 + 16*len(images)
+	for _, image := range images {
+		buf.Write([]byte{16, 16, 0, 0})
+		binary.Write(buf, binary.LittleEndian, []uint16{1, 32})
+		binary.Write(buf, binary.LittleEndian, []uint32{uint32(len(image)), uint32(offset)})
+		offset += len(image)
+	}
+	for _, image := range images {
+		buf.WriteString(image)
+	}
+	return buf.Bytes()
+}
+
+func TestIconResources(t *testing.T) {
+	truncated := icon("image")
+	tests := []struct {
+		name    string
+		data    []byte
+		images  []string
+		wantErr string
+	}{
+		{name: "single", data: icon("image"), images: []string{"image"}},
+		{name: "multiple", data: icon("small", "large"), images: []string{"small", "large"}},
+		{name: "not an icon", data: []byte("PNG image"), wantErr: "is not an icon file"},
+		{name: "truncated directory", data: icon("image")[:10], wantErr: "is truncated"},
+		{name: "truncated image", data: truncated[:len(truncated)-1], wantErr: "is truncated"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "app.ico")
+			if err := ioutil.WriteFile(filename, test.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			resources, err := iconResources(filename)
+			checkError(t, err, test.wantErr)
+			if err != nil {
+				return
+			}
+
+			if len(resources) != len(test.images)+1 {
+				t.Fatalf("expected %d resources, got %d", len(test.images)+1, len(resources))
+			}
+			for i, image := range test.images {
+				if resources[i].typeID != rtIcon || resources[i].id != uint16(i+1) || string(resources[i].data) != image {
+					t.Errorf("unexpected icon resource %d: %+v", i, resources[i])
+				}
+			}
+			group := resources[len(resources)-1]
+			if group.typeID != rtGroupIcon || len(group.data) != 6+14*len(test.images) {
+				t.Errorf("unexpected group icon resource %+v", group)
+			}
+			if id := binary.LittleEndian.Uint16(group.data[6+12:]); id != 1 {
+				t.Errorf("expected the group to reference icon 1, got %d", id)
+			}
+		})
+	}
+}